@@ -0,0 +1,176 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriteBatcherConfig configures a WriteBatcher.
+type WriteBatcherConfig struct {
+	MaxItems int             // Number of buffered sets that triggers a flush; zero means no limit.
+	MaxBytes int             // Size of the buffered commands in bytes that triggers a flush; zero means no limit.
+	Interval time.Duration   // Interval of the periodic flush; zero disables it.
+	OnError  func(err error) // Called with the errors reported for flushed sets; nil ignores them.
+}
+
+// WriteBatcher buffers "set" commands sent with noreply and writes them in batches for write-heavy ingest.
+// A batch is flushed when MaxItems or MaxBytes is reached, every Interval, on Flush and on Close.
+// Since noreply suppresses the responses of successful commands, every batch ends with a meta no-op ("mn")
+// whose "MN" response marks the end of the error lines of the batch; those errors are passed to OnError.
+// This requires a server supporting meta commands, and cannot be used behind twemproxy, which closes the connection on "mn".
+// A WriteBatcher is safe for concurrent use.
+type WriteBatcher struct {
+	client *Client
+	config WriteBatcherConfig
+
+	mu      sync.Mutex
+	pending map[*Server][]byte // Buffered commands per server.
+	items   int                // Number of buffered sets.
+	size    int                // Size of the buffered commands in bytes.
+	closed  bool
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewWriteBatcher returns a new WriteBatcher that writes through the client.
+// It returns ErrInvalidOption if a limit or the interval is negative.
+func (c *Client) NewWriteBatcher(config WriteBatcherConfig) (b *WriteBatcher, err error) {
+	if config.MaxItems < 0 || config.MaxBytes < 0 || config.Interval < 0 {
+		err = ErrInvalidOption
+		return
+	}
+	b = &WriteBatcher{
+		client:  c,
+		config:  config,
+		pending: make(map[*Server][]byte),
+		stop:    make(chan struct{}),
+	}
+	if config.Interval > 0 {
+		b.wg.Add(1)
+		go b.run()
+	}
+	return
+}
+
+// run flushes the batcher every interval until it is closed.
+func (b *WriteBatcher) run() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.Flush()
+		}
+	}
+}
+
+// Set buffers a "set" command with noreply to store a key-value pair.
+// Errors of the command itself are reported later through OnError; Set only returns errors that prevent buffering it,
+// such as ErrUnsupportedByProxy behind a proxy that cannot pass the barrier, and ErrClosed once the batcher is closed.
+func (b *WriteBatcher) Set(key, value string, expiration int) (err error) {
+	key, err = b.client.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := b.client.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	if err = server.checkProxy("mn"); err != nil {
+		return
+	}
+	// set <key> <flags> <exptime> <bytes> noreply\r\n<data>\r\n
+	command := fmt.Sprintf("set %s 0 %d %d noreply\r\n%s\r\n", key, expiration, len(value), value)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		err = ErrClosed
+		return
+	}
+	b.pending[server] = append(b.pending[server], command...)
+	b.items++
+	b.size += len(command)
+	full := (b.config.MaxItems > 0 && b.items >= b.config.MaxItems) || (b.config.MaxBytes > 0 && b.size >= b.config.MaxBytes)
+	b.mu.Unlock()
+	if full {
+		b.Flush()
+	}
+	return
+}
+
+// Flush writes the buffered commands, one batch per server in parallel, and waits for the servers to process them.
+func (b *WriteBatcher) Flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[*Server][]byte)
+	b.items = 0
+	b.size = 0
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for server, commands := range pending {
+		wg.Add(1)
+		go func(server *Server, commands []byte) {
+			defer wg.Done()
+			for _, err := range flushNoReply(server, commands) {
+				if b.config.OnError != nil {
+					b.config.OnError(fmt.Errorf("%s: %w", server.Address, err))
+				}
+			}
+		}(server, commands)
+	}
+	wg.Wait()
+}
+
+// Close stops the periodic flush and flushes the remaining buffered commands.
+// Sets after Close return ErrClosed; closing an already closed batcher is a no-op.
+func (b *WriteBatcher) Close() {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		b.closed = true
+		b.mu.Unlock()
+		close(b.stop)
+		b.wg.Wait()
+		b.Flush()
+	})
+}
+
+// flushNoReply writes noreply commands followed by a meta no-op barrier and collects the error lines
+// the server sends before the "MN" response of the barrier.
+func flushNoReply(server *Server, commands []byte) (errs []error) {
+	// mn\r\n
+	commands = append(commands, "mn\r\n"...)
+	err := server.do(commands, func(reader *bufio.Reader) error {
+		for {
+			line, err := readLine(reader)
+			if err != nil {
+				return err
+			}
+			switch {
+			case line == "MN":
+				return nil
+			case line == "ERROR":
+				// Commands of the batch fail with CLIENT_ERROR or SERVER_ERROR, so a bare ERROR is the unknown barrier.
+				return ErrMetaUnsupported
+			case strings.HasPrefix(line, "CLIENT_ERROR bad data chunk"):
+				errs = append(errs, ErrBadDataChunk)
+			default:
+				errs = append(errs, errors.Join(ErrStoreFailed, errors.New(line)))
+			}
+		}
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return
+}
@@ -0,0 +1,369 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Magic bytes and header length of the binary protocol.
+const (
+	binaryMagicRequest  byte = 0x80
+	binaryMagicResponse byte = 0x81
+	binaryHeaderLength       = 24
+)
+
+// Binary protocol opcodes.
+const (
+	opGet      byte = 0x00
+	opSet      byte = 0x01
+	opAdd      byte = 0x02
+	opReplace  byte = 0x03
+	opDelete   byte = 0x04
+	opNoop     byte = 0x0a
+	opVersion  byte = 0x0b
+	opGetK     byte = 0x0c
+	opGetKQ    byte = 0x0d
+	opGAT      byte = 0x1d
+	opSASLAuth byte = 0x21
+)
+
+// Binary protocol response status codes.
+const (
+	statusNoError        uint16 = 0x0000
+	statusKeyNotFound    uint16 = 0x0001
+	statusKeyExists      uint16 = 0x0002
+	statusValueTooLarge  uint16 = 0x0003
+	statusInvalidArgs    uint16 = 0x0004
+	statusItemNotStored  uint16 = 0x0005
+	statusAuthError      uint16 = 0x0020
+	statusAuthContinue   uint16 = 0x0021
+	statusUnknownCommand uint16 = 0x0081
+)
+
+// binaryRequest is a binary protocol request packet.
+type binaryRequest struct {
+	opcode byte
+	key    string
+	extras []byte
+	value  []byte
+	opaque uint32
+	cas    uint64
+}
+
+// appendTo appends the encoded packet, a 24-byte header followed by extras, key and value, to b.
+func (r *binaryRequest) appendTo(b []byte) []byte {
+	var header [binaryHeaderLength]byte
+	header[0] = binaryMagicRequest
+	header[1] = r.opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(r.key)))
+	header[4] = byte(len(r.extras))
+	// header[5] is the data type and header[6:8] the vbucket id, both unused.
+	binary.BigEndian.PutUint32(header[8:], uint32(len(r.extras)+len(r.key)+len(r.value)))
+	binary.BigEndian.PutUint32(header[12:], r.opaque)
+	binary.BigEndian.PutUint64(header[16:], r.cas)
+	b = append(b, header[:]...)
+	b = append(b, r.extras...)
+	b = append(b, r.key...)
+	return append(b, r.value...)
+}
+
+// binaryResponse is a binary protocol response packet.
+type binaryResponse struct {
+	opcode byte
+	status uint16
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+// readBinaryResponse reads a response packet.
+func readBinaryResponse(reader *bufio.Reader) (res *binaryResponse, err error) {
+	var header [binaryHeaderLength]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	if header[0] != binaryMagicResponse {
+		err = ErrUnexpectedResponse
+		return
+	}
+	keyLength := int(binary.BigEndian.Uint16(header[2:]))
+	extrasLength := int(header[4])
+	bodyLength := int(binary.BigEndian.Uint32(header[8:]))
+	if extrasLength+keyLength > bodyLength {
+		err = ErrUnexpectedResponse
+		return
+	}
+	body := make([]byte, bodyLength)
+	if _, err = io.ReadFull(reader, body); err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	res = &binaryResponse{
+		opcode: header[1],
+		status: binary.BigEndian.Uint16(header[6:]),
+		opaque: binary.BigEndian.Uint32(header[12:]),
+		cas:    binary.BigEndian.Uint64(header[16:]),
+		extras: body[:extrasLength],
+		key:    body[extrasLength : extrasLength+keyLength],
+		value:  body[extrasLength+keyLength:],
+	}
+	return
+}
+
+// err maps the status of a response to an error, following the errors of the equivalent text protocol commands.
+func (res *binaryResponse) err() error {
+	switch res.status {
+	case statusNoError:
+		return nil
+	case statusKeyNotFound:
+		return ErrNotFound
+	case statusKeyExists:
+		return errors.Join(ErrStoreFailed, ErrExists)
+	case statusItemNotStored:
+		return errors.Join(ErrStoreFailed, ErrNotStored)
+	case statusValueTooLarge, statusInvalidArgs:
+		return ErrStoreFailed
+	case statusUnknownCommand:
+		return ErrUnsupportedCommand
+	}
+	return ErrUnexpectedResponse
+}
+
+// item converts a response to a retrieval request into an Item.
+// The key is taken from the response for GetK and GetKQ, and from key otherwise.
+func (res *binaryResponse) item(key string) (item *Item, err error) {
+	if len(res.extras) < 4 {
+		err = ErrUnexpectedResponse
+		return
+	}
+	if len(res.key) > 0 {
+		key = string(res.key)
+	}
+	item = &Item{
+		Key:   key,
+		Value: res.value,
+		Flags: binary.BigEndian.Uint32(res.extras),
+		CAS:   res.cas,
+	}
+	return
+}
+
+// binaryRoundTrip sends a single request and reads its response.
+func (s *Server) binaryRoundTrip(req *binaryRequest) (res *binaryResponse, err error) {
+	err = s.roundTrip(req.appendTo(nil), func(reader *bufio.Reader) (err error) {
+		res, err = readBinaryResponse(reader)
+		return
+	})
+	return
+}
+
+// binaryCall is a binary Get request of a caller waiting for its response.
+type binaryCall struct {
+	key  string
+	item *Item
+	err  error
+	done chan struct{} // Closed once item and err are set.
+}
+
+// binaryQueue collects the binary Get requests of concurrent callers.
+type binaryQueue struct {
+	mu    sync.Mutex
+	calls []*binaryCall
+}
+
+// push queues a request.
+func (q *binaryQueue) push(call *binaryCall) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.calls = append(q.calls, call)
+}
+
+// remove removes a request that is still queued, and reports whether it was.
+func (q *binaryQueue) remove(call *binaryCall) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, c := range q.calls {
+		if c == call {
+			q.calls = slices.Delete(q.calls, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// drain removes and returns every queued request.
+func (q *binaryQueue) drain() (calls []*binaryCall) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	calls, q.calls = q.calls, nil
+	return
+}
+
+// binaryGet retrieves the item stored under the given key, including its flags and CAS token.
+// It returns ErrNotFound if the key does not exist.
+//
+// Concurrent calls share connections: while the connections are busy, callers queue their requests,
+// and the next caller to acquire a connection sends every queued request in one pipelined write.
+// Each request carries its index in the batch as opaque, so responses are matched to their callers by opaque.
+func (s *Server) binaryGet(key string) (item *Item, err error) {
+	call := &binaryCall{key: key, done: make(chan struct{})}
+	s.gets.push(call)
+
+	conn, err := s.acquire()
+	if err != nil {
+		// A request still queued was never sent; otherwise another caller sent it and sets its outcome.
+		if s.gets.remove(call) {
+			return
+		}
+		<-call.done
+		return call.item, call.err
+	}
+	if calls := s.gets.drain(); len(calls) > 0 {
+		err = s.sendGets(conn, calls)
+	}
+	s.release(conn, &err)
+	// The request may have been sent in the batch of another caller, possibly on another connection.
+	<-call.done
+	return call.item, call.err
+}
+
+// sendGets sends the queued Get requests in one pipelined write and hands every response to its caller.
+func (s *Server) sendGets(conn *Conn, calls []*binaryCall) (err error) {
+	var packet []byte
+	for i, c := range calls {
+		packet = (&binaryRequest{opcode: opGet, key: c.key, opaque: uint32(i)}).appendTo(packet)
+	}
+	err = s.exchange(conn, packet, func(reader *bufio.Reader) error {
+		// Every Get request is answered, hits and misses alike.
+		for range calls {
+			res, err := readBinaryResponse(reader)
+			if err != nil {
+				return err
+			}
+			if int(res.opaque) >= len(calls) || res.opcode != opGet {
+				return ErrUnexpectedResponse
+			}
+			c := calls[res.opaque]
+			select {
+			case <-c.done:
+				// A second response for the same request.
+				return ErrUnexpectedResponse
+			default:
+			}
+			if c.err = res.err(); c.err == nil {
+				c.item, c.err = res.item(c.key)
+			}
+			close(c.done)
+		}
+		return nil
+	})
+	// Requests left unanswered fail with the error of the round trip.
+	for _, c := range calls {
+		select {
+		case <-c.done:
+		default:
+			c.err = err
+			close(c.done)
+		}
+	}
+	return
+}
+
+// binaryGetAndTouch retrieves the item stored under the given key and updates its expiration time with a GAT request.
+// It returns ErrNotFound if the key does not exist.
+func (s *Server) binaryGetAndTouch(key string, expiration int) (item *Item, err error) {
+	extras := make([]byte, 4)
+	binary.BigEndian.PutUint32(extras, uint32(expiration))
+	res, err := s.binaryRoundTrip(&binaryRequest{opcode: opGAT, key: key, extras: extras})
+	if err != nil {
+		return
+	}
+	if err = res.err(); err != nil {
+		return
+	}
+	return res.item(key)
+}
+
+// binaryStore stores the item with a Set, Add or Replace request.
+// A non-zero CAS token in the item makes the request fail if the item was modified since it was read.
+func (s *Server) binaryStore(opcode byte, item *Item) (err error) {
+	extras := make([]byte, 8)
+	binary.BigEndian.PutUint32(extras, item.Flags)
+	binary.BigEndian.PutUint32(extras[4:], uint32(item.Expiration))
+	res, err := s.binaryRoundTrip(&binaryRequest{
+		opcode: opcode,
+		key:    item.Key,
+		extras: extras,
+		value:  item.Value,
+		cas:    item.CAS,
+	})
+	if err != nil {
+		return
+	}
+	err = res.err()
+	switch {
+	case errors.Is(err, ErrNotFound) && item.CAS != 0:
+		// A CAS of a missing key fails like NOT_FOUND in the text protocol.
+		err = errors.Join(ErrStoreFailed, ErrCASNotFound)
+	case errors.Is(err, ErrNotFound), opcode == opAdd && errors.Is(err, ErrExists):
+		// Replacing a missing key and adding an existing one are answered with statuses of their own, but fail like NOT_STORED.
+		err = errors.Join(ErrStoreFailed, ErrNotStored)
+	}
+	return
+}
+
+// binaryDelete removes the given key.
+func (s *Server) binaryDelete(key string) (err error) {
+	res, err := s.binaryRoundTrip(&binaryRequest{opcode: opDelete, key: key})
+	if err != nil {
+		return
+	}
+	if err = res.err(); errors.Is(err, ErrNotFound) {
+		// Deleting a missing key fails like NOT_FOUND in the text protocol.
+		err = ErrStoreFailed
+	}
+	return
+}
+
+// binaryGetMulti retrieves the items of the given keys in one round trip.
+// It sends a quiet GetKQ request per key, for which the server only answers hits, followed by a Noop request
+// whose response marks the end of the batch. Keys that do not exist are absent from the result.
+func (s *Server) binaryGetMulti(ctx context.Context, keys []string) (items []*Item, err error) {
+	var packet []byte
+	for i, key := range keys {
+		packet = (&binaryRequest{opcode: opGetKQ, key: key, opaque: uint32(i)}).appendTo(packet)
+	}
+	packet = (&binaryRequest{opcode: opNoop, opaque: uint32(len(keys))}).appendTo(packet)
+	err = s.roundTripContext(ctx, packet, func(reader *bufio.Reader) error {
+		for {
+			res, err := readBinaryResponse(reader)
+			if err != nil {
+				return err
+			}
+			if res.opcode == opNoop {
+				return nil
+			}
+			if res.status != statusNoError {
+				continue
+			}
+			// Responses are matched to their keys by opaque, the index of the request in the batch.
+			if int(res.opaque) >= len(keys) {
+				return ErrUnexpectedResponse
+			}
+			item, err := res.item(keys[res.opaque])
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+	})
+	return
+}
@@ -0,0 +1,88 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestBinaryGetConcurrent(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithBinaryProtocol(), WithSingleConnection())
+	const n = 100
+	for i := range n {
+		if err := c.Set(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Responses to pipelined requests come back in reverse order, so callers only get their own values by opaque.
+	s.reverse.Store(true)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", i)
+			if got, err := c.Get(key); err != nil || got != fmt.Sprintf("value-%d", i) {
+				t.Errorf("Get(%q) = %q, %v; want %q", key, got, err, fmt.Sprintf("value-%d", i))
+			}
+		}()
+	}
+	wg.Wait()
+	if got := s.accepted.Load(); got != 1 {
+		t.Errorf("the server accepted %d connections, want 1", got)
+	}
+}
+
+func TestBinaryGetsMatchedByOpaque(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithBinaryProtocol(), WithSingleConnection())
+	for i := range 4 {
+		if err := c.Set(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.reverse.Store(true)
+	calls := make([]*binaryCall, 5)
+	for i := range calls {
+		calls[i] = &binaryCall{key: fmt.Sprintf("key-%d", i), done: make(chan struct{})}
+	}
+	server := c.servers[0]
+	conn, err := server.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.sendGets(conn, calls)
+	server.release(conn, &err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.reversed.Load(); got != 1 {
+		t.Fatalf("the server reversed %d batches, want 1", got)
+	}
+	for i, call := range calls[:4] {
+		<-call.done
+		if want := fmt.Sprintf("value-%d", i); call.err != nil || call.item == nil || string(call.item.Value) != want {
+			t.Errorf("call for %q = %v, %v; want %q", call.key, call.item, call.err, want)
+		}
+	}
+	// The miss is answered first, and still reaches the call for its own key.
+	<-calls[4].done
+	if !errors.Is(calls[4].err, ErrNotFound) {
+		t.Errorf("call for %q error = %v, want ErrNotFound", calls[4].key, calls[4].err)
+	}
+}
+
+func TestBinaryGetAcquireFailure(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithBinaryProtocol())
+	server := c.servers[0]
+	server.Close()
+	if _, err := server.binaryGet("key"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("binaryGet() error = %v, want ErrClosed", err)
+	}
+	if calls := server.gets.drain(); len(calls) != 0 {
+		t.Errorf("%d requests stayed queued after the connection could not be acquired", len(calls))
+	}
+}
@@ -0,0 +1,149 @@
+package memcache
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of a server.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Commands are sent to the server normally.
+	BreakerOpen                         // The server is considered down and commands to it fail fast.
+	BreakerHalfOpen                     // The cooldown has elapsed and the next command probes the server.
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker is a per-server circuit breaker.
+// It opens after threshold consecutive network failures and lets a probe through once cooldown has elapsed.
+// It is safe for concurrent use.
+type breaker struct {
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int       // Consecutive failures while closed.
+	openedAt  time.Time // When the breaker last opened.
+	threshold int
+	cooldown  time.Duration
+	notify    func(from, to BreakerState) // Called with the lock held on every transition; nil if unused.
+}
+
+// newBreaker creates a closed breaker with the given failure threshold and cooldown.
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a command may be sent to the server.
+// An open breaker whose cooldown has elapsed moves to the half-open state and allows the command as a probe.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.transition(BreakerHalfOpen)
+	}
+	return b.state != BreakerOpen
+}
+
+// success records a command that reached the server and closes the breaker.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.transition(BreakerClosed)
+}
+
+// failure records a network failure and opens the breaker once the threshold is reached or a probe fails.
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.transition(BreakerOpen)
+		b.openedAt = time.Now()
+		b.failures = 0
+	}
+}
+
+// transition moves the breaker to the given state and reports the change, if any. The caller must hold the lock.
+func (b *breaker) transition(to BreakerState) {
+	from := b.state
+	b.state = to
+	if from != to && b.notify != nil {
+		b.notify(from, to)
+	}
+}
+
+// current returns the current state of the breaker.
+func (b *breaker) current() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// breakerEvent is a transition of the circuit breaker of a server.
+type breakerEvent struct {
+	addr     string
+	from, to BreakerState
+}
+
+// breakerEvents queues the transitions of circuit breakers for the listener set with WithBreakerListener.
+// Commands only append to the queue, and a dedicated goroutine calls the listener, so a slow listener never blocks them.
+type breakerEvents struct {
+	mu       sync.Mutex
+	queue    []breakerEvent
+	wake     chan struct{} // Signaled when the queue becomes non-empty.
+	listener func(addr string, from, to BreakerState)
+}
+
+// newBreakerEvents creates a queue delivering transitions to listener.
+func newBreakerEvents(listener func(addr string, from, to BreakerState)) *breakerEvents {
+	return &breakerEvents{
+		wake:     make(chan struct{}, 1),
+		listener: listener,
+	}
+}
+
+// push queues a transition without blocking.
+func (e *breakerEvents) push(event breakerEvent) {
+	e.mu.Lock()
+	e.queue = append(e.queue, event)
+	e.mu.Unlock()
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run calls the listener with every queued transition, in order, until stop is closed.
+// Transitions still queued when stop is closed are dropped.
+func (e *breakerEvents) run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-e.wake:
+		}
+		e.mu.Lock()
+		events := e.queue
+		e.queue = nil
+		e.mu.Unlock()
+		for _, event := range events {
+			e.listener(event.addr, event.from, event.to)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerListener(t *testing.T) {
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithBreakerListener(nil)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithBreakerListener(nil) error = %v, want ErrInvalidOption", err)
+	}
+
+	type transition struct {
+		addr     string
+		from, to BreakerState
+	}
+	const cooldown = 50 * time.Millisecond
+	transitions := make(chan transition, 8)
+	release := make(chan struct{})
+	s := newFakeServer(t)
+	s.closeConnOn("get")
+	c := newTestClient(t, []string{s.addr()}, WithCircuitBreaker(1, cooldown), WithBreakerListener(func(addr string, from, to BreakerState) {
+		// The listener is slow, which must not hold up commands.
+		<-release
+		transitions <- transition{addr, from, to}
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The failed read opens the breaker, and the failed probe after the cooldown opens it again.
+		c.Get("key")
+		time.Sleep(2 * cooldown)
+		c.Get("key")
+		// The successful probe closes it.
+		time.Sleep(2 * cooldown)
+		if err := c.Set("key", "value", 0); err != nil {
+			t.Errorf("Set() error = %v after the cooldown", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("commands were blocked by the listener")
+	}
+	close(release)
+
+	want := []transition{
+		{s.addr(), BreakerClosed, BreakerOpen},
+		{s.addr(), BreakerOpen, BreakerHalfOpen},
+		{s.addr(), BreakerHalfOpen, BreakerOpen},
+		{s.addr(), BreakerOpen, BreakerHalfOpen},
+		{s.addr(), BreakerHalfOpen, BreakerClosed},
+	}
+	for i, w := range want {
+		select {
+		case got := <-transitions:
+			if got != w {
+				t.Errorf("transition %d = %+v, want %+v", i, got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d transitions reported, want %d", i, len(want))
+		}
+	}
+	select {
+	case got := <-transitions:
+		t.Errorf("unexpected transition %+v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
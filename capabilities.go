@@ -0,0 +1,170 @@
+package memcache
+
+import (
+	"errors"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Capabilities describes what a memcached server, or the proxy in front of it, supports (see Client.ServerCapabilities).
+type Capabilities struct {
+	Banner      string            // The greeting sent by the endpoint on connect, if any; memcached itself sends none.
+	Version     string            // The version reported by the server, e.g. "1.6.21"; empty if it cannot be queried, e.g. through twemproxy.
+	Meta        bool              // Whether the server supports meta commands, inferred from its version (1.6.0 and later).
+	GAT         bool              // Whether the server supports "gat" and "gats", inferred from its version (1.5.3 and later).
+	TLS         bool              // Whether the server requires TLS, from the ssl_enabled setting.
+	SASL        bool              // Whether the server requires SASL authentication, from the auth_enabled_sasl setting.
+	ItemSizeMax int               // The item_size_max setting; zero if the settings cannot be read.
+	Settings    map[string]string // The "stats settings" of the server; nil if they cannot be read, e.g. with the binary protocol.
+	FetchedAt   time.Time         // When the snapshot was taken.
+}
+
+// bannerTimeout bounds how long a newly dialed connection waits for a greeting before concluding there is none.
+const bannerTimeout = 100 * time.Millisecond
+
+// ServerCapabilities returns a snapshot of what the memcached server identified by the given address supports:
+// its greeting, its version and the features inferred from it, and its settings, e.g. to choose protocol features
+// per node in a fleet running mixed versions.
+// The snapshot is taken on first use and cached until a connection to the server is dialed again, since a new connection
+// may reach a restarted server; RefreshServerCapabilities takes a new one.
+// Settings that cannot be read are left zero rather than reported as errors, so that the snapshot of a server
+// behind a proxy or spoken to with the binary protocol still reports what is known.
+func (c *Client) ServerCapabilities(addr string) (caps Capabilities, err error) {
+	defer c.hookServer("ServerCapabilities", addr, &err)()
+	server, _, err := c.pickServerFromAddr(addr)
+	if err != nil {
+		return
+	}
+	if cached := server.capabilities.Load(); cached != nil {
+		return cached.clone(), nil
+	}
+	return server.fetchCapabilities()
+}
+
+// RefreshServerCapabilities takes a new snapshot of the capabilities of the memcached server identified by the given address,
+// replacing the one cached by ServerCapabilities.
+func (c *Client) RefreshServerCapabilities(addr string) (caps Capabilities, err error) {
+	defer c.hookServer("RefreshServerCapabilities", addr, &err)()
+	server, _, err := c.pickServerFromAddr(addr)
+	if err != nil {
+		return
+	}
+	return server.fetchCapabilities()
+}
+
+// clone returns a copy of the snapshot that does not share its settings.
+func (caps *Capabilities) clone() Capabilities {
+	out := *caps
+	out.Settings = maps.Clone(caps.Settings)
+	return out
+}
+
+// fetchCapabilities takes a snapshot of the capabilities of the server and caches it.
+func (s *Server) fetchCapabilities() (caps Capabilities, err error) {
+	caps.FetchedAt = time.Now()
+	if caps.Banner, err = s.readBanner(); err != nil {
+		return
+	}
+	if caps.Version, err = s.version(); err != nil {
+		return
+	}
+	caps.Meta = versionAtLeast(caps.Version, 1, 6, 0)
+	caps.GAT = versionAtLeast(caps.Version, 1, 5, 3)
+	if s.checkText("stats") == nil {
+		if caps.Settings, err = s.GetStatsGroup("settings"); err != nil {
+			return
+		}
+		caps.TLS = caps.Settings["ssl_enabled"] == "yes"
+		caps.SASL = caps.Settings["auth_enabled_sasl"] == "yes"
+		if n, err := intStat(caps.Settings, "item_size_max"); err == nil && n > 0 {
+			caps.ItemSizeMax = n
+			s.itemSizeMax.Store(int64(n))
+		}
+	}
+	// The cached snapshot is a copy, so that changes the caller makes to the settings do not reach it.
+	cached := caps.clone()
+	s.capabilities.Store(&cached)
+	return
+}
+
+// readBanner dials a separate connection to the server and returns what the endpoint sends before receiving any command.
+// Memcached never speaks first, so it waits at most bannerTimeout and returns an empty banner if nothing arrives.
+func (s *Server) readBanner() (banner string, err error) {
+	config := s.pool.config
+	// The connection only listens, so it is neither authenticated, verified nor counted as a reconnect of the pool.
+	config.auth = nil
+	config.verify = nil
+	config.onConnect = nil
+	config.backoffBase = 0
+	conn, err := newConn(s.Address, config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if err = conn.conn.SetReadDeadline(time.Now().Add(bannerTimeout)); err != nil {
+		return
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.conn.Read(buf)
+	if isTimeout(err) {
+		err = nil
+	}
+	banner = strings.TrimRight(string(buf[:n]), "\r\n")
+	return
+}
+
+// version returns the version reported by the server with a "version" command or a binary Version request,
+// or an empty version if the proxy in front of the server does not forward the command.
+func (s *Server) version() (version string, err error) {
+	if s.checkProxy("version") != nil {
+		return
+	}
+	if s.binary {
+		var res *binaryResponse
+		if res, err = s.binaryRoundTrip(&binaryRequest{opcode: opVersion}); err != nil {
+			return
+		}
+		if err = res.err(); err != nil {
+			return
+		}
+		version = string(res.value)
+		return
+	}
+	// version\r\n
+	resp, err := s.WriteCommand("version\r\n")
+	if err != nil {
+		return
+	}
+	version, ok := strings.CutPrefix(resp, "VERSION ")
+	if !ok {
+		err = errors.Join(ErrUnexpectedResponse, errors.New(resp))
+	}
+	return
+}
+
+// versionAtLeast reports whether a version such as "1.6.21" or "1.5.3-beta" is at least major.minor.patch.
+// Versions that cannot be parsed are reported as older.
+func versionAtLeast(version string, major, minor, patch int) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 3 {
+		return false
+	}
+	want := []int{major, minor, patch}
+	for i, part := range parts {
+		// Suffixes such as "-beta" or " (Ubuntu)" follow the digits of the last part.
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			return false
+		}
+		if n != want[i] {
+			return n > want[i]
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package memcache
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		want    bool
+	}{
+		{"1.6.0", true},
+		{"1.6.21", true},
+		{"1.10.0", true},
+		{"2.0.0", true},
+		{"1.5.99", false},
+		{"1.6.0-beta", true},
+		{"1.6.2 (Ubuntu)", true},
+		{"1.6", false},
+		{"", false},
+		{"x.6.0", false},
+	} {
+		if got := versionAtLeast(tt.version, 1, 6, 0); got != tt.want {
+			t.Errorf("versionAtLeast(%q, 1.6.0) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestServerCapabilities(t *testing.T) {
+	var commands atomic.Int64
+	addr := newScriptedServer(t, func(line string) string {
+		commands.Add(1)
+		switch line {
+		case "version":
+			return "VERSION 1.5.10\r\n"
+		case "stats settings":
+			return "STAT ssl_enabled no\r\nSTAT auth_enabled_sasl yes\r\nSTAT item_size_max 2097152\r\nEND\r\n"
+		}
+		return "ERROR\r\n"
+	})
+	c := newTestClient(t, []string{addr})
+	caps, err := c.ServerCapabilities(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Banner != "" || caps.Version != "1.5.10" || caps.Meta || !caps.GAT || caps.TLS || !caps.SASL || caps.ItemSizeMax != 2<<20 {
+		t.Errorf("ServerCapabilities() = %+v", caps)
+	}
+	sent := commands.Load()
+
+	// The snapshot is cached, and callers get their own copy of it.
+	caps.Settings["item_size_max"] = "1"
+	cached, err := c.ServerCapabilities(addr)
+	if err != nil || commands.Load() != sent || cached.Settings["item_size_max"] != "2097152" || !cached.FetchedAt.Equal(caps.FetchedAt) {
+		t.Errorf("ServerCapabilities() = %+v, %v with %d more commands, want the cached snapshot", cached, err, commands.Load()-sent)
+	}
+	if _, err := c.RefreshServerCapabilities(addr); err != nil || commands.Load() == sent {
+		t.Errorf("RefreshServerCapabilities() error = %v with %d more commands, want a new snapshot", err, commands.Load()-sent)
+	}
+
+	broken := newScriptedServer(t, func(line string) string { return "SERVER_ERROR out of memory\r\n" })
+	c = newTestClient(t, []string{broken})
+	if _, err := c.ServerCapabilities(broken); err == nil {
+		t.Error("ServerCapabilities() succeeded for a server failing its version")
+	}
+	if _, err := c.ServerCapabilities("127.0.0.1:1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ServerCapabilities() error = %v for an unknown server, want ErrNotFound", err)
+	}
+}
+
+func TestReadBanner(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, "proxy ready\r\n")
+			conn.Close()
+		}
+	}()
+	c := newTestClient(t, []string{ln.Addr().String()})
+	if banner, err := c.servers[0].readBanner(); err != nil || banner != "proxy ready" {
+		t.Errorf("readBanner() = %q, %v; want the greeting", banner, err)
+	}
+}
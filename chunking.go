@@ -0,0 +1,242 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// FlagChunked marks the manifest of a value that was split across several keys (see WithChunking).
+const FlagChunked uint32 = 1 << 17
+
+// chunkManifest describes a value split into chunks.
+type chunkManifest struct {
+	chunks   int    // Number of chunks, stored under "<key>:1" to "<key>:<chunks>".
+	length   int    // Total length of the value.
+	checksum uint32 // CRC32 (IEEE) checksum of the value.
+}
+
+// String encodes the manifest as stored in memcached: "<chunks> <length> <checksum>".
+func (m chunkManifest) String() string {
+	return fmt.Sprintf("%d %d %d", m.chunks, m.length, m.checksum)
+}
+
+// parseChunkManifest decodes a manifest stored by setChunked.
+// It returns ErrCorruptChunks if the manifest is malformed.
+func parseChunkManifest(s string) (m chunkManifest, err error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		err = ErrCorruptChunks
+		return
+	}
+	chunks, err1 := strconv.Atoi(fields[0])
+	length, err2 := strconv.Atoi(fields[1])
+	checksum, err3 := strconv.ParseUint(fields[2], 10, 32)
+	if err = errors.Join(err1, err2, err3); err != nil || chunks < 0 || length < 0 {
+		err = errors.Join(ErrCorruptChunks, err)
+		return
+	}
+	m = chunkManifest{chunks: chunks, length: length, checksum: uint32(checksum)}
+	return
+}
+
+// Limits of the manifests getChunked accepts. A manifest is read from the server, so it is not trusted to size the value.
+const (
+	maxChunks        = 1 << 20 // Number of chunks of a value.
+	maxChunkedLength = 1 << 30 // Length of a value, as large as the largest item memcached accepts.
+)
+
+// checkChunkManifest returns ErrCorruptChunks, joined with ErrUnexpectedResponse, if the manifest describes more than
+// maxChunks chunks, a value longer than maxChunkedLength, or more bytes than its chunks of at most size bytes can hold.
+func checkChunkManifest(m chunkManifest, size int) (err error) {
+	chunks := m.length / size
+	if m.length%size != 0 {
+		chunks++
+	}
+	if m.chunks > maxChunks || m.length > maxChunkedLength || chunks > m.chunks {
+		err = errors.Join(ErrCorruptChunks, ErrUnexpectedResponse, fmt.Errorf("manifest %q", m))
+	}
+	return
+}
+
+// chunkKey returns the key of the i-th chunk of the value stored under key, counting from 1.
+func chunkKey(key string, i int) string {
+	return key + ":" + strconv.Itoa(i)
+}
+
+// defaultItemSizeMax is the item size limit assumed for servers whose settings cannot be read, the default of memcached.
+const defaultItemSizeMax = 1 << 20
+
+// itemSizeLimit returns the item_size_max setting of the server.
+// It is read with "stats settings" the first time it is needed after a connection is dialed, and cached until the next dial.
+// If the settings cannot be read, e.g. through a proxy or with the binary protocol, defaultItemSizeMax is assumed.
+func (s *Server) itemSizeLimit() int {
+	if limit := s.itemSizeMax.Load(); limit > 0 {
+		return int(limit)
+	}
+	limit := defaultItemSizeMax
+	if settings, err := s.GetStatsGroup("settings"); err == nil {
+		if n, err := intStat(settings, "item_size_max"); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	s.itemSizeMax.Store(int64(limit))
+	return limit
+}
+
+// knownItemSizeLimit returns the item_size_max setting of the server if it was already read by itemSizeLimit,
+// and defaultItemSizeMax otherwise, without a round trip.
+func (s *Server) knownItemSizeLimit() int {
+	if limit := s.itemSizeMax.Load(); limit > 0 {
+		return int(limit)
+	}
+	return defaultItemSizeMax
+}
+
+// maxValueSize returns the length of the largest value the server can store under key.
+func (s *Server) maxValueSize(key string) int {
+	return valueSizeLimit(s.itemSizeLimit(), key)
+}
+
+// valueSizeLimit returns the length of the largest value that fits in an item of itemSizeMax bytes under key,
+// which is itemSizeMax less the item header, the key, the CAS token and the line terminators.
+func valueSizeLimit(itemSizeMax int, key string) int {
+	return max(itemSizeMax-itemHeaderSize-len(key)-8-3, 1)
+}
+
+// chunking reports whether Set splits large values, with WithChunking or WithAutoChunking.
+func (c *Client) chunking() bool {
+	return c.chunkSize > 0 || c.autoChunking
+}
+
+// needsChunks reports whether a value is too long to be stored under key without splitting it.
+// The key is already namespaced.
+func (c *Client) needsChunks(key, value string) bool {
+	if c.chunkSize > 0 {
+		return len(value) > c.chunkSize
+	}
+	if !c.autoChunking {
+		return false
+	}
+	server, err := c.pickServer(key)
+	// Routing errors are left to be reported by the regular store.
+	return err == nil && len(value) > server.maxValueSize(key)
+}
+
+// setChunked stores a value that needs chunks as chunks followed by its manifest.
+// With WithAutoChunking, each chunk is as long as the server it is stored on allows.
+// The key is already namespaced. Writing the manifest last means readers keep seeing the previous value,
+// or a miss, until every chunk of the new one is stored. With WithReplication, the chunks and the manifest are also
+// stored on the replicas of their keys, so that reads falling back to a replica can reassemble the value too.
+func (c *Client) setChunked(key, value string, expiration int) (err error) {
+	m := chunkManifest{length: len(value), checksum: crc32.ChecksumIEEE([]byte(value))}
+	for start := 0; start < len(value); {
+		m.chunks++
+		item := &Item{Key: chunkKey(key, m.chunks), Expiration: expiration}
+		var server *Server
+		if server, err = c.pickWriteServer(item.Key); err != nil {
+			return
+		}
+		size := c.chunkSize
+		if size == 0 {
+			size = server.maxValueSize(item.Key)
+		}
+		end := min(start+size, len(value))
+		item.Value = []byte(value[start:end])
+		if err = c.storeReplicated(server, item); err != nil {
+			return
+		}
+		start = end
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	return c.storeReplicated(server, &Item{Key: key, Value: []byte(m.String()), Flags: FlagChunked, Expiration: expiration})
+}
+
+// storeReplicated stores the item on the server, the primary server of its key, and then on its replicas.
+// The key of the item is already namespaced.
+func (c *Client) storeReplicated(server *Server, item *Item) (err error) {
+	if err = storeItem(server, item); err != nil {
+		return
+	}
+	return c.replicate(item.Key, func(replica *Server) error {
+		return storeItem(replica, item)
+	})
+}
+
+// storeItem stores the item on the server with a "set" command.
+func storeItem(server *Server, item *Item) (err error) {
+	if server.binary {
+		return server.binaryStore(opSet, item)
+	}
+	resp, err := server.WriteCommand(storageCommand("set", item))
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	if resp != "STORED" {
+		err = storeError(resp)
+	}
+	return
+}
+
+// getChunk reads a chunk of a value split by setChunked, falling back to its replicas like Get.
+// The key is already namespaced.
+func (c *Client) getChunk(key string) (item *Item, err error) {
+	servers, err := c.pickReadServers(key)
+	if err != nil {
+		return
+	}
+	for _, server := range servers {
+		item, err = server.GetItem(key, false)
+		// Only network failures are retried on the next server.
+		if !isNetworkError(err) {
+			return
+		}
+	}
+	return
+}
+
+// getChunked reassembles the value whose manifest is stored under key. The key is already namespaced.
+// A missing chunk, e.g. an evicted one, is reported as ErrNotFound. Chunks that do not add up to the value
+// described by the manifest, e.g. because a concurrent Set replaced some of them, are reported as ErrCorruptChunks.
+// A manifest outside the limits of checkChunkManifest is rejected before any chunk is read.
+func (c *Client) getChunked(key string, manifest []byte) (value string, err error) {
+	m, err := parseChunkManifest(string(manifest))
+	if err != nil {
+		return
+	}
+	size := c.chunkSize
+	if size == 0 {
+		// With WithAutoChunking, a chunk is as long as the server storing it allows, which is not known here.
+		size = maxChunkedLength
+	}
+	if err = checkChunkManifest(m, size); err != nil {
+		return
+	}
+	// The checked length never exceeds what the chunks can hold.
+	var b strings.Builder
+	b.Grow(m.length)
+	for i := 1; i <= m.chunks; i++ {
+		var item *Item
+		item, err = c.getChunk(chunkKey(key, i))
+		if err != nil {
+			return
+		}
+		if b.Len()+len(item.Value) > m.length {
+			err = ErrCorruptChunks
+			return
+		}
+		b.Write(item.Value)
+	}
+	value = b.String()
+	if len(value) != m.length || crc32.ChecksumIEEE([]byte(value)) != m.checksum {
+		value = ""
+		err = ErrCorruptChunks
+	}
+	return
+}
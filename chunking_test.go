@@ -0,0 +1,107 @@
+package memcache
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChunkedSetReplicates(t *testing.T) {
+	servers := []*fakeServer{newFakeServer(t), newFakeServer(t), newFakeServer(t)}
+	addrs := []string{servers[0].addr(), servers[1].addr(), servers[2].addr()}
+	c := newTestClient(t, addrs, WithChunking(10), WithReplication(2), WithReadRetries(1))
+	value := strings.Repeat("0123456789", 3) + "abcde"
+	if err := c.Set("key", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	// 4 chunks and the manifest, each on its primary server and its replica.
+	items := 0
+	for _, s := range servers {
+		items += s.itemCount()
+	}
+	if items != 10 {
+		t.Fatalf("the servers hold %d items, want 10", items)
+	}
+	// Every chunk and the manifest can still be read from a replica after any one server goes away.
+	servers[0].close()
+	if got, err := c.Get("key"); err != nil || got != value {
+		t.Fatalf("Get() = %q, %v after a server went away; want %q", got, err, value)
+	}
+}
+
+func TestGetChunkedCorruptLength(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithChunking(10))
+	// A manifest claiming a terabyte in a single chunk must not size the buffer by its length.
+	if err := c.SetWithFlags("key", "1 1099511627776 0", FlagChunked, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("key:1", "chunk", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("key"); !errors.Is(err, ErrCorruptChunks) {
+		t.Fatalf("Get() error = %v, want ErrCorruptChunks", err)
+	}
+}
+
+func TestGetChunkedOversizedManifest(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithChunking(10))
+	for _, manifest := range []string{
+		"1099511627776 1099511627776 0", // Chunks that could hold a terabyte.
+		"2000000 100 0",                 // More chunks than any value is split into.
+		"200000000 2000000000 0",        // A value longer than memcached could store in one item.
+		"2 21 0",                        // More bytes than two chunks of 10 bytes hold.
+	} {
+		if err := c.SetWithFlags("key", manifest, FlagChunked, 0); err != nil {
+			t.Fatal(err)
+		}
+		// The manifest is rejected before anything is allocated or fetched for it.
+		if _, err := c.Get("key"); !errors.Is(err, ErrUnexpectedResponse) || !errors.Is(err, ErrCorruptChunks) {
+			t.Errorf("Get() error = %v for the manifest %q, want ErrUnexpectedResponse", err, manifest)
+		}
+	}
+	for _, cmd := range s.received() {
+		if strings.HasPrefix(cmd, "get key:") {
+			t.Errorf("a chunk was fetched: %q", cmd)
+		}
+	}
+}
+
+func TestAutoChunkingFollowsItemSizeMax(t *testing.T) {
+	s := newFakeServer(t)
+	s.mu.Lock()
+	s.itemSizeMax = 2048
+	s.mu.Unlock()
+	c := newTestClient(t, []string{s.addr()}, WithAutoChunking())
+	value := strings.Repeat("0123456789", 500)
+	if err := c.Set("key", value, 0); err != nil {
+		t.Fatalf("Set() error = %v for a value above item_size_max", err)
+	}
+	// Without chunking the server would have rejected the value as too large.
+	if _, flags, _ := s.stored("key"); flags&FlagChunked == 0 {
+		t.Fatalf("the value was stored with flags %#x, want a chunk manifest", flags)
+	}
+	if chunk, _, ok := s.stored("key:1"); !ok || len(chunk) > valueSizeLimit(2048, "key:1") {
+		t.Errorf("the first chunk holds %d bytes, want at most %d", len(chunk), valueSizeLimit(2048, "key:1"))
+	}
+	if got, err := c.Get("key"); err != nil || got != value {
+		t.Errorf("Get() = %d bytes, %v; want the %d bytes stored", len(got), err, len(value))
+	}
+
+	// The limit is read again after reconnecting, so a server restarted with a larger -I stores the value whole.
+	s.mu.Lock()
+	s.itemSizeMax = 1 << 20
+	s.mu.Unlock()
+	s.dropConns()
+	// The first command finds the dropped connection, and the next one dials a new connection.
+	for range 2 {
+		c.Version(s.addr())
+	}
+	if err := c.Set("key", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	if stored, flags, _ := s.stored("key"); flags&FlagChunked != 0 || stored != value {
+		t.Errorf("the value was stored with flags %#x after the limit was raised, want it whole", flags)
+	}
+}
@@ -1,11 +1,26 @@
 package memcache
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+)
+
+// defaultPingTimeout is the maximum time PingLatency waits for a single server.
+const defaultPingTimeout = time.Second
+
+// Default limits of a multi-key retrieval command (see WithMultiGetMaxKeys and WithMultiGetMaxBytes).
+const (
+	defaultMultiGetMaxKeys  = 100
+	defaultMultiGetMaxBytes = 8 << 10
 )
 
 // Server has the address of a memcached server and a connection to it.
@@ -13,39 +28,428 @@ import (
 type Client struct {
 	servers []*Server
 	mu      sync.RWMutex
+
+	addresses            []string                // Server addresses collected by WithServers.
+	rejectDuplicates     bool                    // Whether duplicate addresses fail with ErrDuplicateServer instead of being merged.
+	latencyThreshold     time.Duration           // Latency above which reads prefer a replica; zero disables it.
+	pipelineDepth        int                     // Maximum number of commands a Pipeline queues; zero means unlimited.
+	weightedRing         bool                    // Whether servers are selected with the consistent-hash ring.
+	ring                 *ring                   // The consistent-hash ring; nil when modulo hashing is used.
+	virtualNodes         int                     // Number of points on the ring per unit of server weight.
+	singleConnection     bool                    // Whether commands to a server must share one connection.
+	poolSize             int                     // Number of connections per server; zero means one.
+	maxIdleConns         int                     // Number of idle connections kept open per server; zero keeps every one.
+	maxOpenConns         int                     // Cap of the number of connections per server; zero leaves it to poolSize.
+	acquireTimeout       time.Duration           // Time a command waits for a free connection; zero waits indefinitely.
+	hasher               func(key string) uint32 // Hash of keys for server selection; nil uses CRC32 with crcTable.
+	breakerThreshold     int                     // Consecutive failures that open a server's circuit breaker; zero disables it.
+	breakerCooldown      time.Duration           // Time an open circuit breaker waits before probing the server.
+	breakerEvents        *breakerEvents          // Delivers breaker transitions to the listener of WithBreakerListener; nil if unused.
+	readRetries          int                     // Number of following servers a failed read is retried on.
+	codecs               *CodecRegistry          // Codecs used by SetAuto and GetAuto.
+	codec                Codec                   // Codec used by SetObject and GetObject.
+	namespace            *namespace              // Namespace prefixed to every key; nil when disabled.
+	connConfig           connConfig              // Settings used to establish connections to the servers.
+	proxy                string                  // The proxy in front of the servers, if any.
+	binary               bool                    // Whether servers are spoken to with the binary protocol.
+	slidingExpiration    int                     // Expiration time every Get refreshes; zero disables it.
+	crcTable             *crc32.Table            // Table of the CRC32 polynomial used to hash keys.
+	healthInterval       time.Duration           // Interval of the background health check; zero disables it.
+	logger               *slog.Logger            // Logger for client events; nil disables logging.
+	minHealthyServers    int                     // Number of available servers required to accept writes; zero disables the check.
+	hooks                []Hook                  // Hooks called around every operation.
+	compressionThreshold int                     // Minimum size of values SetAuto compresses; zero disables compression.
+	chunkSize            int                     // Length above which Set splits values into chunks; zero disables chunking.
+	autoChunking         bool                    // Whether Set splits values beyond the item_size_max of their server.
+	validateIdle         time.Duration           // Idle time after which pooled connections are validated before use; zero disables it.
+	replicas             int                     // Number of servers Set and Delete write to; zero or one disables replication.
+	readRepair           bool                    // Whether Get repairs stale copies on replicas.
+	batchFailFast        bool                    // Whether the first network failure of a batch operation aborts its other requests.
+	verifyOnConnect      bool                    // Whether every dialed connection is checked with a "version" command.
+	multiGetMaxKeys      int                     // Maximum number of keys of one multi-key retrieval command.
+	multiGetMaxBytes     int                     // Maximum length of one multi-key retrieval command in bytes.
+	checkAcceptingConns  bool                    // Whether the health check also requires accepting_conns to be 1.
+	extraAllowlist       map[string]bool         // Commands Extra and ExtraLine may send; nil allows every command.
+	extraTimeout         time.Duration           // Bound of Extra and ExtraLine; zero disables it.
+	fallbackSize         int                     // Number of entries of the local fallback cache; zero disables it.
+	fallbackTTL          time.Duration           // How long the local fallback cache serves an entry.
+	fallbackQueue        int                     // Number of keys whose writes are queued during an outage; zero drops them.
+	fallback             *localFallback          // The local fallback cache; nil when disabled.
+
+	closed    bool           // Whether Close or QuitAll was called; guarded by mu.
+	stop      chan struct{}  // Closed to stop the background goroutines.
+	wg        sync.WaitGroup // Tracks the background goroutines.
+	closeOnce sync.Once
 }
 
 // NewClient creates a new Client instance with the provided memcached server addresses.
 // It initializes the servers by creating a new Server instance for each address.
 // If no addresses are provided, it returns ErrEmptyAddresses.
 func NewClient(addresses ...string) (c *Client, err error) {
-	if len(addresses) == 0 {
+	return NewClientWithOptions(WithServers(addresses...))
+}
+
+// NewClientWithOptions creates a new Client instance configured by the provided options.
+// The memcached server addresses are supplied with WithServers; an address given more than once is only used once
+// (see WithRejectDuplicateServers). If no addresses are provided, it returns ErrEmptyAddresses.
+func NewClientWithOptions(opts ...Option) (c *Client, err error) {
+	client := &Client{
+		codecs:           DefaultCodecRegistry,
+		codec:            JSONCodec,
+		crcTable:         crc32.IEEETable,
+		multiGetMaxKeys:  defaultMultiGetMaxKeys,
+		multiGetMaxBytes: defaultMultiGetMaxBytes,
+		virtualNodes:     defaultVirtualNodes,
+	}
+	for _, opt := range opts {
+		if err = opt(client); err != nil {
+			return
+		}
+	}
+	if len(client.addresses) == 0 {
 		err = ErrEmptyAddresses
 		return
 	}
-	servers := make([]*Server, len(addresses))
-	for i, addr := range addresses {
-		servers[i], err = NewServer(addr)
-		if err != nil {
+	if client.addresses, err = client.uniqueAddresses(); err != nil {
+		return
+	}
+	// A quorum larger than the cluster could never be met, so every write would fail.
+	if client.minHealthyServers > len(client.addresses) {
+		err = ErrInvalidOption
+		return
+	}
+	// A proxy that does not forward "version" would reject every probe, so connections through it are not verified.
+	if client.verifyOnConnect && !unsupportedByProxy[client.proxy]["version"] {
+		client.connConfig.verify = verifyMemcached(client.binary)
+	}
+	for _, addr := range client.addresses {
+		if err = checkLocalAddr(client.connConfig.localAddr, addr); err != nil {
+			return
+		}
+	}
+	client.servers = make([]*Server, len(client.addresses))
+	for i, addr := range client.addresses {
+		if client.servers[i], err = client.connectServer(addr); err != nil {
 			return
 		}
 	}
-	c = &Client{servers: servers}
+	client.rebuildRing()
+	if client.fallbackSize > 0 {
+		client.fallback = newLocalFallback(client.fallbackSize, client.fallbackTTL, client.fallbackQueue)
+	}
+	client.stop = make(chan struct{})
+	if client.healthInterval > 0 {
+		client.startHealthCheck()
+	}
+	if client.breakerEvents != nil {
+		client.wg.Add(1)
+		go func() {
+			defer client.wg.Done()
+			client.breakerEvents.run(client.stop)
+		}()
+	}
+	c = client
 	return
 }
 
 // pickServer selects the appropriate server for a given key using a CRC32 hash.
-// It returns an error if there are no servers available.
+// It returns an error if there are no servers available, or ErrServerUnavailable if the circuit breaker of the server is open.
 func (c *Client) pickServer(key string) (s *Server, err error) {
+	servers, err := c.pickServers(key, 1)
+	if err != nil {
+		return
+	}
+	s = servers[0]
+	if !s.available() {
+		err = ErrServerUnavailable
+		return
+	}
+	return
+}
+
+// hash returns the hash of a key used for server selection.
+func (c *Client) hash(key string) uint32 {
+	if c.hasher != nil {
+		return c.hasher(key)
+	}
+	return crc32.Checksum([]byte(key), c.crcTable)
+}
+
+// connectionsPerServer returns the size of the connection pool of every server.
+// WithSingleConnection takes precedence over WithPool and WithMaxOpenConns, and the smaller of these two applies.
+func (c *Client) connectionsPerServer() int {
+	switch {
+	case c.singleConnection:
+		return 1
+	case c.maxOpenConns > 0 && (c.poolSize == 0 || c.poolSize > c.maxOpenConns):
+		return c.maxOpenConns
+	case c.poolSize == 0:
+		return 1
+	}
+	return c.poolSize
+}
+
+// pickWriteServer selects the server for a given key like pickServer, for commands that modify data.
+// With WithMinHealthyServers, it returns ErrInsufficientServers while fewer servers than required are available.
+func (c *Client) pickWriteServer(key string) (s *Server, err error) {
+	if err = c.checkQuorum(); err != nil {
+		return
+	}
+	return c.pickServer(key)
+}
+
+// checkQuorum returns ErrInsufficientServers if fewer servers than configured with WithMinHealthyServers
+// pass their health check and have a circuit breaker that allows commands.
+func (c *Client) checkQuorum() (err error) {
+	if c.minHealthyServers == 0 {
+		return
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	healthy := 0
+	for _, server := range c.servers {
+		if server.available() {
+			healthy++
+		}
+	}
+	if healthy < c.minHealthyServers {
+		err = ErrInsufficientServers
+	}
+	return
+}
+
+// newBreaker creates the circuit breaker of the server with the given address, reporting its transitions
+// to the listener of WithBreakerListener if one is set.
+func (c *Client) newBreaker(addr string) (b *breaker) {
+	b = newBreaker(c.breakerThreshold, c.breakerCooldown)
+	if events := c.breakerEvents; events != nil {
+		b.notify = func(from, to BreakerState) {
+			events.push(breakerEvent{addr: addr, from: from, to: to})
+		}
+	}
+	return
+}
+
+// connectServer creates the server of the given address with the settings of the client, dialing its first connection.
+func (c *Client) connectServer(addr string) (server *Server, err error) {
+	if server, err = newServer(addr, c.connConfig, c.connectionsPerServer()); err != nil {
+		return
+	}
+	server.proxy = c.proxy
+	server.binary = c.binary
+	server.extraTimeout = c.extraTimeout
+	if c.validateIdle > 0 {
+		server.validateOnBorrow(c.validateIdle)
+	}
+	server.pool.maxIdle = c.maxIdleConns
+	server.pool.acquireTimeout = c.acquireTimeout
+	if c.breakerThreshold > 0 {
+		server.breaker = c.newBreaker(addr)
+	}
+	return
+}
+
+// uniqueAddresses returns the server addresses in order, without the ones that repeat an earlier address once normalized
+// (see normalizeAddress). A node listed twice would otherwise get two servers, a double share of the keys, and twice the connections.
+// With WithRejectDuplicateServers, it returns ErrDuplicateServer instead.
+func (c *Client) uniqueAddresses() (addresses []string, err error) {
+	seen := make(map[string]bool, len(c.addresses))
+	for _, addr := range c.addresses {
+		normalized := normalizeAddress(addr)
+		if seen[normalized] {
+			if c.rejectDuplicates {
+				err = errors.Join(ErrDuplicateServer, fmt.Errorf("address %q", addr))
+				return
+			}
+			continue
+		}
+		seen[normalized] = true
+		addresses = append(addresses, addr)
+	}
+	return
+}
+
+// checkLocalAddr returns ErrInvalidOption if the source address of WithLocalAddr cannot reach the given server address
+// because one is an IPv4 address and the other an IPv6 address. Host names are only resolved when dialing, so they are not checked.
+func checkLocalAddr(local *net.TCPAddr, addr string) (err error) {
+	if local == nil || local.IP == nil {
+		return
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		// The address is reported by the dial instead.
+		err = nil
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return
+	}
+	if (ip.To4() == nil) != (local.IP.To4() == nil) {
+		err = errors.Join(ErrInvalidOption, fmt.Errorf("local address %s cannot reach %s", local, addr))
+	}
+	return
+}
+
+// normalizeAddress returns the form of a "host:port" address used to compare addresses:
+// surrounding spaces are trimmed and the host is lowercased. Addresses that cannot be split are only trimmed.
+func normalizeAddress(addr string) string {
+	addr = strings.TrimSpace(addr)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.ToLower(host), port)
+}
+
+// pickServers returns up to n distinct servers for a given key in ring order.
+// The first server is the one pickServer selects, followed by its successors on the ring.
+// Every key-based command is routed through here, so it also rejects keys that are not valid with ErrInvalidKey.
+// It returns an error if there are no servers available.
+func (c *Client) pickServers(key string, n int) (servers []*Server, err error) {
+	if err = validateKey(key); err != nil {
+		return
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lookupServers(key, n)
+}
+
+// lookupServers is pickServers for callers that hold the read lock.
+func (c *Client) lookupServers(key string, n int) (servers []*Server, err error) {
+	if c.closed {
+		err = ErrClosed
+		return
+	}
 	if len(c.servers) == 0 {
 		err = ErrNoServers
 		return
 	}
+	n = min(n, len(c.servers))
+	hash := c.hash(key)
+	if c.ring != nil {
+		servers = c.ring.lookup(hash, n)
+		return
+	}
+	idx := int(hash) % len(c.servers)
+	servers = make([]*Server, n)
+	for i := range n {
+		servers[i] = c.servers[(idx+i)%len(c.servers)]
+	}
+	return
+}
+
+// snapshotServers returns a copy of the server list taken under the read lock.
+// Commands sent to every server iterate over the copy, so that servers added or removed meanwhile cannot disturb them.
+func (c *Client) snapshotServers() (servers []*Server) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	hash := crc32.ChecksumIEEE([]byte(key))
-	idx := int(hash) % len(c.servers)
-	s = c.servers[idx]
+	servers = make([]*Server, len(c.servers))
+	copy(servers, c.servers)
+	return
+}
+
+// checkClosed returns ErrClosed once the client has been closed with Close or QuitAll.
+func (c *Client) checkClosed() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// KeyDistribution returns the address of the server each of the given keys maps to, for diagnostics such as
+// computing the fraction of keys that move when servers are added or removed.
+// All keys are mapped under the same read lock, so the result reflects a single topology.
+// Keys that cannot be routed, e.g. invalid keys, are absent from the result.
+func (c *Client) KeyDistribution(keys []string) (distribution map[string]string) {
+	distribution = make(map[string]string, len(keys))
+	// Namespacing may need a round trip to fetch the version token, so it is done before taking the lock.
+	fullKeys := make(map[string]string, len(keys))
+	for _, key := range keys {
+		fullKey, err := c.namespacedKey(key)
+		if err != nil || validateKey(fullKey) != nil {
+			continue
+		}
+		fullKeys[key] = fullKey
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for key, fullKey := range fullKeys {
+		servers, err := c.lookupServers(fullKey, 1)
+		if err != nil {
+			continue
+		}
+		distribution[key] = servers[0].Address
+	}
+	return
+}
+
+// rebuildRing rebuilds the consistent-hash ring from the current servers and their weights.
+// It does nothing unless the weighted ring is enabled. The caller must hold the write lock, or own the client exclusively.
+func (c *Client) rebuildRing() {
+	if !c.weightedRing {
+		return
+	}
+	c.ring = newRing(c.servers, c.virtualNodes)
+}
+
+// SetWeight changes the weight of the server identified by the given address and rebuilds the ring.
+// A server with weight 2 receives roughly twice as many keys as a server with weight 1.
+// The weight only affects key placement when the client uses WithWeightedRing.
+// It returns ErrInvalidWeight if weight is not positive, and ErrNotFound if no server has the address.
+func (c *Client) SetWeight(addr string, weight int) (err error) {
+	if weight <= 0 {
+		err = ErrInvalidWeight
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, server := range c.servers {
+		if server.Address == addr {
+			server.weight = weight
+			c.rebuildRing()
+			return
+		}
+	}
+	err = ErrNotFound
+	return
+}
+
+// pickReadServers returns the servers to try, in order, when reading a given key.
+// The first one is the primary server, unless it is slower than the latency threshold and the next server on the ring is faster.
+// With read retries, up to that many following servers are appended, and servers whose circuit breaker is open are skipped.
+// It returns ErrServerUnavailable if no server can be tried.
+func (c *Client) pickReadServers(key string) (servers []*Server, err error) {
+	n := 1 + c.readRetries
+	want := n
+	if c.latencyThreshold > 0 {
+		want = max(want, 2)
+	}
+	candidates, err := c.pickServers(key, want)
+	if err != nil {
+		return
+	}
+	for i, server := range candidates {
+		if !server.available() {
+			// Without read retries an unavailable primary fails fast instead of being skipped.
+			if i == 0 && c.readRetries == 0 {
+				err = ErrServerUnavailable
+				return
+			}
+			continue
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		err = ErrServerUnavailable
+		return
+	}
+	if c.latencyThreshold > 0 && len(servers) >= 2 && servers[0].Latency() > c.latencyThreshold && servers[1].Latency() < servers[0].Latency() {
+		servers[0], servers[1] = servers[1], servers[0]
+	}
+	servers = servers[:min(n, len(servers))]
 	return
 }
 
@@ -54,6 +458,10 @@ func (c *Client) pickServer(key string) (s *Server, err error) {
 func (c *Client) pickServerFromAddr(addr string) (s *Server, index int, err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	if c.closed {
+		err = ErrClosed
+		return
+	}
 	for _, server := range c.servers {
 		if server.Address == addr {
 			s = server
@@ -67,34 +475,187 @@ func (c *Client) pickServerFromAddr(addr string) (s *Server, index int, err erro
 
 // Set sends a "set" command to store a key-value pair in the memcached server.
 // The expiration parameter specifies the time until the key expires.
+// With WithChunking or WithAutoChunking, values that are too long are split across several keys.
+// With WithReplication, the value is also stored on the replicas of the key.
+// With WithLocalFallback, the value is also kept in the local fallback cache.
 // It returns an error if the command fails or the store operation is not acknowledged.
 func (c *Client) Set(key, value string, expiration int) (err error) {
-	server, err := c.pickServer(key)
+	defer c.hook("Set", key, &err)()
+	if c.fallback != nil {
+		defer c.fallbackWrite(key, &pendingWrite{value: value, expiration: expiration}, &err)
+	}
+	return c.set(key, value, expiration)
+}
+
+// set is Set without the hooks and the local fallback cache; writes queued during an outage are replayed with it.
+func (c *Client) set(key, value string, expiration int) (err error) {
+	key, err = c.namespacedKey(key)
 	if err != nil {
 		return
 	}
-	// set <key> <flags> <exptime> <bytes>\r\n<data>\r\n
-	command := fmt.Sprintf("set %s 0 %d %d\r\n%s\r\n", key, expiration, len(value), value)
-	resp, err := server.WriteCommand(command)
+	if c.needsChunks(key, value) {
+		return c.setChunked(key, value, expiration)
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	item := &Item{Key: key, Value: []byte(value), Expiration: expiration}
+	if err = storeItem(server, item); err != nil {
+		return
+	}
+	return c.replicate(key, func(replica *Server) error {
+		return storeItem(replica, item)
+	})
+}
+
+// SetNoReply sends a "set" command with "noreply" to store a key-value pair without waiting for a response,
+// for write-heavy workloads where the round trip of Set is the bottleneck. The expiration parameter specifies the time until the key expires.
+// Since the server does not acknowledge the command, it only reports errors writing it: a value the server fails to store,
+// e.g. because it is too large, goes unnoticed, and the error line the server still sends for it is discarded
+// before the next command expecting a response is sent on the connection.
+// Values are never split into chunks, and the local fallback cache of WithLocalFallback is not updated.
+// With WithReplication, the value is also sent to the replicas of the key.
+// It returns ErrUnsupportedByProtocol with WithBinaryProtocol, and ErrUnsupportedByProxy behind twemproxy,
+// which closes the connection on the meta no-op that discards those error lines.
+func (c *Client) SetNoReply(key, value string, expiration int) (err error) {
+	defer c.hook("SetNoReply", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	// set <key> <flags> <exptime> <bytes> noreply\r\n<data>\r\n
+	command := fmt.Sprintf("set %s 0 %d %d noreply\r\n%s\r\n", key, expiration, len(value), value)
+	if err = server.send(command); err != nil {
+		return
+	}
+	return c.replicate(key, func(replica *Server) error {
+		return replica.send(command)
+	})
+}
+
+// SetStream sends a "set" command whose data block of exactly length bytes is copied from r.
+// It is intended for large values that should not be buffered in memory. The expiration parameter specifies the time until the key expires.
+// It returns ErrLengthMismatch if r provides fewer or more than length bytes, and an error if the store operation is not acknowledged.
+func (c *Client) SetStream(key string, r io.Reader, length int, expiration int) (err error) {
+	defer c.hook("SetStream", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	resp, err := server.SetStream(key, r, length, expiration)
 	if err != nil {
-		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
 	if resp != "STORED" {
-		err = ErrStoreFailed
+		err = storeError(resp)
 		return
 	}
 	return nil
 }
 
+// SetAuto encodes v with the codec registered for the given flags and stores it with a "set" command,
+// marking the value with the flags so that GetAuto can decode it without knowing its format.
+// It returns ErrUnknownFlags if no codec handles the flags, and an error if the store operation is not acknowledged.
+func (c *Client) SetAuto(key string, v any, flags uint32, expiration int) (err error) {
+	defer c.hook("SetAuto", key, &err)()
+	data, err := c.codecs.Encode(v, flags)
+	if err != nil {
+		return
+	}
+	return c.setEncoded(key, data, flags, expiration)
+}
+
+// SetObject encodes v with the codec of the client (see WithCodec, JSON by default) and stores it with a "set" command,
+// marking the value with FlagObject so that GetObject decodes it with the same codec.
+// It returns ErrEncodeFailed if encoding fails, and an error if the store operation is not acknowledged.
+func (c *Client) SetObject(key string, v any, expiration int) (err error) {
+	defer c.hook("SetObject", key, &err)()
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		err = errors.Join(ErrEncodeFailed, err)
+		return
+	}
+	return c.setEncoded(key, data, FlagObject, expiration)
+}
+
+// setEncoded stores an encoded value with the flags of its format, compressed if WithCompression applies to it.
+func (c *Client) setEncoded(key string, data []byte, flags uint32, expiration int) (err error) {
+	data, flags, err = c.maybeCompress(data, flags)
+	if err != nil {
+		return
+	}
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	return storeItem(server, &Item{Key: key, Value: data, Flags: flags, Expiration: expiration})
+}
+
+// SetWithFlags sends a "set" command storing the value with the given flags, which other clients can use to tell its format.
+// It builds the same command as Pipeline.SetItem, without requiring an Item.
+// It returns an error if the command fails or the store operation is not acknowledged.
+func (c *Client) SetWithFlags(key, value string, flags uint32, expiration int) (err error) {
+	defer c.hook("SetWithFlags", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	return storeItem(server, &Item{Key: key, Value: []byte(value), Flags: flags, Expiration: expiration})
+}
+
+// SetMulti stores every item with a "set" command, honoring the flags and expiration of each item.
+// The commands are grouped by server and pipelined, so each server is written to in a single round trip.
+// Memcached has no cross-key atomicity: some items may be stored while others fail.
+// It returns a map from the key of every item that failed to its error; the map is empty if all items were stored.
+func (c *Client) SetMulti(items []*Item) (errs map[string]error) {
+	p := c.Pipeline()
+	for _, item := range items {
+		p.SetItem(item)
+	}
+	results, _ := p.Exec()
+	errs = make(map[string]error)
+	for _, r := range results {
+		if r.Err != nil {
+			errs[r.Key] = r.Err
+		}
+	}
+	return
+}
+
 // Add sends an "add" command to store a key-value pair only if the key does not already exist.
 // The expiration parameter specifies the time until the key expires.
-// It returns an error if the command fails or the store operation is not acknowledged.
+// It returns ErrNotStored, along with ErrStoreFailed, if the key already exists,
+// and an error if the command fails or the store operation is not acknowledged.
 func (c *Client) Add(key, value string, expiration int) (err error) {
-	server, err := c.pickServer(key)
+	defer c.hook("Add", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
 	if err != nil {
 		return
 	}
+	if server.binary {
+		return server.binaryStore(opAdd, &Item{Key: key, Value: []byte(value), Expiration: expiration})
+	}
 	// add <key> <flags> <exptime> <bytes>\r\n<data>\r\n
 	command := fmt.Sprintf("add %s 0 %d %d\r\n%s\r\n", key, expiration, len(value), value)
 	resp, err := server.WriteCommand(command)
@@ -103,7 +664,7 @@ func (c *Client) Add(key, value string, expiration int) (err error) {
 		return
 	}
 	if resp != "STORED" {
-		err = ErrStoreFailed
+		err = storeError(resp)
 		return
 	}
 	return nil
@@ -111,12 +672,21 @@ func (c *Client) Add(key, value string, expiration int) (err error) {
 
 // Replace sends a "replace" command to update the value of an existing key.
 // The expiration parameter specifies the time until the key expires.
-// It returns an error if the command fails or the store operation is not acknowledged.
+// It returns ErrNotStored, along with ErrStoreFailed, if the key does not exist,
+// and an error if the command fails or the store operation is not acknowledged.
 func (c *Client) Replace(key, value string, expiration int) (err error) {
-	server, err := c.pickServer(key)
+	defer c.hook("Replace", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
 	if err != nil {
 		return
 	}
+	if server.binary {
+		return server.binaryStore(opReplace, &Item{Key: key, Value: []byte(value), Expiration: expiration})
+	}
 	// replace <key> <flags> <exptime> <bytes>\r\n<data>\r\n
 	command := fmt.Sprintf("replace %s 0 %d %d\r\n%s\r\n", key, expiration, len(value), value)
 	resp, err := server.WriteCommand(command)
@@ -125,115 +695,454 @@ func (c *Client) Replace(key, value string, expiration int) (err error) {
 		return
 	}
 	if resp != "STORED" {
-		err = ErrStoreFailed
+		err = storeError(resp)
+		return
+	}
+	return nil
+}
+
+// Append sends an "append" command to add data to the end of the existing value for a key.
+// It is not resent automatically after a network failure, since appending twice would duplicate the data.
+// It returns ErrNotStored, along with ErrStoreFailed, if the key does not exist,
+// and an error if the command fails or the operation is not acknowledged.
+func (c *Client) Append(key, value string) (err error) {
+	defer c.hook("Append", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	// append <key> <flags> <exptime> <bytes>\r\n<data>\r\n
+	command := fmt.Sprintf("append %s 0 0 %d\r\n%s\r\n", key, len(value), value)
+	resp, err := server.WriteCommand(command)
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	if resp != "STORED" {
+		err = storeError(resp)
+		return
+	}
+	return nil
+}
+
+// Prepend sends a "prepend" command to add data to the beginning of the existing value for a key.
+// It is not resent automatically after a network failure, since prepending twice would duplicate the data.
+// It returns ErrNotStored, along with ErrStoreFailed, if the key does not exist,
+// and an error if the command fails or the operation is not acknowledged.
+func (c *Client) Prepend(key, value string) (err error) {
+	defer c.hook("Prepend", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	// prepend <key> <flags> <exptime> <bytes>\r\n<data>\r\n
+	command := fmt.Sprintf("prepend %s 0 0 %d\r\n%s\r\n", key, len(value), value)
+	resp, err := server.WriteCommand(command)
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	if resp != "STORED" {
+		err = storeError(resp)
+		return
+	}
+	return nil
+}
+
+// CAS (Check And Set) sends a "cas" command to update a key's value only if it has not been modified since it was last read.
+// The cas parameter is the unique value used for this check.
+// It returns ErrExists, along with ErrStoreFailed, if the key was modified since it was read, ErrCASNotFound if it no longer exists,
+// and an error if the command fails or the store operation is not acknowledged.
+func (c *Client) CAS(key, value string, expiration int, cas uint64) (err error) {
+	defer c.hook("CAS", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	if server.binary {
+		// A binary Set carrying a CAS token only succeeds if the item is unchanged.
+		return server.binaryStore(opSet, &Item{Key: key, Value: []byte(value), Expiration: expiration, CAS: cas})
+	}
+	// cas <key> <flags> <exptime> <bytes> <cas_unique>\r\n<data>\r\n
+	command := fmt.Sprintf("cas %s 0 %d %d %d\r\n%s\r\n", key, expiration, len(value), cas, value)
+	resp, err := server.WriteCommand(command)
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	if resp != "STORED" {
+		err = storeError(resp)
 		return
 	}
 	return nil
 }
 
-// Append sends an "append" command to add data to the end of the existing value for a key.
-// It returns an error if the command fails or the operation is not acknowledged.
-func (c *Client) Append(key, value string) (err error) {
+// Get retrieves the value associated with the given key using a "get" command.
+// With WithSlidingExpiration, it uses a "gat" command instead to also refresh the expiration time of the key.
+// With WithChunking or WithAutoChunking, values split across several keys are reassembled.
+// With WithLocalFallback, a read failing because the servers of the key cannot be reached is answered from the local fallback cache.
+// Values marked with FlagCompressed are decompressed, like every getter does (see WithCompression).
+// It returns the value and an error if any.
+func (c *Client) Get(key string) (value string, err error) {
+	defer c.hook("Get", key, &err)()
+	if c.fallback != nil {
+		defer c.fallbackRead(key, &value, &err)
+	}
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	servers, err := c.pickReadServers(key)
+	if err != nil {
+		return
+	}
+	for _, server := range servers {
+		var item *Item
+		if c.slidingExpiration > 0 {
+			item, err = server.getAndTouch(key, c.slidingExpiration)
+		} else {
+			// The flags tell compressed values and the manifests of chunked values apart from plain values.
+			item, err = server.GetItem(key, false)
+		}
+		if err == nil {
+			if item.Flags&FlagChunked != 0 {
+				value, err = c.getChunked(key, item.Value)
+			} else {
+				// Read repair copies the item as stored, before it is decompressed.
+				c.repairReplicas(server, item)
+				err = decompressItem(item)
+				value = string(item.Value)
+			}
+		}
+		// Only network failures are retried on the next server.
+		if !isNetworkError(err) {
+			return
+		}
+	}
+	return
+}
+
+// CASItem stores the item, with its flags and expiration, using a "cas" command with the CAS token of the item.
+// The key is the caller's key, as returned by GetFull or GetsMulti.
+// It returns ErrExists, along with ErrStoreFailed, if the item was modified since its CAS token was read,
+// and ErrCASNotFound if it no longer exists.
+func (c *Client) CASItem(item *Item) (err error) {
+	defer c.hook("CASItem", item.Key, &err)()
+	key, err := c.namespacedKey(item.Key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	stored := *item
+	stored.Key = key
+	if server.binary {
+		return server.binaryStore(opSet, &stored)
+	}
+	// cas <key> <flags> <exptime> <bytes> <cas_unique>\r\n<data>\r\n
+	command := fmt.Sprintf("cas %s %d %d %d %d\r\n%s\r\n", key, stored.Flags, stored.Expiration, len(stored.Value), stored.CAS, stored.Value)
+	resp, err := server.WriteCommand(command)
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	if resp != "STORED" {
+		err = storeError(resp)
+		return
+	}
+	return nil
+}
+
+// GetOrDefault retrieves the value associated with the given key like Get, but returns def if the key does not exist.
+// Only errors other than a miss are returned.
+func (c *Client) GetOrDefault(key, def string) (value string, err error) {
+	value, err = c.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return def, nil
+	}
+	return
+}
+
+// Gets retrieves the value and its CAS (Check And Set) token for the given key using a "gets" command.
+// Values marked with FlagCompressed are decompressed like Get does.
+// It returns the value, the CAS token, and an error if any.
+func (c *Client) Gets(key string) (value string, cas uint64, err error) {
+	defer c.hook("Gets", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickServer(key)
+	if err != nil {
+		return
+	}
+	item, err := server.GetItem(key, true)
+	if err != nil {
+		return
+	}
+	if err = decompressItem(item); err != nil {
+		return
+	}
+	return string(item.Value), item.CAS, nil
+}
+
+// GetFull retrieves the item stored under the given key with a "gets" command, including its value, flags and CAS token.
+// A miss is reported with found set to false and a nil error, so that it can be told apart from a failure.
+// A value marked with FlagCompressed is decompressed and reported without the flag, like Get does,
+// so the item is stored uncompressed if it is written back with CASItem.
+func (c *Client) GetFull(key string) (item *Item, found bool, err error) {
+	defer c.hook("GetFull", key, &err)()
+	fullKey, err := c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickServer(fullKey)
+	if err != nil {
+		return
+	}
+	item, err = server.GetItem(fullKey, true)
+	if errors.Is(err, ErrNotFound) {
+		return nil, false, nil
+	}
+	if err == nil {
+		err = decompressItem(item)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	// The item is reported under the caller's key rather than the namespaced one.
+	item.Key = key
+	found = true
+	return
+}
+
+// GetWithFlags retrieves the value associated with the given key along with the flags it was stored with.
+// Like Get, it decompresses values marked with FlagCompressed and then reports the flags without it;
+// unlike GetAuto, the value is not decoded.
+// It returns ErrNotFound if the key does not exist.
+func (c *Client) GetWithFlags(key string) (value string, flags uint32, err error) {
+	defer c.hook("GetWithFlags", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickServer(key)
+	if err != nil {
+		return
+	}
+	item, err := server.GetItem(key, false)
+	if err != nil {
+		return
+	}
+	if err = decompressItem(item); err != nil {
+		return
+	}
+	return string(item.Value), item.Flags, nil
+}
+
+// GetAuto retrieves the value associated with the given key and decodes it into dest
+// with the codec registered for the flags the value was stored with (see CodecRegistry).
+// Values stored without flags are copied into dest if it is a *string or a *[]byte.
+// It returns ErrUnknownFlags if no codec handles the flags, and ErrDecodeFailed if decoding fails.
+func (c *Client) GetAuto(key string, dest any) (err error) {
+	defer c.hook("GetAuto", key, &err)()
+	data, flags, err := c.getEncoded(key)
+	if err != nil {
+		return
+	}
+	return c.codecs.Decode(data, flags, dest)
+}
+
+// GetObject retrieves the value stored with SetObject under the given key and decodes it into dest
+// with the codec of the client (see WithCodec). Values stored with other flags, e.g. by SetAuto,
+// are decoded with the codec registered for their flags like GetAuto does.
+// It returns ErrDecodeFailed if decoding fails.
+func (c *Client) GetObject(key string, dest any) (err error) {
+	defer c.hook("GetObject", key, &err)()
+	data, flags, err := c.getEncoded(key)
+	if err != nil {
+		return
+	}
+	if flags != FlagObject {
+		return c.codecs.Decode(data, flags, dest)
+	}
+	if err = c.codec.Unmarshal(data, dest); err != nil {
+		err = errors.Join(ErrDecodeFailed, err)
+	}
+	return
+}
+
+// getEncoded retrieves an encoded value, decompressed if needed, along with the flags of its format.
+func (c *Client) getEncoded(key string) (data []byte, flags uint32, err error) {
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
 	server, err := c.pickServer(key)
 	if err != nil {
 		return
 	}
-	// append <key> <flags> <exptime> <bytes>\r\n<data>\r\n
-	command := fmt.Sprintf("append %s 0 0 %d\r\n%s\r\n", key, len(value), value)
-	resp, err := server.WriteCommand(command)
+	item, err := server.GetItem(key, false)
 	if err != nil {
-		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
-	if resp != "STORED" {
-		err = ErrStoreFailed
+	if err = decompressItem(item); err != nil {
 		return
 	}
-	return nil
+	return item.Value, item.Flags, nil
 }
 
-// Prepend sends a "prepend" command to add data to the beginning of the existing value for a key.
-// It returns an error if the command fails or the operation is not acknowledged.
-func (c *Client) Prepend(key, value string) (err error) {
-	server, err := c.pickServer(key)
+// maybeCompress compresses encoded data of at least the WithCompression threshold and marks it with FlagCompressed.
+// Data that does not shrink, such as images or data that is already compressed, is kept as is and unmarked.
+func (c *Client) maybeCompress(data []byte, flags uint32) (out []byte, outFlags uint32, err error) {
+	if c.compressionThreshold == 0 || len(data) < c.compressionThreshold {
+		return data, flags, nil
+	}
+	compressed, err := compress(data)
 	if err != nil {
+		err = errors.Join(ErrEncodeFailed, err)
 		return
 	}
-	// prepend <key> <flags> <exptime> <bytes>\r\n<data>\r\n
-	command := fmt.Sprintf("prepend %s 0 0 %d\r\n%s\r\n", key, len(value), value)
-	resp, err := server.WriteCommand(command)
+	if len(compressed) >= len(data) {
+		return data, flags &^ FlagCompressed, nil
+	}
+	return compressed, flags | FlagCompressed, nil
+}
+
+// uncompress decompresses data marked with FlagCompressed and returns the flags of its codec.
+func uncompress(data []byte, flags uint32) (out []byte, outFlags uint32, err error) {
+	if flags&FlagCompressed == 0 {
+		return data, flags, nil
+	}
+	out, err = decompress(data)
 	if err != nil {
-		err = errors.Join(ErrWriteFailed, err)
+		err = errors.Join(ErrDecodeFailed, err)
 		return
 	}
-	if resp != "STORED" {
-		err = ErrStoreFailed
-		return
+	outFlags = flags &^ FlagCompressed
+	return
+}
+
+// decompressItem decompresses the value of an item marked with FlagCompressed in place and clears the flag.
+// Every getter reads items through it, so that they all return the same value for a key (see WithCompression).
+// The item is left unchanged if decompression fails.
+func decompressItem(item *Item) (err error) {
+	value, flags, err := uncompress(item.Value, item.Flags)
+	if err == nil {
+		item.Value, item.Flags = value, flags
 	}
-	return nil
+	return
 }
 
-// CAS (Check And Set) sends a "cas" command to update a key's value only if it has not been modified since it was last read.
-// The cas parameter is the unique value used for this check.
-// It returns an error if the command fails or the store operation is not acknowledged.
-func (c *Client) CAS(key, value string, expiration int, cas uint64) (err error) {
-	server, err := c.pickServer(key)
+// Size returns the length in bytes of the value stored under the given key without transferring the value,
+// using a meta get command with the "s" flag. It returns ErrNotFound if the key does not exist.
+// On servers without meta command support, or behind a proxy that does not forward them,
+// it falls back to a full "get" and returns the length of the value.
+func (c *Client) Size(key string) (size int, err error) {
+	defer c.hook("Size", key, &err)()
+	key, err = c.namespacedKey(key)
 	if err != nil {
 		return
 	}
-	// cas <key> <flags> <exptime> <bytes> <cas_unique>\r\n<data>\r\n
-	command := fmt.Sprintf("cas %s 0 %d %d %d\r\n%s\r\n", key, expiration, len(value), cas, value)
-	resp, err := server.WriteCommand(command)
+	server, err := c.pickServer(key)
 	if err != nil {
-		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
-	if resp != "STORED" {
-		err = ErrStoreFailed
+	ret, _, err := server.MetaGet(key, "s")
+	if errors.Is(err, ErrMetaUnsupported) || errors.Is(err, ErrUnsupportedByProxy) {
+		var value string
+		value, _, err = server.GetValue(key, false)
+		size = len(value)
 		return
 	}
-	return nil
-}
-
-// Get retrieves the value associated with the given key using a "get" command.
-// It returns the value and an error if any.
-func (c *Client) Get(key string) (value string, err error) {
-	server, err := c.pickServer(key)
 	if err != nil {
 		return
 	}
-	// GetValue returns the value along with a cas token if requested; here we don't need the cas.
-	value, _, err = server.GetValue(key, false)
+	size, err = strconv.Atoi(ret['s'])
+	if err != nil {
+		err = errors.Join(ErrUnexpectedResponse, err)
+		return
+	}
 	return
 }
 
-// Gets retrieves the value and its CAS (Check And Set) token for the given key using a "gets" command.
-// It returns the value, the CAS token, and an error if any.
-func (c *Client) Gets(key string) (value string, cas uint64, err error) {
-	server, err := c.pickServer(key)
+// GetStream retrieves the value associated with the given key and writes it to dst without buffering the whole value.
+// It is intended for large values. Unlike the other getters, it copies values marked with FlagCompressed as stored.
+// It returns the number of bytes written and an error if any.
+func (c *Client) GetStream(key string, dst io.Writer) (n int64, err error) {
+	defer c.hook("GetStream", key, &err)()
+	key, err = c.namespacedKey(key)
 	if err != nil {
 		return
 	}
-	value, cas, err = server.GetValue(key, true)
-	return
+	servers, err := c.pickReadServers(key)
+	if err != nil {
+		return
+	}
+	// A partially written destination cannot be retried, so only the first server is used.
+	return servers[0].GetStream(key, dst)
 }
 
 // Delete sends a "delete" command to remove the key from the memcached server.
+// Only the single-argument form is sent; a CLIENT_ERROR response is reported as ErrDeleteTime.
 // It returns an error if the command fails or the deletion is not acknowledged.
 func (c *Client) Delete(key string) (err error) {
-	server, err := c.pickServer(key)
+	defer c.hook("Delete", key, &err)()
+	if c.fallback != nil {
+		defer c.fallbackWrite(key, &pendingWrite{deleted: true}, &err)
+	}
+	return c.delete(key)
+}
+
+// delete is Delete without the hooks and the local fallback cache; deletes queued during an outage are replayed with it.
+func (c *Client) delete(key string) (err error) {
+	key, err = c.namespacedKey(key)
 	if err != nil {
 		return
 	}
-	// delete <key>\r\n
-	command := fmt.Sprintf("delete %s\r\n", key)
-	resp, err := server.WriteCommand(command)
+	server, err := c.pickWriteServer(key)
+	if err != nil {
+		return
+	}
+	err = server.deleteKey(key)
+	// Copies on replicas are removed even if the primary server had none.
+	rerr := c.replicate(key, func(replica *Server) error {
+		if err := replica.deleteKey(key); err != nil && !errors.Is(err, ErrStoreFailed) {
+			return err
+		}
+		return nil
+	})
+	err = errors.Join(err, rerr)
+	return
+}
+
+// deleteKey removes the key from the server with a "delete" command.
+// It returns ErrStoreFailed if the key does not exist.
+func (s *Server) deleteKey(key string) (err error) {
+	if s.binary {
+		return s.binaryDelete(key)
+	}
+	resp, err := s.WriteCommand(deleteCommand(key))
 	if err != nil {
 		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
 	if resp != "DELETED" {
-		err = ErrStoreFailed
+		err = deleteError(resp)
 		return
 	}
 	return nil
@@ -242,15 +1151,20 @@ func (c *Client) Delete(key string) (err error) {
 // FlushAll sends a "flush_all" command to all servers to clear all keys after the specified delay in seconds.
 // It returns an error if any server fails to acknowledge the command.
 func (c *Client) FlushAll(sec int) (err error) {
+	defer c.hook("FlushAll", "", &err)()
+	if err = c.checkClosed(); err != nil {
+		return
+	}
 	// flush_all <exptime>\r\n
 	command := fmt.Sprintf("flush_all %d\r\n", sec)
-	for _, server := range c.servers {
+	servers := c.snapshotServers()
+	for _, server := range servers {
 		resp, err := server.WriteCommand(command)
 		if err != nil {
 			err = errors.Join(ErrWriteFailed, err)
 			return err
 		}
-		if resp != "OK" {
+		if !hasStatus(resp, "OK") {
 			err = ErrStoreFailed
 			return err
 		}
@@ -258,37 +1172,111 @@ func (c *Client) FlushAll(sec int) (err error) {
 	return
 }
 
-// Increment sends an "incr" command to increase the numeric value stored at the given key by delta.
-// It returns the new value and an error if the command fails or if the key is not found.
-func (c *Client) Increment(key string, delta int) (newValue uint64, err error) {
-	server, err := c.pickServer(key)
-	if err != nil {
+// FlushAllNoReply sends a "flush_all" command with "noreply" to all servers without waiting for their responses.
+// Since the servers do not acknowledge the command, it only reports errors writing it;
+// a server that fails to apply it, e.g. because flushing is disabled, goes unnoticed.
+func (c *Client) FlushAllNoReply(sec int) (err error) {
+	defer c.hook("FlushAllNoReply", "", &err)()
+	if err = c.checkClosed(); err != nil {
 		return
 	}
-	// incr <key> <delta>\r\n
-	command := fmt.Sprintf("incr %s %d\r\n", key, delta)
-	resp, err := server.WriteCommand(command)
-	if err != nil {
-		err = errors.Join(ErrWriteFailed, err)
+	servers := c.snapshotServers()
+
+	// flush_all <exptime> noreply\r\n
+	command := fmt.Sprintf("flush_all %d noreply\r\n", sec)
+	for _, server := range servers {
+		if serr := server.send(command); serr != nil {
+			err = errors.Join(err, fmt.Errorf("%s: %w", server.Address, serr))
+		}
+	}
+	return
+}
+
+// FlushAllConcurrent sends a "flush_all" command to all servers in parallel and waits for every acknowledgement,
+// so that a large cluster is flushed in about one round trip.
+// Unlike FlushAll, it does not stop at the first failure; it returns the joined errors of the servers that failed.
+func (c *Client) FlushAllConcurrent(sec int) (err error) {
+	defer c.hook("FlushAllConcurrent", "", &err)()
+	if err = c.checkClosed(); err != nil {
 		return
 	}
-	if resp == "NOT_FOUND" {
-		err = ErrNotFound
+	servers := c.snapshotServers()
+
+	// flush_all <exptime>\r\n
+	command := fmt.Sprintf("flush_all %d\r\n", sec)
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server *Server) {
+			defer wg.Done()
+			resp, err := server.WriteCommand(command)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", server.Address, errors.Join(ErrWriteFailed, err))
+				return
+			}
+			if !hasStatus(resp, "OK") {
+				errs[i] = fmt.Errorf("%s: %w", server.Address, ErrStoreFailed)
+			}
+		}(i, server)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Increment sends an "incr" command to increase the numeric value stored at the given key by delta.
+// It is not resent automatically after a network failure, since the delta could be applied twice.
+// It returns the new value and an error if the command fails or if the key is not found,
+// and ErrNegativeDelta if delta is negative; use IncrementUint64 for deltas beyond the range of int.
+func (c *Client) Increment(key string, delta int) (newValue uint64, err error) {
+	if delta < 0 {
+		err = ErrNegativeDelta
 		return
 	}
-	fmt.Sscanf(resp, "%d", &newValue)
-	return
+	return c.IncrementUint64(key, uint64(delta))
+}
+
+// IncrementUint64 is like Increment, but takes any unsigned 64-bit delta as memcached does.
+// Counters wrap around at 2^64.
+func (c *Client) IncrementUint64(key string, delta uint64) (newValue uint64, err error) {
+	return c.applyDelta("incr", key, delta)
 }
 
 // Decrement sends a "decr" command to decrease the numeric value stored at the given key by delta.
-// It returns the new value and an error if the command fails or if the key is not found.
+// It is not resent automatically after a network failure, since the delta could be applied twice.
+// It returns the new value and an error if the command fails or if the key is not found,
+// and ErrNegativeDelta if delta is negative; use DecrementUint64 for deltas beyond the range of int.
 func (c *Client) Decrement(key string, delta int) (newValue uint64, err error) {
-	server, err := c.pickServer(key)
+	if delta < 0 {
+		err = ErrNegativeDelta
+		return
+	}
+	return c.DecrementUint64(key, uint64(delta))
+}
+
+// DecrementUint64 is like Decrement, but takes any unsigned 64-bit delta as memcached does.
+// Counters do not go below zero.
+func (c *Client) DecrementUint64(key string, delta uint64) (newValue uint64, err error) {
+	return c.applyDelta("decr", key, delta)
+}
+
+// applyDelta sends an "incr" or "decr" command and returns the new value of the counter.
+func (c *Client) applyDelta(verb, key string, delta uint64) (newValue uint64, err error) {
+	operation := "Increment"
+	if verb == "decr" {
+		operation = "Decrement"
+	}
+	defer c.hook(operation, key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
 	if err != nil {
 		return
 	}
-	// decr <key> <delta>\r\n
-	command := fmt.Sprintf("decr %s %d\r\n", key, delta)
+	// incr <key> <delta>\r\n or decr <key> <delta>\r\n
+	command := fmt.Sprintf("%s %s %d\r\n", verb, key, delta)
 	resp, err := server.WriteCommand(command)
 	if err != nil {
 		err = errors.Join(ErrWriteFailed, err)
@@ -298,14 +1286,30 @@ func (c *Client) Decrement(key string, delta int) (newValue uint64, err error) {
 		err = ErrNotFound
 		return
 	}
-	fmt.Sscanf(resp, "%d", &newValue)
+	newValue, err = parseCounter(resp)
+	return
+}
+
+// parseCounter parses the new value returned by an "incr" or "decr" command, which is a 64-bit unsigned integer.
+// It returns ErrUnexpectedResponse if the response is not a number, e.g. a CLIENT_ERROR for a non-numeric value.
+func parseCounter(resp string) (value uint64, err error) {
+	value, err = strconv.ParseUint(resp, 10, 64)
+	if err != nil {
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("%q: %w", resp, err))
+		return
+	}
 	return
 }
 
 // Touch sends a "touch" command to update the expiration time of the given key without modifying its value.
 // It returns an error if the command fails or if the key is not acknowledged.
 func (c *Client) Touch(key string, expiration int) (err error) {
-	server, err := c.pickServer(key)
+	defer c.hook("Touch", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(key)
 	if err != nil {
 		return
 	}
@@ -316,16 +1320,23 @@ func (c *Client) Touch(key string, expiration int) (err error) {
 		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
-	if resp != "OK" {
+	if !touched(resp) {
 		err = ErrStoreFailed
 		return
 	}
 	return nil
 }
 
+// touched reports whether a response line acknowledges a "touch" command.
+// Memcached answers "TOUCHED"; some compatible servers answer "OK".
+func touched(line string) bool {
+	return hasStatus(line, "TOUCHED") || hasStatus(line, "OK")
+}
+
 // Stats retrieves statistics from the memcached server identified by the given address.
 // It returns a map of stat keys and values, along with any error encountered.
 func (c *Client) Stats(addr string) (stats map[string]string, err error) {
+	defer c.hookServer("Stats", addr, &err)()
 	server, _, err := c.pickServerFromAddr(addr)
 	if err != nil {
 		return
@@ -333,11 +1344,51 @@ func (c *Client) Stats(addr string) (stats map[string]string, err error) {
 	return server.GetStats()
 }
 
+// ConnectionInfo reports the number of open connections of the memcached server identified by the given address
+// and the maximum it accepts, taken from "curr_connections" of the general stats and "maxconns" of the settings stats.
+// It returns ErrUnexpectedResponse if either field is missing.
+func (c *Client) ConnectionInfo(addr string) (current, maximum int, err error) {
+	defer c.hookServer("ConnectionInfo", addr, &err)()
+	server, _, err := c.pickServerFromAddr(addr)
+	if err != nil {
+		return
+	}
+	stats, err := server.GetStats()
+	if err != nil {
+		return
+	}
+	settings, err := server.GetStatsGroup("settings")
+	if err != nil {
+		return
+	}
+	current, err = intStat(stats, "curr_connections")
+	if err != nil {
+		return
+	}
+	maximum, err = intStat(settings, "maxconns")
+	return
+}
+
+// TypedStats retrieves statistics from the memcached server identified by the given address and parses them into TypedStats.
+// It returns the parsed stats, along with any error encountered.
+func (c *Client) TypedStats(addr string) (stats *TypedStats, err error) {
+	raw, err := c.Stats(addr)
+	if err != nil {
+		return
+	}
+	return ParseStats(raw)
+}
+
 // StatsAll retrieves and merges statistics from all memcached servers in the client.
 // It returns a merged map of stat keys and values, along with any error encountered.
 func (c *Client) StatsAll() (mergedStats map[string]string, err error) {
+	defer c.hook("StatsAll", "", &err)()
+	if err = c.checkClosed(); err != nil {
+		return
+	}
 	mergedStats = make(map[string]string)
-	for _, server := range c.servers {
+	servers := c.snapshotServers()
+	for _, server := range servers {
 		stats, err := server.GetStats()
 		if err != nil {
 			err = errors.Join(ErrWriteFailed, err)
@@ -352,9 +1403,40 @@ func (c *Client) StatsAll() (mergedStats map[string]string, err error) {
 	return
 }
 
+// CrawlExpired starts the LRU crawler on the memcached server identified by the given address
+// with a "lru_crawler crawl all" command, which reclaims the memory of expired items in every slab class without flushing live data.
+// The crawl runs in the background on the server. It returns ErrCrawlerBusy if a crawl is already in progress.
+func (c *Client) CrawlExpired(addr string) (err error) {
+	defer c.hookServer("CrawlExpired", addr, &err)()
+	server, _, err := c.pickServerFromAddr(addr)
+	if err != nil {
+		return
+	}
+	// lru_crawler crawl <classid,classid,...|all>\r\n
+	resp, err := server.WriteCommand("lru_crawler crawl all\r\n")
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	token, _, _ := strings.Cut(resp, " ")
+	switch token {
+	case "OK":
+		return
+	case "NOTSTARTED":
+		// There is nothing to crawl, which is not a failure.
+		return
+	case "BUSY":
+		err = ErrCrawlerBusy
+		return
+	}
+	err = errors.Join(ErrUnexpectedResponse, errors.New(resp))
+	return
+}
+
 // Version retrieves the version string from the memcached server identified by the given address.
 // It sends a "version" command and returns the trimmed version string or an error.
 func (c *Client) Version(addr string) (version string, err error) {
+	defer c.hookServer("Version", addr, &err)()
 	server, _, err := c.pickServerFromAddr(addr)
 	if err != nil {
 		return
@@ -366,15 +1448,20 @@ func (c *Client) Version(addr string) (version string, err error) {
 		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
-	version = strings.TrimSpace(resp)
+	version = trimControlLine(resp)
 	return
 }
 
 // Versions retrieves the version strings from all memcached servers in the client.
 // It returns a map where the key is the server address and the value is its version string.
 func (c *Client) Versions() (versions map[string]string, err error) {
+	defer c.hook("Versions", "", &err)()
+	if err = c.checkClosed(); err != nil {
+		return
+	}
 	versions = make(map[string]string)
-	for _, server := range c.servers {
+	servers := c.snapshotServers()
+	for _, server := range servers {
 		// version\r\n
 		command := "version\r\n"
 		resp, err := server.WriteCommand(command)
@@ -382,38 +1469,201 @@ func (c *Client) Versions() (versions map[string]string, err error) {
 			err = errors.Join(ErrWriteFailed, err)
 			return nil, err
 		}
-		version := strings.TrimSpace(resp)
+		version := trimControlLine(resp)
 		versions[server.Address] = version
 	}
 	return
 }
 
+// PingLatency measures the round-trip latency to every memcached server in parallel.
+// Each probe is bounded by a timeout, so an unresponsive server is reported as an error instead of blocking.
+// It returns a map where the key is the server address and the value is its latency; failed servers are omitted and their errors are joined.
+func (c *Client) PingLatency() (latencies map[string]time.Duration, err error) {
+	defer c.hook("PingLatency", "", &err)()
+	if err = c.checkClosed(); err != nil {
+		return
+	}
+	servers := c.snapshotServers()
+
+	type result struct {
+		addr string
+		rtt  time.Duration
+		err  error
+	}
+	// The channel is buffered so that probes finishing after the timeout do not leak.
+	results := make(chan result, len(servers))
+	for _, server := range servers {
+		go func(s *Server) {
+			rtt, err := s.Ping(defaultPingTimeout)
+			results <- result{addr: s.Address, rtt: rtt, err: err}
+		}(server)
+	}
+
+	latencies = make(map[string]time.Duration)
+	pending := make(map[string]struct{}, len(servers))
+	for _, server := range servers {
+		pending[server.Address] = struct{}{}
+	}
+	// The server lock may be held by another operation, so also bound the wait itself.
+	timer := time.NewTimer(defaultPingTimeout + defaultPingTimeout/2)
+	defer timer.Stop()
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.addr)
+			if r.err != nil {
+				err = errors.Join(err, fmt.Errorf("%s: %w", r.addr, r.err))
+				continue
+			}
+			latencies[r.addr] = r.rtt
+		case <-timer.C:
+			for addr := range pending {
+				err = errors.Join(err, fmt.Errorf("%s: %w", addr, ErrTimeout))
+			}
+			return
+		}
+	}
+	return
+}
+
+// Watch streams the "watch" log of the memcached server identified by the given address to fn.
+// The classes select the event types, e.g. "fetchers", "mutations", or "evictions". Streaming stops when fn returns false or ctx is cancelled.
+// It uses a dedicated connection, so regular commands to the server are not blocked.
+func (c *Client) Watch(ctx context.Context, addr string, classes []string, fn func(line string) bool) (err error) {
+	defer c.hookServer("Watch", addr, &err)()
+	server, _, err := c.pickServerFromAddr(addr)
+	if err != nil {
+		return
+	}
+	return server.Watch(ctx, classes, fn)
+}
+
+// AddServer connects to the memcached server of the given address and adds it to the client's server list,
+// e.g. when service discovery reports a new node. The server gets the settings of the client and weight 1,
+// and the keys it takes over are no longer found on their previous server; with WithWeightedRing, only about
+// 1/n of the keys move, while the default modulo placement moves most of them. Quit removes a server again.
+// Operations in progress keep using the server list they started with.
+// It returns ErrDuplicateServer if the address is already in the list, once normalized like WithServers does,
+// ErrClosed if the client is closed, and the error of dialing the server otherwise.
+func (c *Client) AddServer(addr string) (err error) {
+	defer c.hookServer("AddServer", addr, &err)()
+	if err = c.checkNewServer(addr); err != nil {
+		return
+	}
+	if err = checkLocalAddr(c.connConfig.localAddr, addr); err != nil {
+		return
+	}
+	// The server is dialed without the lock, so that operations are not blocked by a slow dial.
+	server, err := c.connectServer(addr)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	// Another call may have added the address, or closed the client, during the dial.
+	if err = c.checkNewServerLocked(addr); err == nil {
+		// Build a new slice so that callers still holding the previous one are not affected.
+		servers := make([]*Server, 0, len(c.servers)+1)
+		servers = append(servers, c.servers...)
+		c.servers = append(servers, server)
+		c.rebuildRing()
+	}
+	c.mu.Unlock()
+	if err != nil {
+		server.Close()
+	}
+	return
+}
+
+// checkNewServer returns an error if a server of the given address cannot be added to the client (see AddServer).
+func (c *Client) checkNewServer(addr string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.checkNewServerLocked(addr)
+}
+
+// checkNewServerLocked is checkNewServer for callers holding the lock.
+func (c *Client) checkNewServerLocked(addr string) error {
+	if c.closed {
+		return ErrClosed
+	}
+	normalized := normalizeAddress(addr)
+	for _, server := range c.servers {
+		if normalizeAddress(server.Address) == normalized {
+			return errors.Join(ErrDuplicateServer, fmt.Errorf("address %q", addr))
+		}
+	}
+	return nil
+}
+
 // Quit closes the connection to the memcached server identified by the given address,
 // removes it from the client's server list, and returns an error if any.
 func (c *Client) Quit(addr string) (err error) {
-	_, index, err := c.pickServerFromAddr(addr)
-	if err != nil {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		err = ErrClosed
+		return
+	}
+	var server *Server
+	for i, s := range c.servers {
+		if s.Address == addr {
+			server = s
+			// Build a new slice so that callers still holding the previous one are not affected.
+			servers := make([]*Server, 0, len(c.servers)-1)
+			servers = append(servers, c.servers[:i]...)
+			c.servers = append(servers, c.servers[i+1:]...)
+			c.rebuildRing()
+			break
+		}
+	}
+	c.mu.Unlock()
+	if server == nil {
+		err = ErrNotFound
 		return
 	}
-	c.servers[index].Close()
-	c.servers = append(c.servers[:index], c.servers[index+1:]...)
+	server.Close()
 	return
 }
 
 // QuitAll closes the connections to all memcached servers and clears the server list.
+// Afterwards, the client is closed and every operation returns ErrClosed.
 // It returns an error if any operation fails.
 func (c *Client) QuitAll() (err error) {
-	for _, server := range c.servers {
+	c.mu.Lock()
+	servers := c.servers
+	c.servers = nil
+	c.closed = true
+	c.rebuildRing()
+	c.mu.Unlock()
+	for _, server := range servers {
 		server.Close()
 	}
-	c.servers = nil
+	return
+}
+
+// Close stops the background goroutines of the client, such as the health check,
+// and closes the connections to all memcached servers.
+// It returns an error if any operation fails.
+func (c *Client) Close() (err error) {
+	c.closeOnce.Do(func() {
+		if c.stop != nil {
+			close(c.stop)
+		}
+	})
+	err = c.QuitAll()
+	c.wg.Wait()
 	return
 }
 
 // Verbosity sends a "verbosity" command to all memcached servers to adjust their logging level.
 // It returns an error if any server fails to acknowledge the command.
 func (c *Client) Verbosity(level int) (err error) {
-	for _, server := range c.servers {
+	defer c.hook("Verbosity", "", &err)()
+	if err = c.checkClosed(); err != nil {
+		return
+	}
+	servers := c.snapshotServers()
+	for _, server := range servers {
 		// verbosity <level>\r\n
 		command := fmt.Sprintf("verbosity %d\r\n", level)
 		resp, err := server.WriteCommand(command)
@@ -421,7 +1671,7 @@ func (c *Client) Verbosity(level int) (err error) {
 			err = errors.Join(ErrWriteFailed, err)
 			return err
 		}
-		if resp != "OK" {
+		if !hasStatus(resp, "OK") {
 			err = ErrStoreFailed
 			return err
 		}
@@ -430,16 +1680,59 @@ func (c *Client) Verbosity(level int) (err error) {
 }
 
 // Extra sends a custom command (provided by cmd) to the memcached server identified by the given address.
+// It is meant for commands whose response ends with an "END" line, such as "stats" or "get"; commands answered by a
+// single line, such as "version" or "verbosity", never send "END" and must be sent with ExtraLine instead.
+// With WithExtraAllowlist, commands that are not allowed are rejected with ErrCommandNotAllowed,
+// and WithExtraTimeout bounds the whole command. Error responses are reported like Server.Extra does.
 // It returns the response from the server and an error if any.
 func (c *Client) Extra(addr string, cmd string) (res string, err error) {
+	defer c.hookServer("Extra", addr, &err)()
+	if err = c.checkExtra(cmd); err != nil {
+		return
+	}
 	s, _, err := c.pickServerFromAddr(addr)
 	if err != nil {
 		return
 	}
 	res, err = s.Extra(cmd)
+	err = extraError(err)
+	return
+}
+
+// extraError reports transport failures of Extra and ExtraLine as ErrWriteFailed, so callers can check both the same way.
+// Error responses of the server mean the command was sent and are returned as is.
+func extraError(err error) error {
+	if isNetworkError(err) && !errors.Is(err, ErrWriteFailed) {
+		return errors.Join(ErrWriteFailed, err)
+	}
+	return err
+}
+
+// ExtraLine sends a custom command answered by a single line, such as "version" or "verbosity",
+// to the memcached server identified by the given address, and returns that line.
+// WithExtraAllowlist and WithExtraTimeout apply like for Extra, and errors are reported like Extra does.
+func (c *Client) ExtraLine(addr string, cmd string) (res string, err error) {
+	defer c.hookServer("ExtraLine", addr, &err)()
+	if err = c.checkExtra(cmd); err != nil {
+		return
+	}
+	s, _, err := c.pickServerFromAddr(addr)
 	if err != nil {
-		err = errors.Join(ErrWriteFailed, err)
-		return res, err
+		return
+	}
+	res, err = s.ExtraLine(cmd)
+	err = extraError(err)
+	return
+}
+
+// checkExtra returns ErrCommandNotAllowed if WithExtraAllowlist is configured and does not allow the command.
+func (c *Client) checkExtra(cmd string) (err error) {
+	if c.extraAllowlist == nil {
+		return
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(cmd), " ")
+	if !c.extraAllowlist[name] {
+		err = ErrCommandNotAllowed
 	}
 	return
 }
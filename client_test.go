@@ -0,0 +1,599 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPickServerConcurrentWithQuit(t *testing.T) {
+	const n = 8
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = newFakeServer(t).addr()
+	}
+	c := newTestClient(t, addrs)
+	var wg, ready sync.WaitGroup
+	stop := make(chan struct{})
+	for g := range 4 {
+		wg.Add(1)
+		ready.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				if i == 100 {
+					ready.Done()
+				}
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("key-%d-%d", g, i)
+				if _, err := c.pickServer(key); err != nil && !errors.Is(err, ErrNoServers) {
+					t.Errorf("pickServer(%q) error = %v", key, err)
+				}
+				c.pickServerFromAddr(addrs[i%n])
+			}
+		}()
+	}
+	// The servers quit while every goroutine is picking servers.
+	ready.Wait()
+	for _, addr := range addrs {
+		if err := c.Quit(addr); err != nil {
+			t.Errorf("Quit(%q) error = %v", addr, err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if _, err := c.pickServer("key"); !errors.Is(err, ErrNoServers) {
+		t.Errorf("pickServer() error = %v after every server quit, want ErrNoServers", err)
+	}
+}
+
+func TestCRC32TableVectors(t *testing.T) {
+	addrs := []string{newFakeServer(t).addr(), newFakeServer(t).addr(), newFakeServer(t).addr()}
+	// "123456789" is the check input of CRC32: 0xCBF43926 with the IEEE polynomial and 0xE3069283 with Castagnoli.
+	// Clients placing keys by CRC32 modulo the number of servers put it on the third and the first server respectively.
+	tests := []struct {
+		name  string
+		table *crc32.Table
+		hash  uint32
+		index int
+	}{
+		{"IEEE", crc32.IEEETable, 0xCBF43926, 2},
+		{"Castagnoli", crc32.MakeTable(crc32.Castagnoli), 0xE3069283, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, addrs, WithCRC32Table(tt.table))
+			if got := c.hash("123456789"); got != tt.hash {
+				t.Errorf("hash() = %#x, want %#x", got, tt.hash)
+			}
+			servers, err := c.pickServers("123456789", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if servers[0].Address != addrs[tt.index] {
+				t.Errorf("pickServers() = %s, want server %d (%s)", servers[0].Address, tt.index, addrs[tt.index])
+			}
+		})
+	}
+	if _, err := NewClientWithOptions(WithServers(addrs...), WithCRC32Table(nil)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithCRC32Table(nil) error = %v, want ErrInvalidOption", err)
+	}
+}
+
+func TestMinHealthyServersValidation(t *testing.T) {
+	addrs := []string{newFakeServer(t).addr(), newFakeServer(t).addr()}
+	for _, n := range []int{-1, 0, 3} {
+		if _, err := NewClientWithOptions(WithServers(addrs...), WithMinHealthyServers(n)); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("WithMinHealthyServers(%d) error = %v with %d servers, want ErrInvalidOption", n, err, len(addrs))
+		}
+	}
+	newTestClient(t, addrs, WithMinHealthyServers(len(addrs)))
+}
+
+func TestIncrementNearUint64Boundary(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	if err := c.Set("counter", "18446744073709551614", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.IncrementUint64("counter", 1); err != nil || got != math.MaxUint64 {
+		t.Fatalf("IncrementUint64() = %d, %v; want %d", got, err, uint64(math.MaxUint64))
+	}
+	// memcached counters wrap around at 2^64.
+	if got, err := c.IncrementUint64("counter", 2); err != nil || got != 1 {
+		t.Fatalf("IncrementUint64() = %d, %v past 2^64; want 1", got, err)
+	}
+	if err := c.Set("counter", "18446744073709551615", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.DecrementUint64("counter", math.MaxUint64-1); err != nil || got != 1 {
+		t.Fatalf("DecrementUint64() = %d, %v; want 1", got, err)
+	}
+}
+
+func TestNegativeDeltaRejected(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	if err := c.Set("counter", "10", 0); err != nil {
+		t.Fatal(err)
+	}
+	for name, op := range map[string]func(key string, delta int) (uint64, error){"Increment": c.Increment, "Decrement": c.Decrement} {
+		if _, err := op("counter", -1); !errors.Is(err, ErrNegativeDelta) {
+			t.Errorf("%s() error = %v for a negative delta, want ErrNegativeDelta", name, err)
+		}
+	}
+	// The negative deltas were never formatted into commands.
+	for _, cmd := range s.received() {
+		if strings.HasPrefix(cmd, "incr ") || strings.HasPrefix(cmd, "decr ") {
+			t.Errorf("a negative delta was sent as %q", cmd)
+		}
+	}
+	if value, _, _ := s.stored("counter"); value != "10" {
+		t.Errorf("counter = %q, want it unchanged", value)
+	}
+}
+
+func TestParseCounter(t *testing.T) {
+	if got, err := parseCounter("18446744073709551615"); err != nil || got != math.MaxUint64 {
+		t.Errorf("parseCounter() = %d, %v; want %d", got, err, uint64(math.MaxUint64))
+	}
+	for _, resp := range []string{"18446744073709551616", "-1", "", "12abc", "CLIENT_ERROR cannot increment or decrement non-numeric value"} {
+		if _, err := parseCounter(resp); !errors.Is(err, ErrUnexpectedResponse) {
+			t.Errorf("parseCounter(%q) error = %v, want ErrUnexpectedResponse", resp, err)
+		}
+	}
+}
+
+func TestFlushAllConcurrentWithTopologyChanges(t *testing.T) {
+	servers := make([]*fakeServer, 4)
+	addrs := make([]string, len(servers))
+	for i := range servers {
+		servers[i] = newFakeServer(t)
+		addrs[i] = servers[i].addr()
+	}
+	c := newTestClient(t, addrs)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for _, flush := range []func() error{
+		func() error { return c.FlushAll(0) },
+		func() error { return c.FlushAllConcurrent(0) },
+		func() error { _, err := c.StatsAll(); return err },
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// A server removed during the command may already be closed.
+				if err := flush(); err != nil && !errors.Is(err, ErrClosed) {
+					t.Errorf("error = %v while servers are removed and added", err)
+				}
+			}
+		}()
+	}
+	for i := range 50 {
+		addr := addrs[i%len(addrs)]
+		if err := c.Quit(addr); err != nil {
+			t.Fatalf("Quit(%q) error = %v", addr, err)
+		}
+		if err := c.AddServer(addr); err != nil {
+			t.Fatalf("AddServer(%q) error = %v", addr, err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if err := c.FlushAll(0); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range servers {
+		if cmds := s.received(); len(cmds) == 0 || cmds[len(cmds)-1] != "flush_all 0" {
+			t.Errorf("server %d did not receive the last flush", i)
+		}
+	}
+}
+
+func TestValueSpacesRoundTrip(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	const value = "  padded value \t "
+	if err := c.Set("key", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("key"); err != nil || got != value {
+		t.Errorf("Get() = %q, %v; want %q", got, err, value)
+	}
+	if got, _, err := c.Gets("key"); err != nil || got != value {
+		t.Errorf("Gets() = %q, %v; want %q", got, err, value)
+	}
+	if item, found, err := c.GetFull("key"); err != nil || !found || string(item.Value) != value {
+		t.Errorf("GetFull() = %v, %v, %v; want %q", item, found, err, value)
+	}
+	if got, err := c.GetMulti([]string{"key"}); err != nil || got["key"] != value {
+		t.Errorf("GetMulti() = %q, %v; want %q", got, err, value)
+	}
+	if got, err := c.Extra(s.addr(), "get key\r\n"); err != nil || !strings.Contains(got, value) {
+		t.Errorf("Extra() = %q, %v; want the data block %q", got, err, value)
+	}
+}
+
+func BenchmarkGetHotKey(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{
+		{"SingleConnection", []Option{WithSingleConnection()}},
+		{"Pool", []Option{WithPool(8)}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			s := newFakeServer(b)
+			// A round trip on loopback is too fast for the connections to matter without some server latency.
+			s.delay.Store(int64(100 * time.Microsecond))
+			c := newTestClient(b, []string{s.addr()}, bm.opts...)
+			if err := c.Set("hot", "value", 0); err != nil {
+				b.Fatal(err)
+			}
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := c.Get("hot"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestDuplicateServerAddresses(t *testing.T) {
+	s, other := newFakeServer(t), newFakeServer(t)
+	_, port, _ := strings.Cut(s.addr(), ":")
+	addrs := []string{"localhost:" + port, other.addr(), " LOCALHOST:" + port + " "}
+
+	c := newTestClient(t, addrs)
+	if len(c.servers) != 2 {
+		t.Fatalf("the client has %d servers for %q, want 2", len(c.servers), addrs)
+	}
+	if got := s.accepted.Load(); got != 1 {
+		t.Errorf("the repeated server accepted %d connections, want 1", got)
+	}
+	if err := c.AddServer("LocalHost:" + port); !errors.Is(err, ErrDuplicateServer) {
+		t.Errorf("AddServer() error = %v for a known server, want ErrDuplicateServer", err)
+	}
+
+	if _, err := NewClientWithOptions(WithServers(addrs...), WithRejectDuplicateServers()); !errors.Is(err, ErrDuplicateServer) {
+		t.Errorf("NewClientWithOptions() error = %v with WithRejectDuplicateServers, want ErrDuplicateServer", err)
+	}
+}
+
+func TestAddServer(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithCircuitBreaker(1, time.Minute))
+
+	// Nothing listens on the address of a closed listener, so the dial fails and the server is not added.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := ln.Addr().String()
+	ln.Close()
+	if err := c.AddServer(down); err == nil {
+		t.Error("AddServer() succeeded for an unreachable server")
+	}
+	if _, _, err := c.pickServerFromAddr(down); !errors.Is(err, ErrNotFound) || len(c.servers) != 1 {
+		t.Errorf("the unreachable server was added: %d servers", len(c.servers))
+	}
+
+	added := newFakeServer(t)
+	if err := c.AddServer(added.addr()); err != nil {
+		t.Fatal(err)
+	}
+	server, _, err := c.pickServerFromAddr(added.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The added server gets the settings of the client.
+	if server.breaker == nil {
+		t.Error("the added server has no circuit breaker")
+	}
+	key := ""
+	for i := 0; key == ""; i++ {
+		if picked, _ := c.pickServer(fmt.Sprint("key-", i)); picked == server {
+			key = fmt.Sprint("key-", i)
+		}
+	}
+	if err := c.Set(key, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, ok := added.stored(key); !ok || value != "value" {
+		t.Errorf("the added server holds %q, %v for a key routed to it", value, ok)
+	}
+
+	c.Close()
+	if err := c.AddServer(newFakeServer(t).addr()); !errors.Is(err, ErrClosed) {
+		t.Errorf("AddServer() error = %v after Close, want ErrClosed", err)
+	}
+}
+
+func TestWithHasherRoutesKeys(t *testing.T) {
+	servers := []*fakeServer{newFakeServer(t), newFakeServer(t), newFakeServer(t)}
+	addrs := []string{servers[0].addr(), servers[1].addr(), servers[2].addr()}
+	// The stub hash makes the placement of every key known in advance: the hash modulo the number of servers.
+	hashes := map[string]uint32{"zero": 0, "one": 1, "two": 2, "five": 5, "nine": 9}
+	c := newTestClient(t, addrs, WithHasher(func(key string) uint32 { return hashes[key] }))
+	for key, hash := range hashes {
+		want := int(hash) % len(addrs)
+		picked, err := c.pickServers(key, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if picked[0].Address != addrs[want] {
+			t.Errorf("pickServers(%q) = %s, want server %d (%s)", key, picked[0].Address, want, addrs[want])
+		}
+		if err = c.Set(key, "value", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "zero" and "nine" share the first server, "one" is alone on the second, "two" and "five" share the third.
+	for i, want := range []int{2, 1, 2} {
+		if got := servers[i].itemCount(); got != want {
+			t.Errorf("server %d holds %d items, want %d", i, got, want)
+		}
+	}
+	if _, err := NewClientWithOptions(WithServers(addrs...), WithHasher(nil)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithHasher(nil) error = %v, want ErrInvalidOption", err)
+	}
+}
+
+func TestPingLatencyUnresponsiveServer(t *testing.T) {
+	healthy := newFakeServer(t).addr()
+	// The server reads the commands but never answers them.
+	silent := newScriptedServer(t, func(line string) string { return "" })
+	c := newTestClient(t, []string{healthy, silent})
+	start := time.Now()
+	latencies, err := c.PingLatency()
+	if elapsed := time.Since(start); elapsed > defaultPingTimeout+defaultPingTimeout/2+time.Second {
+		t.Errorf("PingLatency() returned after %v, want the probes bounded by %v", elapsed, defaultPingTimeout)
+	}
+	if rtt, ok := latencies[healthy]; !ok || rtt <= 0 {
+		t.Errorf("PingLatency()[%s] = %v, %v; want a positive latency", healthy, rtt, ok)
+	}
+	if _, ok := latencies[silent]; ok {
+		t.Errorf("PingLatency() reported a latency for the unresponsive server %s", silent)
+	}
+	if !errors.Is(err, ErrReadFailed) || !strings.Contains(err.Error(), silent) {
+		t.Errorf("PingLatency() error = %v, want ErrReadFailed for %s", err, silent)
+	}
+}
+
+func TestReadRetries(t *testing.T) {
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithReadRetries(0)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithReadRetries(0) error = %v, want ErrInvalidOption", err)
+	}
+	fakes := map[string]*fakeServer{}
+	var addrs []string
+	for range 2 {
+		s := newFakeServer(t)
+		fakes[s.addr()] = s
+		addrs = append(addrs, s.addr())
+	}
+	c := newTestClient(t, addrs, WithReadRetries(1), WithCircuitBreaker(2, time.Minute))
+	servers, err := c.pickServers("key", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, replica := servers[0], servers[1]
+	if _, err = replica.WriteCommand("set key 0 0 7\r\nreplica\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	// A miss is an answer, so it is not retried on the replica.
+	if _, err = c.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() error = %v for a miss on the primary, want ErrNotFound", err)
+	}
+
+	fakes[primary.Address].close()
+	for i := range 3 {
+		if value, err := c.Get("key"); err != nil || value != "replica" {
+			t.Errorf("Get() #%d = %q, %v with the primary down, want the value of the replica", i, value, err)
+		}
+	}
+	// The failed reads opened the breaker, so the primary is skipped instead of being dialed again.
+	if state := primary.State(); state != BreakerOpen {
+		t.Errorf("primary breaker is %v, want open", state)
+	}
+	// Writes are never re-routed.
+	if err = c.Set("key", "value", 0); err == nil {
+		t.Error("Set() succeeded with the primary down, want an error")
+	}
+	if value, _, _ := fakes[replica.Address].stored("key"); value != "replica" {
+		t.Errorf("the replica holds %q after a failed write, want it unchanged", value)
+	}
+}
+
+func TestSize(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	if err := c.Set("key", "hello", 0); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := c.Size("key"); err != nil || size != 5 {
+		t.Errorf("Size() = %d, %v; want 5", size, err)
+	}
+	if _, err := c.Size("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Size() error = %v for a miss, want ErrNotFound", err)
+	}
+	// The size came from meta gets, without transferring the value.
+	for _, cmd := range s.received() {
+		if strings.HasPrefix(cmd, "get ") {
+			t.Errorf("Size() sent %q", cmd)
+		}
+	}
+
+	// A server without meta commands answers "mg" with ERROR, so Size falls back to a full get.
+	addr := newScriptedServer(t, func(line string) string {
+		switch line {
+		case "mg bogus s":
+			return "HD sbogus\r\n"
+		case "get key":
+			return "VALUE key 0 3\r\nabc\r\nEND\r\n"
+		case "get missing":
+			return "END\r\n"
+		}
+		if strings.HasPrefix(line, "mg ") {
+			return "ERROR\r\n"
+		}
+		return ""
+	})
+	c = newTestClient(t, []string{addr})
+	if size, err := c.Size("key"); err != nil || size != 3 {
+		t.Errorf("Size() = %d, %v without meta commands; want 3", size, err)
+	}
+	if _, err := c.Size("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Size() error = %v for a miss without meta commands, want ErrNotFound", err)
+	}
+	if _, err := c.Size("bogus"); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("Size() error = %v for a malformed size, want ErrUnexpectedResponse", err)
+	}
+}
+
+func TestCrawlExpiredResponses(t *testing.T) {
+	var mu sync.Mutex
+	var reply string
+	addr := newScriptedServer(t, func(line string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if line != "lru_crawler crawl all" {
+			return "ERROR\r\n"
+		}
+		return reply + "\r\n"
+	})
+	c := newTestClient(t, []string{addr})
+	tests := []struct {
+		reply string
+		want  error
+	}{
+		{"OK", nil},
+		// Nothing to crawl is not a failure.
+		{"NOTSTARTED", nil},
+		{"BUSY currently processing crawler request", ErrCrawlerBusy},
+		{"ERROR", ErrUnexpectedResponse},
+		{"CLIENT_ERROR lru crawler disabled", ErrUnexpectedResponse},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		reply = tt.reply
+		mu.Unlock()
+		if err := c.CrawlExpired(addr); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("CrawlExpired() error = %v for %q, want %v", err, tt.reply, tt.want)
+		}
+	}
+	if err := c.CrawlExpired("127.0.0.1:1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CrawlExpired() error = %v for an unknown address, want ErrNotFound", err)
+	}
+}
+
+func TestErrClosedAfterClose(t *testing.T) {
+	// A client whose servers all quit has no servers, but it is not closed.
+	addr := newFakeServer(t).addr()
+	c := newTestClient(t, []string{addr})
+	if err := c.Quit(addr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("key"); !errors.Is(err, ErrNoServers) || errors.Is(err, ErrClosed) {
+		t.Errorf("Get() error = %v without servers, want ErrNoServers", err)
+	}
+
+	for name, closeClient := range map[string]func(c *Client) error{"Close": (*Client).Close, "QuitAll": (*Client).QuitAll} {
+		addr := newFakeServer(t).addr()
+		c := newTestClient(t, []string{addr})
+		if err := closeClient(c); err != nil {
+			t.Fatal(err)
+		}
+		ops := map[string]func() error{
+			"Get":         func() error { _, err := c.Get("key"); return err },
+			"Set":         func() error { return c.Set("key", "value", 0) },
+			"Delete":      func() error { return c.Delete("key") },
+			"Increment":   func() error { _, err := c.Increment("key", 1); return err },
+			"GetMulti":    func() error { _, err := c.GetMulti([]string{"key"}); return err },
+			"FlushAll":    func() error { return c.FlushAll(0) },
+			"StatsAll":    func() error { _, err := c.StatsAll(); return err },
+			"Version":     func() error { _, err := c.Version(addr); return err },
+			"PingLatency": func() error { _, err := c.PingLatency(); return err },
+			"AddServer":   func() error { return c.AddServer(addr) },
+		}
+		for op, fn := range ops {
+			if err := fn(); !errors.Is(err, ErrClosed) || errors.Is(err, ErrNoServers) {
+				t.Errorf("%s() error = %v after %s, want ErrClosed", op, err, name)
+			}
+		}
+	}
+}
+
+func TestFlushAllFanOut(t *testing.T) {
+	fakes := []*fakeServer{newFakeServer(t), newFakeServer(t)}
+	// A server with flushing disabled rejects the command.
+	refusing := newScriptedServer(t, func(line string) string {
+		switch {
+		case strings.HasPrefix(line, "flush_all"):
+			return "CLIENT_ERROR flush_all not allowed\r\n"
+		case line == "mn":
+			return "MN\r\n"
+		case line == "version":
+			return "VERSION 1.6.21\r\n"
+		}
+		return "ERROR\r\n"
+	})
+	c := newTestClient(t, []string{fakes[0].addr(), refusing, fakes[1].addr()})
+	fill := func() {
+		t.Helper()
+		for _, s := range fakes {
+			if _, err := newTestClient(t, []string{s.addr()}).servers[0].WriteCommand("set key 0 0 5\r\nvalue\r\n"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	fill()
+	// Every server is flushed, even after the refusing one failed.
+	err := c.FlushAllConcurrent(0)
+	if !errors.Is(err, ErrStoreFailed) || !strings.Contains(err.Error(), refusing) {
+		t.Errorf("FlushAllConcurrent() error = %v, want ErrStoreFailed for %s", err, refusing)
+	}
+	for _, s := range fakes {
+		if n := s.itemCount(); n != 0 {
+			t.Errorf("%s holds %d items after FlushAllConcurrent", s.addr(), n)
+		}
+	}
+
+	fill()
+	// Without replies the refusal goes unnoticed, and its error line is not taken for the response of the next command.
+	if err = c.FlushAllNoReply(0); err != nil {
+		t.Errorf("FlushAllNoReply() error = %v", err)
+	}
+	if version, err := c.Version(refusing); err != nil || version != "VERSION 1.6.21" {
+		t.Errorf("Version() = %q, %v after FlushAllNoReply", version, err)
+	}
+	for _, s := range fakes {
+		for deadline := time.Now().Add(time.Second); s.itemCount() != 0 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		if n := s.itemCount(); n != 0 {
+			t.Errorf("%s holds %d items after FlushAllNoReply", s.addr(), n)
+		}
+	}
+}
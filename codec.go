@@ -0,0 +1,221 @@
+package memcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Flags values marking the serialization format of a stored value.
+const (
+	FlagRaw      uint32 = 0 // The value is stored as is.
+	FlagJSON     uint32 = 1 // The value is JSON.
+	FlagGob      uint32 = 2 // The value is gob-encoded.
+	FlagGzipJSON uint32 = 3 // The value is gzip-compressed JSON.
+	FlagObject   uint32 = 4 // The value was encoded by the codec of the client (see WithCodec and Client.SetObject).
+	FlagTime     uint32 = 5 // The value is a time in UTC formatted with time.RFC3339Nano (see Client.SetTime).
+)
+
+// FlagCompressed is set in the flags of values that were gzip-compressed after encoding (see WithCompression).
+// It is combined with the flags of the codec, and such values are decompressed before they are decoded.
+// Every getter decompresses values marked with it, so the flag must not be set for other purposes.
+const FlagCompressed uint32 = 1 << 16
+
+// Marshaler encodes Go values into the bytes stored in memcached.
+type Marshaler interface {
+	Marshal(v any) ([]byte, error)
+}
+
+// Unmarshaler decodes the bytes stored in memcached back into Go values.
+type Unmarshaler interface {
+	Unmarshal(data []byte, v any) error
+}
+
+// Codec encodes Go values into the bytes stored in memcached and decodes them back.
+// Implementations can wrap any serialization library, such as msgpack or protobuf, without this package depending on it.
+type Codec interface {
+	Marshaler
+	Unmarshaler
+}
+
+// JSONCodec encodes values with encoding/json.
+var JSONCodec Codec = jsonCodec{}
+
+// GobCodec encodes values with encoding/gob.
+var GobCodec Codec = gobCodec{}
+
+// GzipJSONCodec encodes values with encoding/json and compresses them with gzip.
+var GzipJSONCodec Codec = gzipCodec{inner: jsonCodec{}}
+
+// CodecRegistry maps flags values to the codecs that encode and decode values stored with them.
+// It is safe for concurrent use.
+type CodecRegistry struct {
+	mu     sync.RWMutex
+	codecs map[uint32]Codec
+}
+
+// NewCodecRegistry creates a CodecRegistry with the built-in codecs registered
+// for FlagJSON, FlagGob and FlagGzipJSON.
+func NewCodecRegistry() *CodecRegistry {
+	return &CodecRegistry{
+		codecs: map[uint32]Codec{
+			FlagJSON:     JSONCodec,
+			FlagGob:      GobCodec,
+			FlagGzipJSON: GzipJSONCodec,
+		},
+	}
+}
+
+// DefaultCodecRegistry is the registry used by clients that are not configured with WithCodecRegistry.
+var DefaultCodecRegistry = NewCodecRegistry()
+
+// RegisterCodec registers codec for the given flags value in DefaultCodecRegistry.
+func RegisterCodec(flags uint32, codec Codec) {
+	DefaultCodecRegistry.RegisterCodec(flags, codec)
+}
+
+// RegisterCodec registers codec for the given flags value, replacing any codec registered before.
+// FlagRaw is reserved for values stored as is and cannot be registered.
+func (r *CodecRegistry) RegisterCodec(flags uint32, codec Codec) {
+	if flags == FlagRaw {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codecs[flags] = codec
+}
+
+// Codec returns the codec registered for the given flags value.
+func (r *CodecRegistry) Codec(flags uint32) (codec Codec, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	codec, ok = r.codecs[flags]
+	return
+}
+
+// Encode encodes v with the codec registered for the given flags value.
+// With FlagRaw, v must be a string or a []byte and is stored as is.
+// It returns ErrUnknownFlags if no codec handles the flags, and ErrEncodeFailed if encoding fails.
+func (r *CodecRegistry) Encode(v any, flags uint32) (data []byte, err error) {
+	if flags == FlagRaw {
+		switch value := v.(type) {
+		case string:
+			data = []byte(value)
+			return
+		case []byte:
+			data = value
+			return
+		}
+		err = ErrUnknownFlags
+		return
+	}
+	codec, ok := r.Codec(flags)
+	if !ok {
+		err = ErrUnknownFlags
+		return
+	}
+	data, err = codec.Marshal(v)
+	if err != nil {
+		err = errors.Join(ErrEncodeFailed, err)
+		return
+	}
+	return
+}
+
+// Decode decodes data into dest according to the flags it was stored with.
+// Values stored with FlagRaw can be decoded into a *string or a *[]byte.
+// It returns ErrUnknownFlags if no codec handles the flags, and ErrDecodeFailed if decoding fails.
+func (r *CodecRegistry) Decode(data []byte, flags uint32, dest any) (err error) {
+	if flags == FlagRaw {
+		switch d := dest.(type) {
+		case *string:
+			*d = string(data)
+			return
+		case *[]byte:
+			*d = data
+			return
+		}
+	}
+	codec, ok := r.Codec(flags)
+	if !ok {
+		err = ErrUnknownFlags
+		return
+	}
+	if err = codec.Unmarshal(data, dest); err != nil {
+		err = errors.Join(ErrDecodeFailed, err)
+		return
+	}
+	return
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// gzipCodec compresses the output of an inner codec with gzip.
+type gzipCodec struct {
+	inner Codec
+}
+
+func (c gzipCodec) Marshal(v any) ([]byte, error) {
+	data, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return compress(data)
+}
+
+func (c gzipCodec) Unmarshal(data []byte, v any) error {
+	decompressed, err := decompress(data)
+	if err != nil {
+		return err
+	}
+	return c.inner.Unmarshal(decompressed, v)
+}
+
+// compress compresses data with gzip.
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decompresses gzip-compressed data.
+func decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
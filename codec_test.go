@@ -0,0 +1,229 @@
+package memcache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestCompressionSkipsIncompressibleValues(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithCompression(64))
+	random := make([]byte, 4096)
+	rng := rand.NewChaCha8([32]byte{})
+	rng.Read(random)
+	compressible := bytes.Repeat([]byte("memcache "), 512)
+	tests := []struct {
+		name       string
+		value      []byte
+		compressed bool
+	}{
+		{"incompressible", random, false},
+		{"compressible", compressible, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.SetAuto(tt.name, tt.value, FlagRaw, 0); err != nil {
+				t.Fatal(err)
+			}
+			stored, flags, ok := s.stored(tt.name)
+			if !ok {
+				t.Fatal("the value was not stored")
+			}
+			if compressed := flags&FlagCompressed != 0; compressed != tt.compressed {
+				t.Errorf("stored with flags %#x, want compressed = %v", flags, tt.compressed)
+			}
+			if !tt.compressed && stored != string(tt.value) {
+				t.Error("the incompressible value was not stored as is")
+			}
+			if tt.compressed && len(stored) >= len(tt.value) {
+				t.Errorf("stored %d bytes for a value of %d bytes", len(stored), len(tt.value))
+			}
+			var got []byte
+			if err := c.GetAuto(tt.name, &got); err != nil || !bytes.Equal(got, tt.value) {
+				t.Errorf("GetAuto() = %d bytes, %v; want the %d bytes stored", len(got), err, len(tt.value))
+			}
+		})
+	}
+}
+
+func TestGetAutoDispatchesOnFlags(t *testing.T) {
+	type payload struct{ Name string }
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	store := func(key string, flags uint32, data []byte) {
+		t.Helper()
+		// set <key> <flags> <exptime> <bytes>\r\n<data>\r\n
+		if _, err := c.servers[0].WriteCommand(fmt.Sprintf("set %s %d 0 %d\r\n%s\r\n", key, flags, len(data), data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		flags uint32
+		codec Codec
+	}{{FlagJSON, JSONCodec}, {FlagGob, GobCodec}, {FlagGzipJSON, GzipJSONCodec}} {
+		data, err := tt.codec.Marshal(payload{Name: "memcache"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := fmt.Sprintf("flags-%d", tt.flags)
+		store(key, tt.flags, data)
+		var got payload
+		if err = c.GetAuto(key, &got); err != nil || got.Name != "memcache" {
+			t.Errorf("GetAuto() = %+v, %v for flags %d", got, err, tt.flags)
+		}
+	}
+	store("unknown", 99, []byte("value"))
+	store("corrupt", FlagJSON, []byte("{not json"))
+	for key, want := range map[string]error{"unknown": ErrUnknownFlags, "corrupt": ErrDecodeFailed, "missing": ErrNotFound} {
+		var got payload
+		if err := c.GetAuto(key, &got); !errors.Is(err, want) {
+			t.Errorf("GetAuto(%q) error = %v, want %v", key, err, want)
+		}
+	}
+}
+
+// reverseCodec stores strings reversed.
+type reverseCodec struct{}
+
+func (reverseCodec) Marshal(v any) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.New("not a string")
+	}
+	b := []byte(s)
+	slices.Reverse(b)
+	return b, nil
+}
+
+func (reverseCodec) Unmarshal(data []byte, v any) error {
+	b := slices.Clone(data)
+	slices.Reverse(b)
+	*v.(*string) = string(b)
+	return nil
+}
+
+func TestCodecRegistry(t *testing.T) {
+	const flagReverse uint32 = 10
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithCodecRegistry(nil)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithCodecRegistry(nil) error = %v, want ErrInvalidOption", err)
+	}
+	r := NewCodecRegistry()
+	r.RegisterCodec(flagReverse, reverseCodec{})
+	// FlagRaw is reserved, so registering it keeps values stored as is.
+	r.RegisterCodec(FlagRaw, reverseCodec{})
+	if _, ok := r.Codec(FlagRaw); ok {
+		t.Error("a codec was registered for FlagRaw")
+	}
+	if _, ok := DefaultCodecRegistry.Codec(flagReverse); ok {
+		t.Error("registering in a new registry changed DefaultCodecRegistry")
+	}
+
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithCodecRegistry(r))
+	if err := c.SetAuto("key", "memcache", flagReverse, 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, flags, _ := s.stored("key"); value != "ehcacmem" || flags != flagReverse {
+		t.Errorf("stored %q with flags %d, want the reversed value with flags %d", value, flags, flagReverse)
+	}
+	var got string
+	if err := c.GetAuto("key", &got); err != nil || got != "memcache" {
+		t.Errorf("GetAuto() = %q, %v", got, err)
+	}
+	for _, tt := range []struct {
+		v     any
+		flags uint32
+		want  error
+	}{
+		{"value", 99, ErrUnknownFlags},
+		{42, FlagRaw, ErrUnknownFlags},
+		{make(chan int), FlagJSON, ErrEncodeFailed},
+		{42, flagReverse, ErrEncodeFailed},
+	} {
+		if err := c.SetAuto("rejected", tt.v, tt.flags, 0); !errors.Is(err, tt.want) {
+			t.Errorf("SetAuto(%T, %d) error = %v, want %v", tt.v, tt.flags, err, tt.want)
+		}
+	}
+	if _, _, ok := s.stored("rejected"); ok {
+		t.Error("a value that failed to encode was stored")
+	}
+}
+
+func TestCodecRegistryConcurrent(t *testing.T) {
+	r := NewCodecRegistry()
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 100 {
+				flags := uint32(10 + (i+j)%4)
+				r.RegisterCodec(flags, reverseCodec{})
+				if _, err := r.Encode("value", flags); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestObjectCodec(t *testing.T) {
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithCodec(nil)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithCodec(nil) error = %v, want ErrInvalidOption", err)
+	}
+
+	// Clients encode objects as JSON by default.
+	type payload struct{ Name string }
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	if err := c.SetObject("json", payload{Name: "memcache"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, flags, _ := s.stored("json"); value != `{"Name":"memcache"}` || flags != FlagObject {
+		t.Errorf("SetObject() stored %q with flags %d, want JSON with FlagObject", value, flags)
+	}
+	var got payload
+	if err := c.GetObject("json", &got); err != nil || got.Name != "memcache" {
+		t.Errorf("GetObject() = %+v, %v", got, err)
+	}
+
+	c = newTestClient(t, []string{s.addr()}, WithCodec(reverseCodec{}))
+	if err := c.SetObject("reversed", "memcache", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := s.stored("reversed"); value != "ehcacmem" {
+		t.Errorf("SetObject() stored %q, want the value encoded by the codec of the client", value)
+	}
+	var value string
+	if err := c.GetObject("reversed", &value); err != nil || value != "memcache" {
+		t.Errorf("GetObject() = %q, %v", value, err)
+	}
+	// Values stored with the flags of another codec are decoded by the registry.
+	if err := c.SetAuto("auto", "memcache", FlagJSON, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GetObject("auto", &value); err != nil || value != "memcache" {
+		t.Errorf("GetObject() = %q, %v for a value stored by SetAuto", value, err)
+	}
+
+	if err := c.SetObject("unencodable", 42, 0); !errors.Is(err, ErrEncodeFailed) {
+		t.Errorf("SetObject() error = %v for a value the codec rejects, want ErrEncodeFailed", err)
+	}
+	if _, _, ok := s.stored("unencodable"); ok {
+		t.Error("SetObject() stored a value the codec rejected")
+	}
+	// The JSON codec cannot decode the reversed value.
+	if err := newTestClient(t, []string{s.addr()}).GetObject("reversed", &got); !errors.Is(err, ErrDecodeFailed) {
+		t.Errorf("GetObject() error = %v for a value of another codec, want ErrDecodeFailed", err)
+	}
+	if err := c.GetObject("missing", &value); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetObject() error = %v for a missing key, want ErrNotFound", err)
+	}
+}
@@ -1,23 +1,59 @@
 package memcache
 
 import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"math/rand/v2"
 	"net"
+	"time"
 )
 
+// connConfig holds the settings used to establish connections to a server.
+type connConfig struct {
+	backoffBase  time.Duration             // Initial delay between failed reconnect attempts; zero disables backoff.
+	backoffMax   time.Duration             // Maximum delay between failed reconnect attempts.
+	readBuffer   int                       // Size of the socket receive buffer (SO_RCVBUF); zero keeps the system default.
+	writeBuffer  int                       // Size of the socket send buffer (SO_SNDBUF); zero keeps the system default.
+	timeout      time.Duration             // Bound of dialing and of every read and write; zero disables it.
+	dialTimeout  time.Duration             // Bound of dialing, overriding timeout; zero falls back to it.
+	readTimeout  time.Duration             // Bound of every read, overriding timeout; zero falls back to it.
+	writeTimeout time.Duration             // Bound of every write, overriding timeout; zero falls back to it.
+	onConnect    func()                    // Called after every successful dial; nil if unused.
+	verify       func(conn net.Conn) error // Checks that a newly dialed endpoint is memcached (see WithVerifyOnConnect); nil if unused.
+	auth         func(conn net.Conn) error // Authenticates a newly dialed connection (see WithSASL); nil if unused.
+	localAddr    *net.TCPAddr              // Source address of outbound connections (see WithLocalAddr); nil lets the system choose.
+	tlsConfig    *tls.Config               // Settings of the TLS layer of every connection (see WithTLS); nil for cleartext.
+}
+
 type Conn struct {
-	addr string
-	conn net.Conn
+	addr     string
+	conn     net.Conn
+	reader   *bufio.Reader // Buffers the responses read from conn; created on first use and kept across reconnects.
+	deadline time.Time
+	config   connConfig
+	lastUsed time.Time // When the connection was last dialed or given back to its pool.
+	unsynced bool      // Whether commands with "noreply" were written since the last response was read (see Server.sync).
+
+	failures    int       // Consecutive failed dial attempts.
+	nextAttempt time.Time // Dial attempts fail fast with lastErr until this time.
+	lastErr     error     // The error of the last failed dial attempt.
 }
 
 func NewConn(address string) (conn *Conn, err error) {
-	c, err := net.Dial("tcp", address)
-	if err != nil {
-		return
+	return newConn(address, connConfig{})
+}
+
+// newConn creates a connection to the given address using the given settings.
+func newConn(address string, config connConfig) (conn *Conn, err error) {
+	c := &Conn{
+		addr:   address,
+		config: config,
 	}
-	conn = &Conn{
-		addr: address,
-		conn: c,
+	if err = c.connect(); err != nil {
+		return
 	}
+	conn = c
 	return
 }
 
@@ -25,42 +61,314 @@ func (c *Conn) connect() (err error) {
 	if c.conn != nil {
 		return
 	}
-	conn, err := net.Dial("tcp", c.addr)
+	// While backing off, fail fast with the error of the last attempt instead of hammering the server.
+	if c.config.backoffBase > 0 && time.Now().Before(c.nextAttempt) {
+		err = c.lastErr
+		return
+	}
+	// Honor the current deadline while dialing so a reconnect cannot outlive it.
+	dialer := net.Dialer{Deadline: c.deadline, Timeout: c.config.dialBound()}
+	// A nil *net.TCPAddr stored in the interface would not be nil, so it is only set when configured.
+	if c.config.localAddr != nil {
+		dialer.LocalAddr = c.config.localAddr
+	}
+	conn, err := dialer.Dial("tcp", c.addr)
 	if err != nil {
+		c.backoff(err)
+		return
+	}
+	if err = c.config.apply(conn); err != nil {
+		conn.Close()
 		return
 	}
+	if c.config.tlsConfig != nil {
+		if conn, err = c.handshake(conn); err != nil {
+			c.backoff(err)
+			return
+		}
+	}
+	// Every connection is authenticated, so one re-established after a failure is never left unauthenticated.
+	if c.config.auth != nil {
+		if err = c.config.auth(conn); err != nil {
+			conn.Close()
+			c.backoff(err)
+			return
+		}
+	}
+	if c.config.verify != nil {
+		if err = c.config.verify(conn); err != nil {
+			conn.Close()
+			c.backoff(err)
+			return
+		}
+	}
+	if !c.deadline.IsZero() {
+		if err = conn.SetDeadline(c.deadline); err != nil {
+			conn.Close()
+			return
+		}
+	}
 	c.conn = conn
+	c.failures = 0
+	c.lastUsed = time.Now()
+	if c.config.onConnect != nil {
+		c.config.onConnect()
+	}
 	return
 }
 
-func (c *Conn) reconnect() error {
-	if c.conn != nil {
-		c.conn.Close()
-		c.conn = nil
+// apply applies the socket settings to a newly dialed connection.
+// Buffer sizes only apply to TCP connections and are ignored for other transports.
+func (config connConfig) apply(conn net.Conn) (err error) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if config.readBuffer > 0 {
+		if err = tcp.SetReadBuffer(config.readBuffer); err != nil {
+			return
+		}
 	}
+	if config.writeBuffer > 0 {
+		if err = tcp.SetWriteBuffer(config.writeBuffer); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// dialBound returns the timeout of dialing, WithDialTimeout if set and WithTimeout otherwise.
+func (config connConfig) dialBound() time.Duration {
+	if config.dialTimeout > 0 {
+		return config.dialTimeout
+	}
+	return config.timeout
+}
+
+// readBound returns the timeout of every read, WithReadTimeout if set and WithTimeout otherwise.
+func (config connConfig) readBound() time.Duration {
+	if config.readTimeout > 0 {
+		return config.readTimeout
+	}
+	return config.timeout
+}
+
+// writeBound returns the timeout of every write, WithWriteTimeout if set and WithTimeout otherwise.
+func (config connConfig) writeBound() time.Duration {
+	if config.writeTimeout > 0 {
+		return config.writeTimeout
+	}
+	return config.timeout
+}
+
+// handshake wraps a newly dialed connection in TLS and performs the handshake within the dial timeout and the current deadline.
+// Unless the configuration names the server, the certificate is verified against the host of the address.
+// The connection is closed if the handshake fails.
+func (c *Conn) handshake(raw net.Conn) (conn net.Conn, err error) {
+	config := c.config.tlsConfig
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(c.addr); err == nil {
+			config = config.Clone()
+			config.ServerName = host
+		}
+	}
+	deadline := c.deadline
+	if timeout := c.config.dialBound(); timeout > 0 {
+		if bound := time.Now().Add(timeout); deadline.IsZero() || bound.Before(deadline) {
+			deadline = bound
+		}
+	}
+	tlsConn := tls.Client(raw, config)
+	if err = raw.SetDeadline(deadline); err == nil {
+		err = tlsConn.Handshake()
+	}
+	// The deadline of the handshake must not outlive it; the one of the connection is applied by connect.
+	if err == nil {
+		err = raw.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		raw.Close()
+		return
+	}
+	conn = tlsConn
+	return
+}
+
+// backoff records a failed dial attempt and schedules the next one.
+// The delay doubles with every consecutive failure up to the configured maximum, with jitter so that clients do not redial in lockstep.
+func (c *Conn) backoff(err error) {
+	if c.config.backoffBase <= 0 {
+		return
+	}
+	c.failures++
+	delay := c.config.backoffMax
+	if shift := c.failures - 1; shift < 32 && c.config.backoffBase<<shift < c.config.backoffMax {
+		delay = c.config.backoffBase << shift
+	}
+	// Wait between half and all of the delay.
+	delay = delay/2 + rand.N(delay/2+1)
+	c.nextAttempt = time.Now().Add(delay)
+	c.lastErr = err
+}
+
+func (c *Conn) reconnect() error {
+	c.Close()
 	return c.connect()
 }
 
+// Close closes the underlying network connection.
+// A previous reconnect may have failed and left no connection, in which case there is nothing to close.
+func (c *Conn) Close() (err error) {
+	if c.conn == nil {
+		return
+	}
+	err = c.conn.Close()
+	c.conn = nil
+	c.unsynced = false
+	// Bytes read ahead from the closed connection must not be taken for a response on the next one.
+	if c.reader != nil {
+		c.reader.Reset(c)
+	}
+	return
+}
+
+// bufferedReader returns the buffered reader of the connection.
+// Every response is read through the same reader, so bytes it read ahead of one response are kept for the next one
+// instead of being lost with a reader created per command.
+func (c *Conn) bufferedReader() *bufio.Reader {
+	if c.reader == nil {
+		c.reader = bufio.NewReader(c)
+	}
+	return c.reader
+}
+
+// refreshReadDeadline starts the configured read timeout for the next read.
+// An explicit deadline set with SetDeadline takes precedence.
+func (c *Conn) refreshReadDeadline() (err error) {
+	timeout := c.config.readBound()
+	if timeout <= 0 || !c.deadline.IsZero() {
+		return
+	}
+	return c.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// refreshWriteDeadline starts the configured write timeout for the next write.
+// An explicit deadline set with SetDeadline takes precedence.
+func (c *Conn) refreshWriteDeadline() (err error) {
+	timeout := c.config.writeBound()
+	if timeout <= 0 || !c.deadline.IsZero() {
+		return
+	}
+	return c.conn.SetWriteDeadline(time.Now().Add(timeout))
+}
+
+// SetDeadline sets the read and write deadline of the connection.
+// The deadline is kept and reapplied if the connection is re-established.
+// A zero value for t clears the deadline.
+func (c *Conn) SetDeadline(t time.Time) (err error) {
+	c.deadline = t
+	if c.conn == nil {
+		return
+	}
+	return c.conn.SetDeadline(t)
+}
+
 func (c *Conn) Write(b []byte) (n int, err error) {
-	n, err = c.conn.Write(b)
+	// A previous reconnect may have failed, so dial again before using the connection.
+	if err = c.connect(); err != nil {
+		return
+	}
+	n, err = c.write(b)
 	if err != nil {
+		if isTimeout(err) {
+			return
+		}
 		if err = c.reconnect(); err != nil {
 			return
 		}
-		n, err = c.conn.Write(b)
+		n, err = c.write(b)
 		return
 	}
 	return
 }
 
+// writeOnce writes b without retrying it on a new connection if the write fails.
+// Commands that are not idempotent must not be resent, since the server may have applied them before the failure.
+// The connection is still re-established for the next command.
+func (c *Conn) writeOnce(b []byte) (n int, err error) {
+	if err = c.connect(); err != nil {
+		return
+	}
+	n, err = c.write(b)
+	if err != nil {
+		c.reconnect()
+	}
+	return
+}
+
 func (c *Conn) Read(p []byte) (n int, err error) {
-	n, err = c.conn.Read(p)
+	// Responses only ever arrive on the connection the request was written to, so a closed connection is not redialed here.
+	if c.conn == nil {
+		err = net.ErrClosed
+		return
+	}
+	n, err = c.read(p)
+	// A response may arrive in fragments, and the last one can come together with an error.
+	// Bytes already read belong to the response in progress, so they are returned and the error is reported by the next read.
+	if n > 0 {
+		err = nil
+		return
+	}
+	// A new connection would never receive the response in progress, so reading from one would block until the timeout.
+	// The broken connection is closed instead, and the next command dials a fresh one.
 	if err != nil {
-		if err = c.reconnect(); err != nil {
-			return
-		}
-		n, err = c.conn.Read(p)
+		c.Close()
+	}
+	return
+}
+
+// write writes b to the underlying connection within the configured timeout.
+func (c *Conn) write(b []byte) (n int, err error) {
+	if err = c.refreshWriteDeadline(); err != nil {
 		return
 	}
+	n, err = c.conn.Write(b)
+	c.closeOnTimeout(err)
 	return
 }
+
+// deadlineWriter is an io.Writer over the underlying connection of a Conn.
+// Every write gets the full write timeout, so that a long copy is bounded per chunk rather than as a whole, and is never retried.
+type deadlineWriter struct {
+	conn *Conn
+}
+
+// Write writes b to the underlying connection within the configured timeout.
+func (w deadlineWriter) Write(b []byte) (n int, err error) {
+	return w.conn.write(b)
+}
+
+// read reads from the underlying connection within the configured timeout.
+func (c *Conn) read(p []byte) (n int, err error) {
+	if err = c.refreshReadDeadline(); err != nil {
+		return
+	}
+	n, err = c.conn.Read(p)
+	c.closeOnTimeout(err)
+	return
+}
+
+// closeOnTimeout closes the connection after a timeout.
+// A late response would otherwise be read as the response of the next command, and retrying on a new connection
+// would only wait for the same unresponsive server again, so the next command dials instead.
+func (c *Conn) closeOnTimeout(err error) {
+	if isTimeout(err) {
+		c.Close()
+	}
+}
+
+// isTimeout reports whether err is a network timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
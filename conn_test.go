@@ -0,0 +1,433 @@
+package memcache
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fragmentedConn is a net.Conn delivering each of its fragments in separate reads.
+// The last fragment comes together with io.EOF, as a connection closed right after the response would deliver it.
+type fragmentedConn struct {
+	net.Conn
+	fragments []string
+	written   bytes.Buffer
+}
+
+func (c *fragmentedConn) Read(p []byte) (n int, err error) {
+	if len(c.fragments) == 0 {
+		return 0, io.EOF
+	}
+	n = copy(p, c.fragments[0])
+	// A fragment longer than p is delivered over several reads.
+	if n < len(c.fragments[0]) {
+		c.fragments[0] = c.fragments[0][n:]
+		return
+	}
+	c.fragments = c.fragments[1:]
+	if len(c.fragments) == 0 {
+		err = io.EOF
+	}
+	return
+}
+
+func (c *fragmentedConn) Write(p []byte) (n int, err error)  { return c.written.Write(p) }
+func (c *fragmentedConn) Close() error                       { return nil }
+func (c *fragmentedConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fragmentedConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fragmentedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// newServerOn returns a server whose single pooled connection is conn.
+func newServerOn(conn net.Conn) *Server {
+	p := &pool{address: "fake", conns: make(chan *Conn, 1)}
+	p.conns <- &Conn{addr: "fake", conn: conn}
+	p.idle.Add(1)
+	return &Server{Address: "fake", pool: p, weight: 1}
+}
+
+func TestSetFragmentedResponse(t *testing.T) {
+	conn := &fragmentedConn{fragments: []string{"STO", "RED\r\n"}}
+	server := newServerOn(conn)
+	if err := storeItem(server, &Item{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("storeItem() error = %v, want nil for a response split across reads", err)
+	}
+	if got, want := conn.written.String(), "set key 0 0 5\r\nvalue\r\n"; got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+func TestConnReadDefersErrorAfterData(t *testing.T) {
+	c := &Conn{addr: "fake", conn: &fragmentedConn{fragments: []string{"STO", "RED\r\n"}}}
+	buf := make([]byte, 16)
+	for _, want := range []string{"STO", "RED\r\n"} {
+		n, err := c.Read(buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Fatalf("Read() = %q, %v; want %q, nil", buf[:n], err, want)
+		}
+	}
+	if _, err := c.Read(buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("Read() error = %v, want the deferred io.EOF", err)
+	}
+	if c.conn != nil {
+		t.Error("the connection was not closed after the error")
+	}
+}
+
+func TestCloseAfterFailedReconnect(t *testing.T) {
+	s := newFakeServer(t)
+	p, err := newPool(s.addr(), connConfig{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-p.conns
+	// The server goes away, so the redial fails and leaves no underlying connection.
+	s.close()
+	if err = conn.reconnect(); err == nil {
+		t.Fatal("reconnect() succeeded with the server gone")
+	}
+	if conn.conn != nil {
+		t.Fatal("a failed reconnect left an underlying connection")
+	}
+	if err = conn.Close(); err != nil {
+		t.Errorf("Close() error = %v, want nil", err)
+	}
+	p.conns <- conn
+	server := &Server{Address: s.addr(), pool: p, weight: 1}
+	server.Close()
+	if _, err = server.acquire(); !errors.Is(err, ErrClosed) {
+		t.Errorf("acquire() error = %v after Close, want ErrClosed", err)
+	}
+}
+
+// partialWriteConn writes only the first limit bytes of its first write and then fails it.
+type partialWriteConn struct {
+	net.Conn
+	limit int
+	done  bool
+}
+
+func (c *partialWriteConn) Write(p []byte) (n int, err error) {
+	if c.done || len(p) <= c.limit {
+		return c.Conn.Write(p)
+	}
+	c.done = true
+	n, _ = c.Conn.Write(p[:c.limit])
+	return n, errors.New("connection reset mid-write")
+}
+
+func TestWriteErrorDoesNotCorruptNextBorrower(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithSingleConnection())
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	// The next command is cut off in its data block, so the server waits for the rest of it on that connection.
+	server := c.servers[0]
+	conn := <-server.pool.conns
+	conn.conn = &partialWriteConn{Conn: conn.conn, limit: len("append key 0 0 6\r\n-s")}
+	server.pool.conns <- conn
+	if err := c.Append("key", "-suffix"); !errors.Is(err, ErrWriteFailed) {
+		t.Fatalf("Append() error = %v, want ErrWriteFailed", err)
+	}
+	// The next borrower gets a connection of its own, which the leftover bytes of the failed command cannot reach.
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v after a failed write; want %q", got, err, "value")
+	}
+	if err := c.Set("key", "other", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("key"); err != nil || got != "other" {
+		t.Fatalf("Get() = %q, %v; want %q", got, err, "other")
+	}
+}
+
+// selfSignedCert issues a self-signed certificate for the given DNS name, and returns it along with a pool trusting it.
+func selfSignedCert(t *testing.T, name string) (cert tls.Certificate, roots *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots = x509.NewCertPool()
+	roots.AddCert(leaf)
+	cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+	return
+}
+
+func TestTLSSetGet(t *testing.T) {
+	cert, roots := selfSignedCert(t, "localhost")
+	s := newFakeTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	_, port, _ := strings.Cut(s.addr(), ":")
+	// The certificate is verified against the host of the address, since the configuration sets no ServerName.
+	c := newTestClient(t, []string{net.JoinHostPort("localhost", port)}, WithTLS(&tls.Config{RootCAs: roots}))
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v; want %q", got, err, "value")
+	}
+	// The command in flight when the server drops the connection fails, and the next one redials with a new handshake.
+	s.dropConns()
+	if _, err := c.Get("key"); !errors.Is(err, ErrReadFailed) {
+		t.Fatalf("Get() error = %v on a dropped connection, want ErrReadFailed", err)
+	}
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v after a reconnect; want %q", got, err, "value")
+	}
+	if got := s.accepted.Load(); got != 2 {
+		t.Errorf("the server accepted %d connections, want 2", got)
+	}
+}
+
+func TestTLSServerName(t *testing.T) {
+	cert, roots := selfSignedCert(t, "memcache.test")
+	s := newFakeTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	// The host of the address does not match the certificate.
+	if _, err := NewClientWithOptions(WithServers(s.addr()), WithTLS(&tls.Config{RootCAs: roots})); err == nil {
+		t.Fatal("NewClientWithOptions() succeeded with a certificate for another host")
+	}
+	c := newTestClient(t, []string{s.addr()}, WithTLS(&tls.Config{RootCAs: roots, ServerName: "memcache.test"}))
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	// The listener accepts connections but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	start := time.Now()
+	_, err = NewClientWithOptions(WithServers(ln.Addr().String()), WithTLS(&tls.Config{ServerName: "localhost"}), WithDialTimeout(200*time.Millisecond))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("NewClientWithOptions() error = %v, want a handshake timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the handshake was given up after %v, want about 200ms", elapsed)
+	}
+}
+
+// saslAuths returns the number of SASL Auth requests the server received.
+func saslAuths(s *fakeServer) (n int) {
+	for _, cmd := range s.received() {
+		if strings.HasPrefix(cmd, "binary 0x21 ") {
+			n++
+		}
+	}
+	return
+}
+
+func TestSASLRejectedCredentials(t *testing.T) {
+	s := newFakeServer(t)
+	s.requireSASL("user", "secret")
+	if _, err := NewClientWithOptions(WithServers(s.addr()), WithSASL("user", "wrong")); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("NewClientWithOptions() error = %v with a wrong password, want ErrAuthFailed", err)
+	}
+	if _, err := NewClientWithOptions(WithServers(s.addr()), WithSASL("", "secret")); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithSASL() error = %v without a username, want ErrInvalidOption", err)
+	}
+	c := newTestClient(t, []string{s.addr()}, WithSASL("user", "secret"))
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v; want %q", got, err, "value")
+	}
+}
+
+func TestSASLReauthenticatesAfterReconnect(t *testing.T) {
+	s := newFakeServer(t)
+	s.requireSASL("user", "secret")
+	c := newTestClient(t, []string{s.addr()}, WithSASL("user", "secret"), WithSingleConnection())
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	server := c.servers[0]
+	conn := <-server.pool.conns
+	err := conn.reconnect()
+	server.pool.conns <- conn
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server answers requests of an unauthenticated connection with an auth error, so the Get only succeeds
+	// if the new connection authenticated first.
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v after a reconnect; want %q", got, err, "value")
+	}
+	if got := saslAuths(s); got != 2 {
+		t.Errorf("the server received %d SASL Auth requests, want 2", got)
+	}
+	if cmds := s.received(); !strings.HasPrefix(cmds[len(cmds)-2], "binary 0x21 ") {
+		t.Errorf("the command before the Get was %q, want a SASL Auth request", cmds[len(cmds)-2])
+	}
+}
+
+func TestSASLFailureClosesAndBacksOff(t *testing.T) {
+	s := newFakeServer(t)
+	s.requireSASL("user", "secret")
+	c := newTestClient(t, []string{s.addr()}, WithSASL("user", "secret"), WithSingleConnection(), WithReconnectBackoff(time.Minute, time.Minute))
+	// The credentials are revoked, so the next connection is rejected.
+	s.requireSASL("user", "rotated")
+	server := c.servers[0]
+	conn := <-server.pool.conns
+	err := conn.reconnect()
+	closed := conn.conn == nil
+	server.pool.conns <- conn
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("reconnect() error = %v, want ErrAuthFailed", err)
+	}
+	if !closed {
+		t.Error("a connection that failed to authenticate was kept")
+	}
+	// The server sees the rejected socket closed.
+	for deadline := time.Now().Add(time.Second); s.open.Load() != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections are still open after the failed authentication", s.open.Load())
+		}
+	}
+	// While backing off, commands fail with the error of the last attempt instead of dialing again.
+	accepted := s.accepted.Load()
+	if _, err := c.Get("key"); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Get() error = %v while backing off, want ErrAuthFailed", err)
+	}
+	if got := s.accepted.Load(); got != accepted {
+		t.Errorf("the server accepted %d connections while the client backed off, want none", got-accepted)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	for _, opt := range []Option{WithReconnectBackoff(0, time.Second), WithReconnectBackoff(time.Second, time.Millisecond)} {
+		if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), opt); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("NewClientWithOptions() error = %v, want ErrInvalidOption", err)
+		}
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := ln.Addr().String()
+	ln.Close()
+	live := newFakeServer(t).addr()
+
+	base := 20 * time.Millisecond
+	c := &Conn{addr: refused, config: connConfig{backoffBase: base, backoffMax: 4 * base}}
+	// The delays double up to the maximum, each shortened by up to half as jitter.
+	for _, want := range []time.Duration{base, 2 * base, 4 * base, 4 * base} {
+		start := time.Now()
+		if err = c.connect(); err == nil {
+			t.Fatal("connect() succeeded to a closed port")
+		}
+		if delay := c.nextAttempt.Sub(start); delay < want/2 || delay > want+10*time.Millisecond {
+			t.Errorf("connect() failure %d backs off %v, want between %v and %v", c.failures, delay, want/2, want)
+		}
+		// While backing off, the connection fails fast with the last error without dialing.
+		c.addr = live
+		if again := c.connect(); again != err || c.conn != nil {
+			t.Errorf("connect() = %v during the backoff, want the last error %v", again, err)
+		}
+		for time.Now().Before(c.nextAttempt) {
+			time.Sleep(time.Millisecond)
+		}
+		c.addr = refused
+	}
+
+	// Once the delay is over, a successful dial resets the backoff.
+	c.addr = live
+	if err = c.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.failures != 0 {
+		t.Errorf("%d failures recorded after a successful dial, want 0", c.failures)
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithLocalAddr(nil)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithLocalAddr(nil) error = %v, want ErrInvalidOption", err)
+	}
+	// Nothing listens on the servers, so the compatible addresses fail to dial instead.
+	for _, tt := range []struct {
+		local   string
+		server  string
+		invalid bool
+	}{
+		{"::1", "127.0.0.1:1", true},
+		{"127.0.0.1", "[::1]:1", true},
+		{"::1", "[::1]:1", false},
+		// Host names are only resolved when dialing.
+		{"::1", "localhost:1", false},
+	} {
+		_, err := NewClientWithOptions(WithServers(tt.server), WithLocalAddr(&net.TCPAddr{IP: net.ParseIP(tt.local)}))
+		if errors.Is(err, ErrInvalidOption) != tt.invalid {
+			t.Errorf("WithLocalAddr(%s) with server %s error = %v, want ErrInvalidOption: %v", tt.local, tt.server, err, tt.invalid)
+		}
+	}
+
+	// Every address of 127.0.0.0/8 is local, so the connections can be bound to another one than the server's.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	remote := make(chan net.Addr, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		remote <- conn.RemoteAddr()
+		conn.Read(make([]byte, 64))
+		io.WriteString(conn, "VERSION 1.6.21\r\n")
+	}()
+	c := newTestClient(t, []string{ln.Addr().String()}, WithLocalAddr(&net.TCPAddr{IP: net.ParseIP("127.0.0.2")}))
+	if _, err := c.Version(ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if addr := (<-remote).(*net.TCPAddr); !addr.IP.Equal(net.ParseIP("127.0.0.2")) {
+		t.Errorf("the connection came from %s, want 127.0.0.2", addr)
+	}
+}
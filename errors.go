@@ -10,3 +10,37 @@ var ErrNotFound = errors.New("key not found")
 var ErrUnexpectedResponse = errors.New("unexpected response from server")
 var ErrInternal = errors.New("internal error")
 var ErrNoServers = errors.New("no servers available")
+var ErrTimeout = errors.New("operation timed out")
+var ErrInvalidOption = errors.New("invalid option")
+var ErrLengthMismatch = errors.New("value length mismatch")
+var ErrInvalidWeight = errors.New("invalid server weight")
+var ErrServerUnavailable = errors.New("server unavailable")
+var ErrUnknownFlags = errors.New("no codec registered for flags")
+var ErrDecodeFailed = errors.New("decode failed")
+var ErrEncodeFailed = errors.New("encode failed")
+var ErrInvalidKey = errors.New("invalid key")
+var ErrNoNamespace = errors.New("no namespace configured")
+var ErrMetaUnsupported = errors.New("meta commands not supported by server")
+var ErrUnsupportedByProxy = errors.New("command not supported by proxy")
+var ErrCrawlerBusy = errors.New("lru crawler busy")
+var ErrBadDataChunk = errors.New("data block length mismatch")
+var ErrUnsupportedCommand = errors.New("command not supported by server")
+var ErrUnsupportedByProtocol = errors.New("command not supported by protocol")
+var ErrClosed = errors.New("closed")
+var ErrItemTooLarge = errors.New("item too large")
+var ErrInsufficientServers = errors.New("insufficient healthy servers")
+var ErrNegativeDelta = errors.New("negative delta")
+var ErrCorruptChunks = errors.New("corrupt chunked value")
+var ErrDeleteTime = errors.New("delete with a time argument not supported")
+var ErrExists = errors.New("item modified since read")
+var ErrNotAcceptingConns = errors.New("server not accepting connections")
+var ErrCommandNotAllowed = errors.New("command not allowed")
+var ErrDuplicateServer = errors.New("duplicate server address")
+var ErrBatchAborted = errors.New("batch aborted")
+var ErrNotMemcached = errors.New("endpoint is not memcached")
+var ErrCommandFailed = errors.New("command rejected by server")
+var ErrWriteQueued = errors.New("write queued for replay")
+var ErrNotStored = errors.New("item not stored")
+var ErrCASNotFound = errors.New("item to compare and swap not found")
+var ErrAuthFailed = errors.New("authentication failed")
+var ErrPoolTimeout = errors.New("timed out waiting for a free connection")
@@ -0,0 +1,76 @@
+package memcache
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// NeverExpire is the expiration of items that are only removed when evicted or deleted.
+const NeverExpire = 0
+
+// TTLNever is the remaining time to live reported by TTL for items stored with NeverExpire.
+const TTLNever time.Duration = -1
+
+// TTLUnknown is the remaining time to live reported by GetMultiWithMeta for items whose server cannot report it.
+const TTLUnknown time.Duration = -2
+
+// maxRelativeExpiration is the longest expiration memcached interprets as seconds from now;
+// larger values are taken as a Unix timestamp.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// ExpirationFromDuration converts d into the expiration of a storage command.
+// Zero is NeverExpire. A negative d expires the item immediately.
+// Durations are rounded up to whole seconds, so that a short positive duration does not turn into NeverExpire,
+// and durations longer than 30 days are converted to a Unix timestamp, as memcached requires.
+func ExpirationFromDuration(d time.Duration) int {
+	switch {
+	case d == 0:
+		return NeverExpire
+	case d < 0:
+		return -1
+	case d > maxRelativeExpiration:
+		return int(time.Now().Add(d).Unix())
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// TTL returns the remaining time to live of the item stored under the given key, using a meta get with the "t" flag.
+// It returns TTLNever for items that never expire, ErrNotFound if the key does not exist,
+// and ErrMetaUnsupported on servers without meta command support.
+func (c *Client) TTL(key string) (ttl time.Duration, err error) {
+	defer c.hook("TTL", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	server, err := c.pickServer(key)
+	if err != nil {
+		return
+	}
+	return server.ttl(key)
+}
+
+// ttl reads the remaining time to live of the key with a meta get command, like Client.TTL.
+func (s *Server) ttl(key string) (ttl time.Duration, err error) {
+	ret, _, err := s.MetaGet(key, "t")
+	if err != nil {
+		return
+	}
+	return parseTTL(ret['t'])
+}
+
+// parseTTL parses the value of the "t" return flag of a meta get command, where -1 means that the item never expires.
+func parseTTL(token string) (ttl time.Duration, err error) {
+	seconds, err := strconv.Atoi(token)
+	if err != nil {
+		err = errors.Join(ErrUnexpectedResponse, err)
+		return
+	}
+	if seconds < 0 {
+		ttl = TTLNever
+		return
+	}
+	ttl = time.Duration(seconds) * time.Second
+	return
+}
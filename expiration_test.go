@@ -0,0 +1,78 @@
+package memcache
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestExpirationFromDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, NeverExpire},
+		{-time.Second, -1},
+		{time.Millisecond, 1},
+		{90 * time.Second, 90},
+		{maxRelativeExpiration, int(maxRelativeExpiration / time.Second)},
+	}
+	for _, tt := range tests {
+		if got := ExpirationFromDuration(tt.d); got != tt.want {
+			t.Errorf("ExpirationFromDuration(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+	// Beyond 30 days, the expiration is an absolute Unix time rather than a timestamp in the past.
+	if got := int64(ExpirationFromDuration(60 * 24 * time.Hour)); got < time.Now().Unix() {
+		t.Errorf("ExpirationFromDuration(60 days) = %d, want a Unix time in the future", got)
+	}
+}
+
+func TestTTLNeverExpire(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	if err := c.Set("forever", "value", ExpirationFromDuration(0)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := c.TTL("forever"); err != nil || ttl != TTLNever {
+		t.Errorf("TTL() = %v, %v; want TTLNever", ttl, err)
+	}
+	if err := c.Set("expiring", "value", ExpirationFromDuration(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := c.TTL("expiring"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL() = %v, %v; want up to a minute", ttl, err)
+	}
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithSlidingExpiration(0)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithSlidingExpiration(0) error = %v, want ErrInvalidOption", err)
+	}
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithSlidingExpiration(3600))
+	if err := c.Set("key", "value", 10); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Get("key"); err != nil || value != "value" {
+		t.Fatalf("Get() = %q, %v", value, err)
+	}
+	// The read refreshed the TTL in the same round trip.
+	s.mu.Lock()
+	expiration := s.items["key"].expiration
+	s.mu.Unlock()
+	if remaining := expiration - time.Now().Unix(); remaining < 3500 {
+		t.Errorf("the key expires in %ds after Get, want about 3600s", remaining)
+	}
+	if cmds := s.received(); !slices.Contains(cmds, "gat 3600 key") || slices.Contains(cmds, "get key") {
+		t.Errorf("Get() sent %q, want a gat instead of a get", cmds)
+	}
+	// Misses do not create keys.
+	if _, err := c.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v for a miss, want ErrNotFound", err)
+	}
+	if _, _, ok := s.stored("missing"); ok {
+		t.Error("a missed Get created the key")
+	}
+}
@@ -0,0 +1,707 @@
+package memcache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeItem is an item stored by fakeServer.
+type fakeItem struct {
+	value      []byte
+	flags      uint32
+	expiration int64 // Absolute Unix time; zero never expires.
+	cas        uint64
+}
+
+// fakeServer is an in-memory memcached server speaking enough of the text, meta and binary protocols
+// for the tests of the client. It records every command line it receives.
+type fakeServer struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	items    map[string]*fakeItem
+	cas      uint64
+	conns    []net.Conn
+	commands []string
+	closeOn  map[string]bool // Commands the server closes the connection on instead of answering, like twemproxy does.
+	sasl     string          // SASL PLAIN credentials, as "\x00<username>\x00<password>", binary requests require; none if empty.
+
+	itemSizeMax int          // Stores larger than this fail like memcached does; zero accepts any size.
+	accepted    atomic.Int64 // Number of connections accepted so far.
+	open        atomic.Int64 // Number of connections currently open.
+	delay       atomic.Int64 // Time to wait before answering each text command, as a time.Duration.
+	reverse     atomic.Bool  // Whether the responses to pipelined binary requests are sent in reverse order.
+	reversed    atomic.Int64 // Number of batches of several binary responses sent in reverse order.
+	wg          sync.WaitGroup
+	closeOnce   sync.Once
+}
+
+// newFakeServer starts a fakeServer on a loopback port; it is stopped when the test ends.
+func newFakeServer(t testing.TB) *fakeServer {
+	t.Helper()
+	return newFakeServerAt(t, "127.0.0.1:0")
+}
+
+// newFakeServerAt starts a fakeServer listening on the given address; it is stopped when the test ends.
+func newFakeServerAt(t testing.TB, addr string) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return startFakeServer(t, ln)
+}
+
+// newFakeTLSServer starts a fakeServer accepting TLS connections with the given configuration on a loopback port.
+func newFakeTLSServer(t testing.TB, config *tls.Config) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return startFakeServer(t, tls.NewListener(ln, config))
+}
+
+// startFakeServer serves ln with a new fakeServer until the test ends.
+func startFakeServer(t testing.TB, ln net.Listener) *fakeServer {
+	s := &fakeServer{ln: ln, items: make(map[string]*fakeItem)}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+// addr returns the address the server listens on.
+func (s *fakeServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+// close stops the server and closes every connection.
+func (s *fakeServer) close() {
+	s.closeOnce.Do(func() {
+		s.ln.Close()
+		s.dropConns()
+		s.wg.Wait()
+	})
+}
+
+// dropConns closes every connection accepted so far, as a server restart or a network failure would.
+func (s *fakeServer) dropConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// received returns the command lines received so far, without their data blocks.
+func (s *fakeServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// closeConnOn makes the server close the connection when it receives one of the given commands.
+func (s *fakeServer) closeConnOn(commands ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeOn = make(map[string]bool)
+	for _, cmd := range commands {
+		s.closeOn[cmd] = true
+	}
+}
+
+// itemCount returns the number of items stored.
+func (s *fakeServer) itemCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// stored returns the value and flags of the item stored under key as the server holds them, and whether there is one.
+func (s *fakeServer) stored(key string) (value string, flags uint32, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if !ok {
+		return
+	}
+	return string(item.value), item.flags, true
+}
+
+func (s *fakeServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		s.accepted.Add(1)
+		s.open.Add(1)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.open.Add(-1)
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+// lookup returns the live item stored under key. The caller holds s.mu.
+func (s *fakeServer) lookup(key string) *fakeItem {
+	item := s.items[key]
+	if item == nil {
+		return nil
+	}
+	if item.expiration != 0 && time.Now().Unix() >= item.expiration {
+		delete(s.items, key)
+		return nil
+	}
+	return item
+}
+
+// absoluteExpiration converts an expiration of the protocol to an absolute Unix time.
+func absoluteExpiration(expiration int64) int64 {
+	switch {
+	case expiration == 0:
+		return 0
+	case expiration < 0:
+		return 1
+	case expiration > 60*60*24*30:
+		return expiration
+	}
+	return time.Now().Unix() + expiration
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	if b, err := reader.Peek(1); err == nil && b[0] == 0x80 {
+		s.handleBinary(reader, writer)
+		return
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if delay := time.Duration(s.delay.Load()); delay > 0 {
+			time.Sleep(delay)
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			writer.WriteString("ERROR\r\n")
+			writer.Flush()
+			continue
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.TrimRight(line, "\r\n"))
+		closing := s.closeOn[fields[0]]
+		s.mu.Unlock()
+		if closing {
+			return
+		}
+		var data []byte
+		switch fields[0] {
+		case "set", "add", "replace", "append", "prepend", "cas", "ms":
+			sizeField := 4
+			if fields[0] == "ms" {
+				sizeField = 2
+			}
+			if len(fields) <= sizeField {
+				writer.WriteString("ERROR\r\n")
+				writer.Flush()
+				continue
+			}
+			n, err := strconv.Atoi(fields[sizeField])
+			if err != nil || n < 0 {
+				writer.WriteString("CLIENT_ERROR bad command line format\r\n")
+				writer.Flush()
+				continue
+			}
+			data = make([]byte, n+2)
+			if _, err = io.ReadFull(reader, data); err != nil {
+				return
+			}
+		}
+		s.mu.Lock()
+		s.execute(writer, fields, data)
+		s.mu.Unlock()
+		if writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+// execute answers a text or meta command. The caller holds s.mu.
+func (s *fakeServer) execute(w *bufio.Writer, f []string, data []byte) {
+	noreply := f[len(f)-1] == "noreply"
+	reply := func(line string) {
+		if !noreply {
+			w.WriteString(line + "\r\n")
+		}
+	}
+	switch f[0] {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		n := len(data) - 2
+		if string(data[n:]) != "\r\n" {
+			reply("CLIENT_ERROR bad data chunk")
+			return
+		}
+		if s.itemSizeMax > 0 && 48+len(f[1])+n+8 > s.itemSizeMax {
+			// Memcached reports errors even for commands with noreply.
+			w.WriteString("SERVER_ERROR object too large for cache\r\n")
+			return
+		}
+		flags, _ := strconv.ParseUint(f[2], 10, 32)
+		expiration, _ := strconv.ParseInt(f[3], 10, 64)
+		value := append([]byte(nil), data[:n]...)
+		current := s.lookup(f[1])
+		store := func() {
+			s.cas++
+			s.items[f[1]] = &fakeItem{value: value, flags: uint32(flags), expiration: absoluteExpiration(expiration), cas: s.cas}
+			reply("STORED")
+		}
+		switch f[0] {
+		case "set":
+			store()
+		case "add":
+			if current != nil {
+				reply("NOT_STORED")
+				return
+			}
+			store()
+		case "replace":
+			if current == nil {
+				reply("NOT_STORED")
+				return
+			}
+			store()
+		case "append", "prepend":
+			if current == nil {
+				reply("NOT_STORED")
+				return
+			}
+			if f[0] == "append" {
+				current.value = append(current.value, value...)
+			} else {
+				current.value = append(value, current.value...)
+			}
+			s.cas++
+			current.cas = s.cas
+			reply("STORED")
+		case "cas":
+			cas, _ := strconv.ParseUint(f[5], 10, 64)
+			switch {
+			case current == nil:
+				reply("NOT_FOUND")
+			case current.cas != cas:
+				reply("EXISTS")
+			default:
+				store()
+			}
+		}
+	case "get", "gets", "gat", "gats":
+		keys := f[1:]
+		touch := f[0] == "gat" || f[0] == "gats"
+		var expiration int64
+		if touch {
+			expiration, _ = strconv.ParseInt(f[1], 10, 64)
+			keys = f[2:]
+		}
+		for _, key := range keys {
+			item := s.lookup(key)
+			if item == nil {
+				continue
+			}
+			if touch {
+				item.expiration = absoluteExpiration(expiration)
+			}
+			if f[0] == "gets" || f[0] == "gats" {
+				fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, item.flags, len(item.value), item.cas)
+			} else {
+				fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, item.flags, len(item.value))
+			}
+			w.Write(item.value)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "delete":
+		if len(f) > 3 || (len(f) == 3 && f[2] != "noreply") {
+			reply("CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]")
+			return
+		}
+		if s.lookup(f[1]) == nil {
+			reply("NOT_FOUND")
+			return
+		}
+		delete(s.items, f[1])
+		reply("DELETED")
+	case "incr", "decr":
+		item := s.lookup(f[1])
+		if item == nil {
+			reply("NOT_FOUND")
+			return
+		}
+		delta, err := strconv.ParseUint(f[2], 10, 64)
+		if err != nil {
+			reply("CLIENT_ERROR invalid numeric delta argument")
+			return
+		}
+		value, err := strconv.ParseUint(string(item.value), 10, 64)
+		if err != nil {
+			reply("CLIENT_ERROR cannot increment or decrement non-numeric value")
+			return
+		}
+		switch {
+		case f[0] == "incr":
+			// Counters wrap around at 2^64 like they do in memcached.
+			value += delta
+		case delta > value:
+			value = 0
+		default:
+			value -= delta
+		}
+		item.value = []byte(strconv.FormatUint(value, 10))
+		s.cas++
+		item.cas = s.cas
+		reply(strconv.FormatUint(value, 10))
+	case "touch":
+		item := s.lookup(f[1])
+		if item == nil {
+			reply("NOT_FOUND")
+			return
+		}
+		expiration, _ := strconv.ParseInt(f[2], 10, 64)
+		item.expiration = absoluteExpiration(expiration)
+		reply("TOUCHED")
+	case "flush_all":
+		clear(s.items)
+		reply("OK")
+	case "verbosity":
+		reply("OK")
+	case "version":
+		w.WriteString("VERSION 1.6.21\r\n")
+	case "stats":
+		s.stats(w, f[1:])
+	case "mn":
+		w.WriteString("MN\r\n")
+	case "mg":
+		s.metaGet(w, f)
+	case "ms":
+		s.metaSet(w, f, data)
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+}
+
+// stats answers a "stats" command. The caller holds s.mu.
+func (s *fakeServer) stats(w *bufio.Writer, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(w, "STAT pid 1\r\nSTAT uptime 100\r\nSTAT time %d\r\nSTAT version 1.6.21\r\n", time.Now().Unix())
+		w.WriteString("STAT rusage_user 0.123456\r\nSTAT rusage_system 1.500000\r\n")
+		fmt.Fprintf(w, "STAT curr_connections %d\r\nSTAT max_connections 1024\r\n", s.open.Load())
+		fmt.Fprintf(w, "STAT get_hits 3\r\nSTAT get_misses 1\r\nSTAT evictions 0\r\nSTAT curr_items %d\r\nEND\r\n", len(s.items))
+		return
+	}
+	switch args[0] {
+	case "settings":
+		itemSizeMax := s.itemSizeMax
+		if itemSizeMax == 0 {
+			itemSizeMax = defaultItemSizeMax
+		}
+		fmt.Fprintf(w, "STAT maxconns 1024\r\nSTAT item_size_max %d\r\nSTAT ssl_enabled no\r\nEND\r\n", itemSizeMax)
+	default:
+		w.WriteString("END\r\n")
+	}
+}
+
+// metaGet answers a meta get command with the v, s, t, f, c, k and q flags. The caller holds s.mu.
+func (s *fakeServer) metaGet(w *bufio.Writer, f []string) {
+	item := s.lookup(f[1])
+	if item == nil {
+		for _, flag := range f[2:] {
+			if flag == "q" {
+				return
+			}
+		}
+		w.WriteString("EN\r\n")
+		return
+	}
+	var returned []string
+	withValue := false
+	for _, flag := range f[2:] {
+		switch flag[0] {
+		case 'v':
+			withValue = true
+		case 's':
+			returned = append(returned, fmt.Sprintf("s%d", len(item.value)))
+		case 't':
+			ttl := int64(-1)
+			if item.expiration != 0 {
+				ttl = item.expiration - time.Now().Unix()
+			}
+			returned = append(returned, fmt.Sprintf("t%d", ttl))
+		case 'f':
+			returned = append(returned, fmt.Sprintf("f%d", item.flags))
+		case 'c':
+			returned = append(returned, fmt.Sprintf("c%d", item.cas))
+		case 'k':
+			returned = append(returned, "k"+f[1])
+		}
+	}
+	if !withValue {
+		w.WriteString(strings.TrimSpace("HD "+strings.Join(returned, " ")) + "\r\n")
+		return
+	}
+	fmt.Fprintf(w, "VA %d %s\r\n", len(item.value), strings.Join(returned, " "))
+	w.Write(item.value)
+	w.WriteString("\r\n")
+}
+
+// metaSet answers a meta set command with the T, E, C and ME flags. The caller holds s.mu.
+func (s *fakeServer) metaSet(w *bufio.Writer, f []string, data []byte) {
+	current := s.lookup(f[1])
+	var expiration int64
+	var cas, compare uint64
+	addMode := false
+	for _, flag := range f[3:] {
+		switch flag[0] {
+		case 'T':
+			expiration, _ = strconv.ParseInt(flag[1:], 10, 64)
+		case 'E':
+			cas, _ = strconv.ParseUint(flag[1:], 10, 64)
+		case 'C':
+			compare, _ = strconv.ParseUint(flag[1:], 10, 64)
+		case 'M':
+			addMode = flag == "ME"
+		}
+	}
+	switch {
+	case addMode && current != nil:
+		w.WriteString("NS\r\n")
+		return
+	case compare != 0 && current == nil:
+		w.WriteString("NF\r\n")
+		return
+	case compare != 0 && current.cas != compare:
+		w.WriteString("EX\r\n")
+		return
+	}
+	s.cas++
+	if cas == 0 {
+		cas = s.cas
+	}
+	s.items[f[1]] = &fakeItem{value: append([]byte(nil), data[:len(data)-2]...), expiration: absoluteExpiration(expiration), cas: cas}
+	w.WriteString("HD\r\n")
+}
+
+// requireSASL makes binary connections authenticate with SASL PLAIN and the given credentials, like memcached started with -S.
+func (s *fakeServer) requireSASL(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sasl = "\x00" + username + "\x00" + password
+}
+
+// handleBinary answers binary protocol requests: SASL Auth, Get, GetK, GetKQ, Set, Add, Replace, Delete, Noop and Version.
+func (s *fakeServer) handleBinary(reader *bufio.Reader, writer *bufio.Writer) {
+	authenticated := false
+	var held [][]byte // Responses held back while pipelined requests remain, with reverse.
+	for {
+		var header [24]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return
+		}
+		opcode := header[1]
+		keyLength := int(binary.BigEndian.Uint16(header[2:]))
+		extrasLength := int(header[4])
+		body := make([]byte, binary.BigEndian.Uint32(header[8:]))
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+		opaque := binary.BigEndian.Uint32(header[12:])
+		cas := binary.BigEndian.Uint64(header[16:])
+		extras := body[:extrasLength]
+		key := string(body[extrasLength : extrasLength+keyLength])
+		value := body[extrasLength+keyLength:]
+		respond := func(status uint16, extras []byte, key string, value []byte, cas uint64) {
+			res := make([]byte, 24, 24+len(extras)+len(key)+len(value))
+			res[0] = 0x81
+			res[1] = opcode
+			binary.BigEndian.PutUint16(res[2:], uint16(len(key)))
+			res[4] = byte(len(extras))
+			binary.BigEndian.PutUint16(res[6:], status)
+			binary.BigEndian.PutUint32(res[8:], uint32(len(extras)+len(key)+len(value)))
+			binary.BigEndian.PutUint32(res[12:], opaque)
+			binary.BigEndian.PutUint64(res[16:], cas)
+			res = append(append(append(res, extras...), key...), value...)
+			if s.reverse.Load() {
+				held = append(held, res)
+				return
+			}
+			writer.Write(res)
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, fmt.Sprintf("binary 0x%02x %s", opcode, key))
+		switch {
+		case opcode == opSASLAuth && s.sasl != "":
+			authenticated = key == "PLAIN" && string(value) == s.sasl
+			if authenticated {
+				respond(statusNoError, nil, "", []byte("Authenticated"), 0)
+			} else {
+				respond(statusAuthError, nil, "", []byte("Auth failure"), 0)
+			}
+		case s.sasl != "" && !authenticated:
+			respond(statusAuthError, nil, "", []byte("Auth failure"), 0)
+		default:
+			s.handleBinaryRequest(opcode, key, extras, value, cas, respond)
+		}
+		s.mu.Unlock()
+		// Quiet requests are answered together with the next request that is not quiet.
+		if opcode == opGetKQ {
+			continue
+		}
+		if s.reverse.Load() {
+			if reader.Buffered() > 0 {
+				continue
+			}
+			for i := len(held) - 1; i >= 0; i-- {
+				writer.Write(held[i])
+			}
+			if len(held) > 1 {
+				s.reversed.Add(1)
+			}
+			held = nil
+		}
+		if writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+// handleBinaryRequest answers an authenticated binary request through respond. The caller holds s.mu.
+func (s *fakeServer) handleBinaryRequest(opcode byte, key string, extras, value []byte, cas uint64, respond func(status uint16, extras []byte, key string, value []byte, cas uint64)) {
+	switch opcode {
+	case opGet, opGetK, opGetKQ:
+		item := s.lookup(key)
+		if item == nil {
+			if opcode != opGetKQ {
+				respond(statusKeyNotFound, nil, "", []byte("Not found"), 0)
+			}
+			break
+		}
+		flags := binary.BigEndian.AppendUint32(nil, item.flags)
+		if opcode == opGet {
+			key = ""
+		}
+		respond(statusNoError, flags, key, item.value, item.cas)
+	case opSet, opAdd, opReplace:
+		current := s.lookup(key)
+		switch {
+		case opcode == opAdd && current != nil:
+			respond(statusKeyExists, nil, "", nil, 0)
+		case opcode == opReplace && current == nil, cas != 0 && current == nil:
+			respond(statusKeyNotFound, nil, "", nil, 0)
+		case cas != 0 && current.cas != cas:
+			respond(statusKeyExists, nil, "", nil, 0)
+		default:
+			s.cas++
+			s.items[key] = &fakeItem{
+				value:      append([]byte(nil), value...),
+				flags:      binary.BigEndian.Uint32(extras),
+				expiration: absoluteExpiration(int64(binary.BigEndian.Uint32(extras[4:]))),
+				cas:        s.cas,
+			}
+			respond(statusNoError, nil, "", nil, s.cas)
+		}
+	case opDelete:
+		if s.lookup(key) == nil {
+			respond(statusKeyNotFound, nil, "", nil, 0)
+			break
+		}
+		delete(s.items, key)
+		respond(statusNoError, nil, "", nil, 0)
+	case opNoop:
+		respond(statusNoError, nil, "", nil, 0)
+	case opVersion:
+		respond(statusNoError, nil, "", []byte("1.6.21"), 0)
+	default:
+		respond(statusUnknownCommand, nil, "", nil, 0)
+	}
+}
+
+// newScriptedServer starts a server answering every command line with the reply returned by respond;
+// data blocks are not read. It is stopped when the test ends.
+func newScriptedServer(t testing.TB, respond func(line string) string) (addr string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if _, err = io.WriteString(conn, respond(strings.TrimRight(line, "\r\n"))); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+	return ln.Addr().String()
+}
+
+// newTestClient creates a client of the given servers with the given options; it is closed when the test ends.
+func newTestClient(t testing.TB, addrs []string, opts ...Option) *Client {
+	t.Helper()
+	c, err := NewClientWithOptions(append([]Option{WithServers(addrs...)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
@@ -0,0 +1,237 @@
+package memcache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// localFallback is the in-process cache of WithLocalFallback, with the writes queued during an outage.
+// Entries and queued writes are keyed by the caller's key, since namespacing a key may need a round trip.
+type localFallback struct {
+	mu      sync.Mutex
+	size    int                      // Maximum number of entries; the least recently used one is evicted beyond it.
+	ttl     time.Duration            // How long an entry is served after it was stored.
+	entries map[string]*list.Element // Elements of order, whose values are *fallbackEntry.
+	order   *list.List               // Entries from the most to the least recently used.
+
+	queueSize int                      // Maximum number of keys with a queued write; zero drops writes during an outage.
+	pending   map[string]*pendingWrite // The last write of every key that failed during an outage, waiting to be replayed.
+	replaying map[string]*pendingWrite // The writes being replayed; a newer write of a key that reaches the servers removes it.
+	replayMu  sync.Mutex               // Held while the queued writes are replayed, so that only one caller replays them.
+}
+
+// fallbackEntry is a value of the local fallback cache.
+type fallbackEntry struct {
+	key      string
+	value    string
+	storedAt time.Time
+}
+
+// pendingWrite is a queued Set, or a Delete if deleted is set.
+type pendingWrite struct {
+	value      string
+	expiration int
+	deleted    bool
+}
+
+// newLocalFallback creates a local fallback cache of size entries served for ttl, queueing the writes of up to queueSize keys.
+func newLocalFallback(size int, ttl time.Duration, queueSize int) *localFallback {
+	return &localFallback{
+		size:      size,
+		ttl:       ttl,
+		entries:   make(map[string]*list.Element),
+		order:     list.New(),
+		queueSize: queueSize,
+		pending:   make(map[string]*pendingWrite),
+		replaying: make(map[string]*pendingWrite),
+	}
+}
+
+// get returns the value stored under key if it is younger than the TTL.
+func (f *localFallback) get(key string) (value string, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	elem, ok := f.entries[key]
+	if !ok {
+		return
+	}
+	entry := elem.Value.(*fallbackEntry)
+	if time.Since(entry.storedAt) >= f.ttl {
+		f.order.Remove(elem)
+		delete(f.entries, key)
+		return "", false
+	}
+	f.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// put stores value under key, evicting the least recently used entry if the cache is full.
+func (f *localFallback) put(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if elem, ok := f.entries[key]; ok {
+		elem.Value = &fallbackEntry{key: key, value: value, storedAt: time.Now()}
+		f.order.MoveToFront(elem)
+		return
+	}
+	f.entries[key] = f.order.PushFront(&fallbackEntry{key: key, value: value, storedAt: time.Now()})
+	if f.order.Len() > f.size {
+		oldest := f.order.Back()
+		f.order.Remove(oldest)
+		delete(f.entries, oldest.Value.(*fallbackEntry).key)
+	}
+}
+
+// remove drops the entry of key, if any.
+func (f *localFallback) remove(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if elem, ok := f.entries[key]; ok {
+		f.order.Remove(elem)
+		delete(f.entries, key)
+	}
+}
+
+// purge drops every entry, e.g. once the namespace is invalidated.
+func (f *localFallback) purge() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clear(f.entries)
+	f.order.Init()
+}
+
+// queue records the write of key to replay it once the servers are back, replacing any write of key queued before.
+// It reports false if the queue is disabled or full.
+func (f *localFallback) queue(key string, write *pendingWrite) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.pending[key]; !ok && len(f.pending) >= f.queueSize {
+		return false
+	}
+	f.pending[key] = write
+	return true
+}
+
+// dequeue drops the queued write of key, or the one being replayed, once a newer write of key reached the servers.
+func (f *localFallback) dequeue(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.pending, key)
+	delete(f.replaying, key)
+}
+
+// startReplay moves every queued write to the writes being replayed and returns their keys.
+func (f *localFallback) startReplay() (keys []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, write := range f.pending {
+		f.replaying[key] = write
+		keys = append(keys, key)
+	}
+	clear(f.pending)
+	return
+}
+
+// replayed removes the write of key from the writes being replayed and returns it,
+// or nil if a newer write of key reached the servers meanwhile.
+func (f *localFallback) replayed(key string) (write *pendingWrite) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	write = f.replaying[key]
+	delete(f.replaying, key)
+	return
+}
+
+// requeue queues again a replayed write that failed, unless a newer write of its key was queued meanwhile.
+func (f *localFallback) requeue(key string, write *pendingWrite) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, newer := f.pending[key]; !newer {
+		f.pending[key] = write
+	}
+}
+
+// isOutage reports whether err means the servers of a key cannot be reached, so that WithLocalFallback applies.
+func isOutage(err error) bool {
+	return isNetworkError(err) || errors.Is(err, ErrInsufficientServers)
+}
+
+// fallbackRead is deferred by Get. A value read from the servers is stored in the local fallback cache,
+// and a read that failed because of an outage is answered from it if it holds the key.
+func (c *Client) fallbackRead(key string, value *string, err *error) {
+	f := c.fallback
+	switch {
+	case *err == nil:
+		f.put(key, *value)
+		c.replayWrites()
+	case isOutage(*err):
+		if cached, ok := f.get(key); ok {
+			*value, *err = cached, nil
+		}
+	case errors.Is(*err, ErrNotFound):
+		f.remove(key)
+	}
+}
+
+// fallbackWrite is deferred by Set and Delete. A write that reached the servers updates the local fallback cache;
+// during an outage, it is queued if WithLocalFallbackWriteQueue allows it, and reported with ErrWriteQueued.
+func (c *Client) fallbackWrite(key string, write *pendingWrite, err *error) {
+	f := c.fallback
+	switch {
+	// Deleting a key that does not exist still leaves it absent.
+	case *err == nil, write.deleted && errors.Is(*err, ErrStoreFailed):
+		f.dequeue(key)
+		if write.deleted {
+			f.remove(key)
+		} else {
+			f.put(key, write.value)
+		}
+		c.replayWrites()
+	case isOutage(*err):
+		if !f.queue(key, write) {
+			return
+		}
+		if write.deleted {
+			f.remove(key)
+		} else {
+			f.put(key, write.value)
+		}
+		*err = errors.Join(ErrWriteQueued, *err)
+	}
+}
+
+// replayWrites sends the writes queued during an outage in the background, once a command reached the servers again.
+// Writes that fail because of an outage again are queued again, unless a newer write of their key was queued meanwhile.
+func (c *Client) replayWrites() {
+	f := c.fallback
+	if !f.replayMu.TryLock() {
+		return
+	}
+	keys := f.startReplay()
+	if len(keys) == 0 {
+		f.replayMu.Unlock()
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer f.replayMu.Unlock()
+		for _, key := range keys {
+			write := f.replayed(key)
+			if write == nil {
+				continue
+			}
+			var err error
+			if write.deleted {
+				err = c.delete(key)
+			} else {
+				err = c.set(key, write.value, write.expiration)
+			}
+			if isOutage(err) {
+				f.requeue(key, write)
+			}
+		}
+	}()
+}
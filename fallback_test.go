@@ -0,0 +1,108 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLocalFallbackLRU(t *testing.T) {
+	f := newLocalFallback(2, 50*time.Millisecond, 0)
+	f.put("a", "1")
+	f.put("b", "2")
+	f.get("a")
+	// b is the least recently used entry, so it is evicted.
+	f.put("c", "3")
+	if _, ok := f.get("b"); ok {
+		t.Error("the least recently used entry was kept beyond the size")
+	}
+	if value, ok := f.get("a"); !ok || value != "1" {
+		t.Errorf("get(a) = %q, %v; want the recently used entry", value, ok)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := f.get("c"); ok {
+		t.Error("an entry was served beyond its TTL")
+	}
+}
+
+func TestLocalFallback(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"WithLocalFallback(0, time.Minute)": WithLocalFallback(0, time.Minute),
+		"WithLocalFallback(10, 0)":          WithLocalFallback(10, 0),
+		"WithLocalFallbackWriteQueue(0)":    WithLocalFallbackWriteQueue(0),
+	} {
+		if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), opt); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("%s error = %v, want ErrInvalidOption", name, err)
+		}
+	}
+
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithLocalFallback(10, time.Minute))
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	// The server now drops every connection, as during an outage.
+	s.closeConnOn("get", "set", "delete")
+	if value, err := c.Get("key"); err != nil || value != "value" {
+		t.Errorf("Get() = %q, %v during an outage, want the cached value", value, err)
+	}
+	if _, err := c.Get("uncached"); !isOutage(err) {
+		t.Errorf("Get() error = %v for an uncached key during an outage, want the failure", err)
+	}
+	// Without a write queue, writes during an outage fail and are dropped.
+	if err := c.Set("key", "new", 0); err == nil || errors.Is(err, ErrWriteQueued) {
+		t.Errorf("Set() error = %v during an outage, want the failure", err)
+	}
+	if value, _ := c.Get("key"); value != "value" {
+		t.Errorf("Get() = %q after a dropped write, want the cached value", value)
+	}
+	s.closeConnOn()
+	if _, err := c.Get("key"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := s.stored("key"); value != "value" {
+		t.Errorf("the server holds %q, want the dropped write to stay dropped", value)
+	}
+}
+
+func TestLocalFallbackWriteQueue(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithLocalFallback(10, time.Minute), WithLocalFallbackWriteQueue(1))
+	if err := c.Set("up", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	s.closeConnOn("get", "set", "delete")
+	if err := c.Set("queued", "value", 0); !errors.Is(err, ErrWriteQueued) || !isOutage(err) {
+		t.Errorf("Set() error = %v during an outage, want ErrWriteQueued with the failure", err)
+	}
+	// The queued write is applied to the local cache right away.
+	if value, err := c.Get("queued"); err != nil || value != "value" {
+		t.Errorf("Get() = %q, %v for a queued write, want its value", value, err)
+	}
+	// The queue holds one key, so the next write is dropped.
+	if err := c.Set("dropped", "value", 0); err == nil || errors.Is(err, ErrWriteQueued) {
+		t.Errorf("Set() error = %v with a full queue, want the failure", err)
+	}
+
+	// The first command reaching the server again replays the queue in the background.
+	s.closeConnOn()
+	if _, err := c.Get("up"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if value, _, ok := s.stored("queued"); ok {
+			if value != "value" {
+				t.Errorf("the replayed write stored %q, want %q", value, "value")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the queued write was not replayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, _, ok := s.stored("dropped"); ok {
+		t.Error("a write beyond the queue size was replayed")
+	}
+}
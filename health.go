@@ -0,0 +1,90 @@
+package memcache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// startHealthCheck starts the background goroutine that pings every server at the configured interval.
+// It is stopped by Client.Close.
+func (c *Client) startHealthCheck() {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.healthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.checkHealth()
+			}
+		}
+	}()
+}
+
+// checkHealth pings every server once, concurrently so that a hung server does not delay the checks of the others,
+// and waits for every ping to finish.
+func (c *Client) checkHealth() {
+	var wg sync.WaitGroup
+	for _, server := range c.snapshotServers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.checkServerHealth(server)
+		}()
+	}
+	wg.Wait()
+}
+
+// checkServerHealth pings a server and records whether it is healthy.
+// The outcome of the ping also feeds the circuit breaker, and transitions are logged.
+// A server behind a proxy that does not forward the ping, such as twemproxy, cannot be probed and keeps its state.
+func (c *Client) checkServerHealth(server *Server) {
+	select {
+	case <-c.stop:
+		return
+	default:
+	}
+	_, err := server.Ping(min(defaultPingTimeout, c.healthInterval))
+	if errors.Is(err, ErrUnsupportedByProxy) {
+		return
+	}
+	if err == nil && c.checkAcceptingConns {
+		// A server that refuses new connections is overloaded, even though it still answers on existing ones.
+		// Servers whose stats cannot be read are judged by the ping alone.
+		if accepting, serr := server.acceptingConns(); serr == nil && !accepting {
+			err = ErrNotAcceptingConns
+		}
+	}
+	healthy := err == nil
+	if server.unhealthy.Swap(!healthy) == !healthy || c.logger == nil {
+		return
+	}
+	if healthy {
+		c.logger.Info("memcache: server is up", "addr", server.Address)
+	} else {
+		c.logger.Warn("memcache: server is down", "addr", server.Address, "err", err)
+	}
+}
+
+// Healthy reports whether at least one server is currently considered up, without any network round trip.
+// A server is up if its last background health check succeeded (see WithHealthCheck) and its circuit breaker is not open
+// (see WithCircuitBreaker); a breaker that is waiting for a probe to be let through counts as open. When neither is
+// enabled, nothing is known about the servers, and Healthy reports whether the client has any servers at all.
+// It is false once the client is closed.
+func (c *Client) Healthy() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return false
+	}
+	for _, server := range c.servers {
+		if server.Healthy() && server.State() != BreakerOpen {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package memcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthCheckBehindTwemproxy(t *testing.T) {
+	s := newFakeServer(t)
+	s.closeConnOn("version")
+	c := newTestClient(t, []string{s.addr()}, WithProxyMode(ProxyTwemproxy), WithHealthCheck(10*time.Millisecond))
+	c.checkHealth()
+	if !c.Healthy() {
+		t.Fatal("Healthy() = false, want a server that cannot be probed to keep its state")
+	}
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatalf("Set() error = %v after a health check", err)
+	}
+}
+
+func TestHealthCheckConcurrentPings(t *testing.T) {
+	const interval = 300 * time.Millisecond
+	// Hung servers accept connections and commands but never answer them.
+	hung := func(line string) string { return "" }
+	addrs := []string{newScriptedServer(t, hung), newScriptedServer(t, hung), newScriptedServer(t, hung)}
+	up := newFakeServer(t)
+	addrs = append(addrs, up.addr())
+	c := newTestClient(t, addrs, WithHealthCheck(interval))
+	start := time.Now()
+	c.checkHealth()
+	// Sequential pings would take one ping timeout per hung server.
+	if elapsed := time.Since(start); elapsed >= 2*interval {
+		t.Errorf("checkHealth() took %v, want less than %v with concurrent pings", elapsed, 2*interval)
+	}
+	for _, server := range c.servers {
+		if want := server.Address == up.addr(); server.Healthy() != want {
+			t.Errorf("%s Healthy() = %v, want %v", server.Address, server.Healthy(), want)
+		}
+	}
+}
+
+func TestClientHealthyModes(t *testing.T) {
+	// Without health tracking, Healthy only reports whether there are servers.
+	addr := newFakeServer(t).addr()
+	c := newTestClient(t, []string{addr})
+	if !c.Healthy() {
+		t.Error("Healthy() = false with a configured server")
+	}
+	if err := c.Quit(addr); err != nil {
+		t.Fatal(err)
+	}
+	if c.Healthy() {
+		t.Error("Healthy() = true without servers")
+	}
+
+	// With a circuit breaker, a server whose breaker opened is down.
+	s := newFakeServer(t)
+	c = newTestClient(t, []string{s.addr()}, WithCircuitBreaker(1, time.Minute))
+	s.close()
+	if _, err := c.Get("key"); err == nil {
+		t.Fatal("Get() succeeded with the server gone")
+	}
+	if c.Healthy() {
+		t.Errorf("Healthy() = true with the breaker %v", c.servers[0].State())
+	}
+
+	// With health checks, a server that failed its last check is down.
+	hung := newScriptedServer(t, func(line string) string { return "" })
+	c = newTestClient(t, []string{hung}, WithHealthCheck(50*time.Millisecond))
+	c.checkHealth()
+	if c.Healthy() {
+		t.Error("Healthy() = true after the only server failed its health check")
+	}
+
+	c = newTestClient(t, []string{newFakeServer(t).addr()})
+	c.Close()
+	if c.Healthy() {
+		t.Error("Healthy() = true after Close")
+	}
+}
+
+func TestAcceptingConnsCheck(t *testing.T) {
+	// The servers answer their ping, and only their accepting_conns stat tells them apart.
+	server := func(stats string) string {
+		return newScriptedServer(t, func(line string) string {
+			if line == "version" {
+				return "VERSION 1.6.21\r\n"
+			}
+			return stats
+		})
+	}
+	refusing := server("STAT accepting_conns 0\r\nEND\r\n")
+	accepting := server("STAT accepting_conns 1\r\nEND\r\n")
+	unreadable := server("ERROR\r\n")
+	addrs := []string{refusing, accepting, unreadable}
+
+	c := newTestClient(t, addrs, WithHealthCheck(time.Hour), WithAcceptingConnsCheck())
+	c.checkHealth()
+	for _, server := range c.servers {
+		if want := server.Address != refusing; server.Healthy() != want {
+			t.Errorf("%s Healthy() = %v, want %v", server.Address, server.Healthy(), want)
+		}
+	}
+
+	// Without the option, the ping alone decides.
+	c = newTestClient(t, addrs, WithHealthCheck(time.Hour))
+	c.checkHealth()
+	for _, server := range c.servers {
+		if !server.Healthy() {
+			t.Errorf("%s Healthy() = false without WithAcceptingConnsCheck", server.Address)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package memcache
+
+// HookInfo describes a client operation passed to hooks.
+type HookInfo struct {
+	Operation string // The name of the Client method, e.g. "Get".
+	Key       string // The key as given by the caller; empty for operations that are not about a single key.
+	Server    string // The address of the server the operation is sent to; empty for operations on all servers.
+}
+
+// Hook is called before a client operation, e.g. to start a tracing span, and returns a function that is called
+// with the final error of the operation once it completes, e.g. to record the error and end the span.
+// The returned function may be nil.
+type Hook func(info HookInfo) (done func(err error))
+
+// hook runs the hooks before an operation about a single key and returns the function that runs them after it.
+// The server is the one the key maps to. It is meant to be deferred as defer c.hook(...)() with a pointer to the named error result.
+func (c *Client) hook(operation, key string, err *error) func() {
+	if len(c.hooks) == 0 {
+		return func() {}
+	}
+	info := HookInfo{Operation: operation, Key: key}
+	if key != "" {
+		if fullKey, nerr := c.namespacedKey(key); nerr == nil {
+			if servers, perr := c.pickServers(fullKey, 1); perr == nil {
+				info.Server = servers[0].Address
+			}
+		}
+	}
+	return c.runHooks(info, err)
+}
+
+// hookServer is hook for operations sent to the server with the given address.
+func (c *Client) hookServer(operation, addr string, err *error) func() {
+	if len(c.hooks) == 0 {
+		return func() {}
+	}
+	return c.runHooks(HookInfo{Operation: operation, Server: addr}, err)
+}
+
+// runHooks calls every hook in order and returns a function calling their done functions in reverse order,
+// so that hooks nest like middleware.
+func (c *Client) runHooks(info HookInfo, err *error) func() {
+	dones := make([]func(err error), 0, len(c.hooks))
+	for _, h := range c.hooks {
+		if done := h(info); done != nil {
+			dones = append(dones, done)
+		}
+	}
+	return func() {
+		for i := len(dones) - 1; i >= 0; i-- {
+			dones[i](*err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestHooksReceiveFinalErrors(t *testing.T) {
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithHook(nil)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithHook(nil) error = %v, want ErrInvalidOption", err)
+	}
+	s := newFakeServer(t)
+	var events []string
+	var errs []error
+	hook := func(name string) Hook {
+		return func(info HookInfo) func(err error) {
+			events = append(events, fmt.Sprintf("%s before %s %q %s", name, info.Operation, info.Key, info.Server))
+			return func(err error) {
+				events = append(events, fmt.Sprintf("%s after %s", name, info.Operation))
+				errs = append(errs, err)
+			}
+		}
+	}
+	// A hook without a done function is allowed.
+	silent := func(HookInfo) func(err error) { return nil }
+	c := newTestClient(t, []string{s.addr()}, WithHook(hook("outer")), WithHook(silent), WithHook(hook("inner")))
+
+	if _, err := c.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() error = %v, want ErrNotFound", err)
+	}
+	want := []string{
+		fmt.Sprintf("outer before Get %q %s", "missing", s.addr()),
+		fmt.Sprintf("inner before Get %q %s", "missing", s.addr()),
+		"inner after Get",
+		"outer after Get",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("hook events = %q, want %q", events, want)
+	}
+	if len(errs) != 2 || !errors.Is(errs[0], ErrNotFound) || !errors.Is(errs[1], ErrNotFound) {
+		t.Errorf("done functions received %v, want the final ErrNotFound", errs)
+	}
+
+	events, errs = nil, nil
+	if _, err := c.Version(s.addr()); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("outer before Version %q %s", "", s.addr()); len(events) == 0 || events[0] != want || errs[0] != nil {
+		t.Errorf("hook events = %q with errors %v, want %q and no error", events, errs, want)
+	}
+}
@@ -0,0 +1,99 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// SetIdempotent stores value under the given key like Set, tagged with a deduplication token generated by the caller,
+// so that a Set retried after a lost response can tell whether the first attempt was applied.
+// Retries must pass the same token; a new write of the key must use a new one.
+// It reports applied true if this call stored the value, and false if the value was already stored with the same token.
+//
+// The token is hashed into the CAS value of the item with the "E" flag of a meta set command, so it costs no storage
+// beyond the 8-byte CAS value every item already carries; servers started with -C, which keep no CAS values, cannot store it.
+// The item is read with its CAS token first and written with a compare-and-swap, so a concurrent write in between is
+// detected and the key is read again, a bounded number of times.
+// It requires meta command support with the "E" flag; it returns ErrMetaUnsupported if the server does not understand
+// meta commands, ErrCommandFailed if it rejects the flag, ErrInvalidOption for an empty token, and ErrExists if every attempt conflicted.
+func (c *Client) SetIdempotent(key, value string, expiration int, token string) (applied bool, err error) {
+	defer c.hook("SetIdempotent", key, &err)()
+	if token == "" {
+		err = ErrInvalidOption
+		return
+	}
+	cas := tokenCAS(token)
+	for range compareAndSetRetries + 1 {
+		item, found, err := c.GetFull(key)
+		if err != nil {
+			return false, err
+		}
+		if found && item.CAS == cas {
+			return false, nil
+		}
+		fullKey, err := c.namespacedKey(key)
+		if err != nil {
+			return false, err
+		}
+		server, err := c.pickWriteServer(fullKey)
+		if err != nil {
+			return false, err
+		}
+		// Without an item to compare against, the write must not replace one created meanwhile.
+		compare := "ME"
+		if found {
+			compare = fmt.Sprintf("C%d", item.CAS)
+		}
+		err = server.metaSetToken(fullKey, value, expiration, compare, cas)
+		// The key was created, modified or deleted by another client since it was read.
+		if errors.Is(err, ErrStoreFailed) {
+			continue
+		}
+		return err == nil, err
+	}
+	return false, ErrExists
+}
+
+// tokenCAS hashes a deduplication token of SetIdempotent into the CAS value stored with the item.
+func tokenCAS(token string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(token))
+	return h.Sum64()
+}
+
+// metaSetToken stores value under key with a meta set command whose compare flag, either a "C" flag or the "ME" add mode,
+// guards against concurrent writes, and which sets the CAS value of the item to cas.
+// A failed comparison is reported as ErrStoreFailed, joined with the reason like storeError.
+func (s *Server) metaSetToken(key, value string, expiration int, compare string, cas uint64) (err error) {
+	// ms <key> <datalen> <flags>*\r\n<data>\r\n
+	cmd := fmt.Sprintf("ms %s %d T%d %s E%d\r\n%s\r\n", key, len(value), expiration, compare, cas, value)
+	err = s.do([]byte(cmd), func(reader *bufio.Reader) (err error) {
+		line, err := readLine(reader)
+		if err != nil {
+			return
+		}
+		status, _, _ := strings.Cut(line, " ")
+		switch status {
+		case "HD":
+		case "NS":
+			err = errors.Join(ErrStoreFailed, ErrNotStored)
+		case "NF":
+			err = errors.Join(ErrStoreFailed, ErrCASNotFound)
+		case "EX":
+			err = errors.Join(ErrStoreFailed, ErrExists)
+		case "ERROR":
+			// A server without meta commands also parses the data block as one or more commands, whose responses
+			// cannot be known in advance, so the connection is discarded instead of reading them.
+			err = errors.Join(ErrMetaUnsupported, errDesync)
+		case "CLIENT_ERROR", "SERVER_ERROR":
+			err = errors.Join(ErrCommandFailed, errors.New(line))
+		default:
+			err = errors.Join(ErrUnexpectedResponse, errors.New(line))
+		}
+		return
+	})
+	return
+}
@@ -0,0 +1,99 @@
+package memcache
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetIdempotentWithoutMetaDiscardsConnection(t *testing.T) {
+	// A server without meta commands answers the meta set line with ERROR, then runs its data block as a command.
+	addr := newScriptedServer(t, func(line string) string {
+		switch {
+		case strings.HasPrefix(line, "ms "):
+			return "ERROR\r\n"
+		case line == "get other":
+			return "VALUE other 0 5\r\nother\r\nEND\r\n"
+		case line == "get key", line == "gets key":
+			return "END\r\n"
+		}
+		return "ERROR\r\n"
+	})
+	c := newTestClient(t, []string{addr}, WithSingleConnection())
+	if _, err := c.SetIdempotent("key", "get other", 0, "token"); !errors.Is(err, ErrMetaUnsupported) {
+		t.Fatalf("SetIdempotent() error = %v, want ErrMetaUnsupported", err)
+	}
+	// The response to the data block would otherwise be read as the response of the next command.
+	if _, err := c.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() error = %v after SetIdempotent, want ErrNotFound", err)
+	}
+}
+
+// lostAckConn delivers the whole first write and then reports it as failed, as a connection reset right after the request would.
+type lostAckConn struct {
+	net.Conn
+	done bool
+}
+
+func (c *lostAckConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	if c.done || err != nil {
+		return
+	}
+	c.done = true
+	return n, errors.New("connection reset after write")
+}
+
+func TestNonIdempotentCommandsAreNotResent(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithSingleConnection())
+	if err := c.Set("counter", "10", 0); err != nil {
+		t.Fatal(err)
+	}
+	server := c.servers[0]
+	failNextWrite := func() {
+		conn := <-server.pool.conns
+		// The failed command before closed the connection, so dial the next one now.
+		if err := conn.connect(); err != nil {
+			t.Fatal(err)
+		}
+		conn.conn = &lostAckConn{Conn: conn.conn}
+		server.pool.conns <- conn
+	}
+	tests := []struct {
+		name    string
+		command string
+		op      func() error
+	}{
+		{"Increment", "incr counter 1", func() error { _, err := c.Increment("counter", 1); return err }},
+		{"Decrement", "decr counter 3", func() error { _, err := c.Decrement("counter", 3); return err }},
+		{"Append", "append counter 0 0 1", func() error { return c.Append("counter", "0") }},
+		{"Prepend", "prepend counter 0 0 1", func() error { return c.Prepend("counter", "1") }},
+	}
+	for _, tt := range tests {
+		failNextWrite()
+		if err := tt.op(); !errors.Is(err, ErrWriteFailed) {
+			t.Errorf("%s() error = %v after a lost acknowledgment, want ErrWriteFailed", tt.name, err)
+		}
+		// Only the first, failed write reached the server, which reads it asynchronously on the old connection.
+		sent := 0
+		for deadline := time.Now().Add(time.Second); sent == 0 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			sent = 0
+			for _, cmd := range s.received() {
+				if cmd == tt.command {
+					sent++
+				}
+			}
+		}
+		if sent != 1 {
+			t.Errorf("%s() sent %q %d times, want once", tt.name, tt.command, sent)
+		}
+	}
+	// Idempotent commands are resent on a new connection.
+	failNextWrite()
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Errorf("Set() error = %v after a failed write, want it resent", err)
+	}
+}
@@ -0,0 +1,92 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Item is a value stored in memcached along with its metadata.
+type Item struct {
+	Key        string // The key the item is stored under.
+	Value      []byte // The stored data.
+	Flags      uint32 // Opaque flags stored alongside the value, e.g. to mark its serialization format.
+	Expiration int    // The expiration time in seconds, or as a Unix timestamp; NeverExpire keeps the item until evicted.
+	CAS        uint64 // The CAS token, set only when retrieved with a "gets" command.
+}
+
+// storageCommand builds a storage command such as "set" or "add" for the item.
+func storageCommand(verb string, item *Item) string {
+	// <verb> <key> <flags> <exptime> <bytes>\r\n<data>\r\n
+	return fmt.Sprintf("%s %s %d %d %d\r\n%s\r\n", verb, item.Key, item.Flags, item.Expiration, len(item.Value), item.Value)
+}
+
+// maxKeyLength is the maximum length of a key accepted by memcached.
+const maxKeyLength = 250
+
+// validateKey checks that key can be sent in a text protocol command line.
+// Keys must be 1 to 250 bytes long and must not contain whitespace or control characters,
+// otherwise they could split the command line or inject a second command.
+func validateKey(key string) (err error) {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		err = ErrInvalidKey
+		return
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			err = ErrInvalidKey
+			return
+		}
+	}
+	return
+}
+
+// storeError maps a failed response of a storage command to an error.
+// A "CLIENT_ERROR bad data chunk" response means the data block did not match its declared length and is reported as ErrBadDataChunk.
+// The responses declining the store are reported as ErrStoreFailed joined with the reason, so that callers can tell them apart
+// from other failures: ErrNotStored for "NOT_STORED", e.g. an "add" of a key that exists, ErrExists for "EXISTS",
+// and ErrCASNotFound for "NOT_FOUND", a "cas" of a key that does not exist.
+func storeError(resp string) error {
+	switch resp {
+	case "CLIENT_ERROR bad data chunk":
+		return ErrBadDataChunk
+	case "NOT_STORED":
+		return errors.Join(ErrStoreFailed, ErrNotStored)
+	case "EXISTS":
+		// The item was modified since its CAS token was read.
+		return errors.Join(ErrStoreFailed, ErrExists)
+	case "NOT_FOUND":
+		return errors.Join(ErrStoreFailed, ErrCASNotFound)
+	}
+	return ErrStoreFailed
+}
+
+// deleteCommand builds a "delete" command for the key.
+// Only the single-argument form is sent: memcached 1.4 and later reject the legacy "delete <key> <time>" form.
+func deleteCommand(key string) string {
+	// delete <key>\r\n
+	return fmt.Sprintf("delete %s\r\n", key)
+}
+
+// deleteError maps a failed response of a "delete" command to an error.
+// A CLIENT_ERROR means the server rejected the command line, which only the legacy form with a time argument triggers.
+func deleteError(resp string) error {
+	if strings.HasPrefix(resp, "CLIENT_ERROR") {
+		return errors.Join(ErrDeleteTime, errors.New(resp))
+	}
+	return ErrStoreFailed
+}
+
+// checkDeleteForm returns ErrDeleteTime if cmd is a "delete" command in the legacy form with a time argument,
+// which modern servers answer with a CLIENT_ERROR instead of deleting the key.
+func checkDeleteForm(cmd string) (err error) {
+	fields := strings.Fields(cmd)
+	if len(fields) < 3 || fields[0] != "delete" {
+		return
+	}
+	// delete <key> [noreply]\r\n
+	if len(fields) > 3 || fields[2] != "noreply" {
+		err = ErrDeleteTime
+	}
+	return
+}
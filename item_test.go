@@ -0,0 +1,230 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func FuzzValidateKey(f *testing.F) {
+	for _, seed := range []string{"key", "", " ", "a b", "a\r\nflush_all", "\x00", "\x7f", "ключ", strings.Repeat("k", 250), strings.Repeat("k", 251)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, key string) {
+		if validateKey(key) != nil {
+			return
+		}
+		if len(key) == 0 || len(key) > maxKeyLength {
+			t.Fatalf("validateKey accepted a key of %d bytes", len(key))
+		}
+		for i := 0; i < len(key); i++ {
+			if key[i] <= ' ' || key[i] == 0x7f {
+				t.Fatalf("validateKey accepted %q with the byte %#x at %d", key, key[i], i)
+			}
+		}
+	})
+}
+
+func FuzzSetGetFraming(f *testing.F) {
+	f.Add("key", []byte("value"))
+	f.Add("k", []byte("\r\nEND\r\n"))
+	f.Add("k", []byte("x\r\nflush_all\r\n"))
+	f.Add("a b", []byte(" padded "))
+	f.Add("k", []byte{})
+	s := newFakeServer(f)
+	c := newTestClient(f, []string{s.addr()})
+	f.Fuzz(func(t *testing.T, key string, value []byte) {
+		if err := c.Set(key, string(value), 0); err != nil {
+			if !errors.Is(err, ErrInvalidKey) {
+				t.Fatalf("Set(%q) error = %v", key, err)
+			}
+			return
+		}
+		got, err := c.Get(key)
+		if err != nil || got != string(value) {
+			t.Fatalf("Get(%q) = %q, %v; want %q", key, got, err, value)
+		}
+		// Every command reached the server as a single command line.
+		for _, cmd := range s.received() {
+			if name, _, _ := strings.Cut(cmd, " "); name != "set" && name != "get" {
+				t.Fatalf("the server received the injected command %q", cmd)
+			}
+		}
+	})
+}
+
+func TestStoreErrorBadDataChunk(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	server := c.servers[0]
+	// The data block is longer than declared.
+	resp, err := server.WriteCommand("set key 0 0 2\r\nabcd\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storeError(resp); !errors.Is(err, ErrBadDataChunk) {
+		t.Fatalf("storeError(%q) = %v, want ErrBadDataChunk", resp, err)
+	}
+	// The leftover bytes of the data block do not desynchronize the next command.
+	if err = c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v; want %q", got, err, "value")
+	}
+}
+
+func TestStoreErrorResponses(t *testing.T) {
+	tests := []struct {
+		resp string
+		errs []error
+	}{
+		{"CLIENT_ERROR bad data chunk", []error{ErrBadDataChunk}},
+		{"NOT_STORED", []error{ErrStoreFailed, ErrNotStored}},
+		{"EXISTS", []error{ErrStoreFailed, ErrExists}},
+		{"NOT_FOUND", []error{ErrStoreFailed, ErrCASNotFound}},
+	}
+	for _, tt := range tests {
+		err := storeError(tt.resp)
+		for _, want := range tt.errs {
+			if !errors.Is(err, want) {
+				t.Errorf("storeError(%q) = %v, want %v", tt.resp, err, want)
+			}
+		}
+	}
+}
+
+func TestDeleteSendsSingleArgumentForm(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	p := c.Pipeline()
+	p.Delete("key")
+	p.Exec()
+	for _, cmd := range s.received() {
+		if strings.HasPrefix(cmd, "delete ") && cmd != "delete key" {
+			t.Errorf("sent %q, want the single-argument form", cmd)
+		}
+	}
+	// The legacy form is rejected before it reaches the server.
+	for _, cmd := range []string{"delete key 0\r\n", "delete key 0 noreply\r\n"} {
+		if _, err := c.Extra(s.addr(), cmd); !errors.Is(err, ErrDeleteTime) {
+			t.Errorf("Extra(%q) error = %v, want ErrDeleteTime", cmd, err)
+		}
+	}
+	if got := s.received(); got[len(got)-1] != "delete key" {
+		t.Errorf("the server received %q", got[len(got)-1])
+	}
+}
+
+func TestDeleteClientErrorIsDeleteTime(t *testing.T) {
+	addr := newScriptedServer(t, func(line string) string {
+		return "CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]\r\n"
+	})
+	c := newTestClient(t, []string{addr})
+	if err := c.Delete("key"); !errors.Is(err, ErrDeleteTime) {
+		t.Errorf("Delete() error = %v, want ErrDeleteTime", err)
+	}
+	if err := deleteError("NOT_FOUND"); errors.Is(err, ErrDeleteTime) {
+		t.Errorf("deleteError(NOT_FOUND) = %v, want an error other than ErrDeleteTime", err)
+	}
+}
+
+func TestFlagsRoundTrip(t *testing.T) {
+	for name, opts := range map[string][]Option{"text": nil, "binary": {WithBinaryProtocol()}} {
+		t.Run(name, func(t *testing.T) {
+			s := newFakeServer(t)
+			c := newTestClient(t, []string{s.addr()}, opts...)
+			// Every bit but FlagCompressed, which getters interpret, is returned as stored.
+			for _, flags := range []uint32{0, 1, FlagChunked | FlagJSON, math.MaxUint32 &^ FlagCompressed} {
+				key := fmt.Sprintf("key-%d", flags)
+				if err := c.SetWithFlags(key, "value", flags, 0); err != nil {
+					t.Fatal(err)
+				}
+				if value, got, err := c.GetWithFlags(key); err != nil || value != "value" || got != flags {
+					t.Errorf("GetWithFlags(%q) = %q, %#x, %v; want %q, %#x", key, value, got, err, "value", flags)
+				}
+				if item, found, err := c.GetFull(key); err != nil || !found || item.Flags != flags {
+					t.Errorf("GetFull(%q) = %v, %v, %v; want flags %#x", key, item, found, err, flags)
+				}
+			}
+		})
+	}
+}
+
+func TestParseValueLine(t *testing.T) {
+	item, n, err := parseValueLine("VALUE key 4294967295 5 42", true)
+	if err != nil || item.Key != "key" || item.Flags != math.MaxUint32 || n != 5 || item.CAS != 42 {
+		t.Errorf("parseValueLine() = %v, %d, %v", item, n, err)
+	}
+	if _, _, err = parseValueLine("END", false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("parseValueLine(END) error = %v, want ErrNotFound", err)
+	}
+	for _, line := range []string{
+		"VALUE key 4294967296 5",
+		"VALUE key -1 5",
+		"VALUE key 0 -1",
+		"VALUE key 0 five",
+		"VALUE key 0",
+		"STAT key 0 5",
+	} {
+		if _, _, err = parseValueLine(line, false); !errors.Is(err, ErrUnexpectedResponse) {
+			t.Errorf("parseValueLine(%q) error = %v, want ErrUnexpectedResponse", line, err)
+		}
+	}
+	if _, _, err = parseValueLine("VALUE key 0 5 cas", true); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("parseValueLine() error = %v for a malformed CAS token, want ErrUnexpectedResponse", err)
+	}
+}
+
+func TestGetNegativeLength(t *testing.T) {
+	addr := newScriptedServer(t, func(line string) string { return "VALUE key 0 -1\r\nEND\r\n" })
+	c := newTestClient(t, []string{addr})
+	if _, err := c.Get("key"); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("Get() error = %v for a negative length, want ErrUnexpectedResponse", err)
+	}
+}
+
+func TestGetFull(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithNamespace("app", time.Minute))
+	if err := c.SetWithFlags("key", "value", 42, 0); err != nil {
+		t.Fatal(err)
+	}
+	item, found, err := c.GetFull("key")
+	if err != nil || !found || string(item.Value) != "value" || item.Flags != 42 || item.CAS == 0 {
+		t.Fatalf("GetFull() = %+v, %v, %v; want the value with flags 42 and a CAS token", item, found, err)
+	}
+	// The item carries the caller's key, so it can be written back as is.
+	if item.Key != "key" {
+		t.Errorf("GetFull() key = %q, want the key without the namespace", item.Key)
+	}
+	item.Value = []byte("other")
+	if err = c.CASItem(item); err != nil {
+		t.Errorf("CASItem() error = %v with the token of GetFull", err)
+	}
+
+	// A miss is not an error.
+	if item, found, err = c.GetFull("missing"); err != nil || found || item != nil {
+		t.Errorf("GetFull() = %v, %v, %v for a miss, want nil, false, nil", item, found, err)
+	}
+	// A value marked as compressed that does not decompress is a failure, not a miss.
+	if err = c.SetWithFlags("corrupt", "not gzip", FlagCompressed, 0); err != nil {
+		t.Fatal(err)
+	}
+	if item, found, err = c.GetFull("corrupt"); err == nil || found || item != nil {
+		t.Errorf("GetFull() = %v, %v, %v for a corrupt value, want an error", item, found, err)
+	}
+	c.servers[0].Close()
+	if _, found, err = c.GetFull("key"); !errors.Is(err, ErrClosed) || found {
+		t.Errorf("GetFull() = %v, %v for a closed server, want ErrClosed", found, err)
+	}
+}
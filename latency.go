@@ -0,0 +1,34 @@
+package memcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyWeight is the weight given to a new sample in the exponentially weighted moving average.
+const latencyWeight = 0.2
+
+// latencyTracker keeps an exponentially weighted moving average of observed latencies.
+// It is safe for concurrent use.
+type latencyTracker struct {
+	avg atomic.Int64 // The current average in nanoseconds; zero means no sample yet.
+}
+
+// observe adds a latency sample to the moving average.
+func (l *latencyTracker) observe(d time.Duration) {
+	for {
+		old := l.avg.Load()
+		next := int64(d)
+		if old != 0 {
+			next = int64(latencyWeight*float64(d) + (1-latencyWeight)*float64(old))
+		}
+		if l.avg.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// value returns the current moving average latency.
+func (l *latencyTracker) value() time.Duration {
+	return time.Duration(l.avg.Load())
+}
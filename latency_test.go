@@ -0,0 +1,57 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	var l latencyTracker
+	if l.value() != 0 {
+		t.Fatalf("value() = %v without samples, want 0", l.value())
+	}
+	// The first sample is taken as is, and later ones move the average by latencyWeight.
+	l.observe(100 * time.Millisecond)
+	if l.value() != 100*time.Millisecond {
+		t.Errorf("value() = %v after one sample, want 100ms", l.value())
+	}
+	l.observe(0)
+	if l.value() != 80*time.Millisecond {
+		t.Errorf("value() = %v after a fast sample, want 80ms", l.value())
+	}
+}
+
+func TestLatencyAwareReads(t *testing.T) {
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithLatencyAwareReads(0)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithLatencyAwareReads(0) error = %v, want ErrInvalidOption", err)
+	}
+	addrs := []string{newFakeServer(t).addr(), newFakeServer(t).addr()}
+	plain := newTestClient(t, addrs)
+	aware := newTestClient(t, addrs, WithLatencyAwareReads(10*time.Millisecond))
+	for _, c := range []*Client{plain, aware} {
+		servers, err := c.pickServers("key", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the replica holds the value, so a read answered by the primary misses.
+		if _, err = servers[1].WriteCommand("set key 0 0 7\r\nreplica\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		servers[0].latency.observe(time.Second)
+	}
+	if _, err := plain.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v without latency-aware reads, want ErrNotFound from the primary", err)
+	}
+	if value, err := aware.Get("key"); err != nil || value != "replica" {
+		t.Errorf("Get() = %q, %v; want the value of the faster replica", value, err)
+	}
+	// Writes still go to the slow primary.
+	if err := aware.Set("key", "primary", 0); err != nil {
+		t.Fatal(err)
+	}
+	servers, _ := aware.pickServers("key", 2)
+	if value, _, err := servers[0].GetValue("key", false); err != nil || value != "primary" {
+		t.Errorf("primary GetValue() = %q, %v; want the written value", value, err)
+	}
+}
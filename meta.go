@@ -0,0 +1,148 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetaGet sends a meta get ("mg") command for the given key with the given flags, e.g. "s" for the size or "t" for the remaining TTL.
+// It returns the returned flags keyed by their flag character, and the value if the "v" flag was requested.
+// It returns ErrNotFound on a miss, and ErrMetaUnsupported if the server does not understand meta commands.
+func (s *Server) MetaGet(key string, flags ...string) (ret map[byte]string, value []byte, err error) {
+	// mg <key> <flags>*\r\n
+	cmd := strings.TrimSpace("mg "+key+" "+strings.Join(flags, " ")) + "\r\n"
+	err = s.do([]byte(cmd), func(reader *bufio.Reader) (err error) {
+		ret, value, err = readMetaGet(reader)
+		return
+	})
+	return
+}
+
+// readMetaGet reads the response of a meta get command.
+func readMetaGet(reader *bufio.Reader) (ret map[byte]string, value []byte, err error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return
+	}
+	return readMetaGetLine(reader, line)
+}
+
+// readMetaGetLine parses the response line of a meta get command that was already read, and reads the data block following it.
+func readMetaGetLine(reader *bufio.Reader, line string) (ret map[byte]string, value []byte, err error) {
+	parts := strings.Split(line, " ")
+	switch parts[0] {
+	case "EN":
+		err = ErrNotFound
+		return
+	case "ERROR":
+		err = ErrMetaUnsupported
+		return
+	case "HD":
+		ret = parseMetaFlags(parts[1:])
+		return
+	case "VA":
+		// VA <size> <flags>*\r\n<data block>\r\n
+		if len(parts) < 2 {
+			err = ErrUnexpectedResponse
+			return
+		}
+		var size int
+		size, err = strconv.Atoi(parts[1])
+		if err != nil {
+			err = errors.Join(ErrUnexpectedResponse, err)
+			return
+		}
+		ret = parseMetaFlags(parts[2:])
+		data := make([]byte, size+2)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			err = errors.Join(ErrReadFailed, err)
+			return
+		}
+		if string(data[size:]) != "\r\n" {
+			err = ErrUnexpectedResponse
+			return
+		}
+		value = data[:size]
+		return
+	}
+	err = errors.Join(ErrUnexpectedResponse, errors.New(line))
+	return
+}
+
+// parseMetaFlags parses meta return flags such as "s42" or "t-1" into a map keyed by the flag character.
+func parseMetaFlags(tokens []string) (flags map[byte]string) {
+	flags = make(map[byte]string, len(tokens))
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		flags[token[0]] = token[1:]
+	}
+	return
+}
+
+// ItemMeta is a value returned by GetMultiWithMeta along with its flags and remaining time to live.
+type ItemMeta struct {
+	Value string
+	Flags uint32
+	TTL   time.Duration // Remaining time to live; TTLNever if the item never expires, TTLUnknown if the server cannot report it.
+}
+
+// metaGetMulti retrieves the values, flags and remaining time to live of the given keys with one quiet meta get command per key,
+// terminated by a meta no-op, all in one round trip. Misses are suppressed by the "q" flag and absent from the result,
+// which is keyed by the keys returned with the "k" flag.
+// It returns ErrMetaUnsupported if the server does not understand meta commands.
+func (s *Server) metaGetMulti(keys []string) (items map[string]ItemMeta, err error) {
+	var cmd []byte
+	for _, key := range keys {
+		// mg <key> v f t k q\r\n
+		cmd = fmt.Appendf(cmd, "mg %s v f t k q\r\n", key)
+	}
+	// mn\r\n
+	cmd = append(cmd, "mn\r\n"...)
+	items = make(map[string]ItemMeta, len(keys))
+	err = s.do(cmd, func(reader *bufio.Reader) error {
+		for {
+			line, err := readLine(reader)
+			if err != nil {
+				return err
+			}
+			if line == "MN" {
+				return nil
+			}
+			if line == "ERROR" {
+				// A server without meta commands answers every request of the batch, including the no-op, with ERROR.
+				// They are all read so that the connection stays usable.
+				for range keys {
+					if _, err = readLine(reader); err != nil {
+						return err
+					}
+				}
+				return ErrMetaUnsupported
+			}
+			ret, value, err := readMetaGetLine(reader, line)
+			if err != nil {
+				return err
+			}
+			flags, err := strconv.ParseUint(ret['f'], 10, 32)
+			if err != nil {
+				return errors.Join(ErrUnexpectedResponse, err)
+			}
+			ttl, err := parseTTL(ret['t'])
+			if err != nil {
+				return err
+			}
+			items[ret['k']] = ItemMeta{Value: string(value), Flags: uint32(flags), TTL: ttl}
+		}
+	})
+	if err != nil {
+		items = nil
+	}
+	return
+}
@@ -0,0 +1,63 @@
+package memcache
+
+import (
+	"errors"
+	"strconv"
+)
+
+// compareAndSetRetries is the number of times SetIfGreater and SetIfLess read the key again after a conflicting write.
+const compareAndSetRetries = 10
+
+// SetIfGreater stores value under the given key only if it is greater than the number stored there, e.g. to track
+// the highest timestamp observed. The value is read with its CAS token and written back with a "cas" command,
+// so concurrent updates cannot lower it; on a conflict, the key is read and compared again, a bounded number of times.
+// A missing key is created with an "add" command. The number is stored as decimal text, like a counter.
+// It reports whether the value was stored; an equal or greater stored number is left unchanged with applied false.
+// It returns ErrDecodeFailed if the stored value is not a number, and ErrExists if every attempt conflicted.
+func (c *Client) SetIfGreater(key string, value uint64, expiration int) (applied bool, err error) {
+	defer c.hook("SetIfGreater", key, &err)()
+	return c.setIf(key, value, expiration, func(stored uint64) bool {
+		return value > stored
+	})
+}
+
+// SetIfLess stores value under the given key only if it is less than the number stored there, e.g. to track
+// the lowest latency observed. It works like SetIfGreater.
+func (c *Client) SetIfLess(key string, value uint64, expiration int) (applied bool, err error) {
+	defer c.hook("SetIfLess", key, &err)()
+	return c.setIf(key, value, expiration, func(stored uint64) bool {
+		return value < stored
+	})
+}
+
+// setIf stores value under the given key if the key is missing or replace accepts the number stored there,
+// as described by SetIfGreater.
+func (c *Client) setIf(key string, value uint64, expiration int, replace func(stored uint64) bool) (applied bool, err error) {
+	data := strconv.FormatUint(value, 10)
+	for range compareAndSetRetries + 1 {
+		item, found, err := c.GetFull(key)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			err = c.Add(key, data, expiration)
+		} else {
+			stored, perr := strconv.ParseUint(string(item.Value), 10, 64)
+			if perr != nil {
+				return false, errors.Join(ErrDecodeFailed, perr)
+			}
+			if !replace(stored) {
+				return false, nil
+			}
+			item.Value = []byte(data)
+			item.Expiration = expiration
+			err = c.CASItem(item)
+		}
+		// The key was created, modified or deleted by another client since it was read.
+		if errors.Is(err, ErrStoreFailed) {
+			continue
+		}
+		return err == nil, err
+	}
+	return false, ErrExists
+}
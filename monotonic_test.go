@@ -0,0 +1,84 @@
+package memcache
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetIfGreaterAndLess(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	for _, tt := range []struct {
+		name    string
+		set     func(key string, value uint64, expiration int) (bool, error)
+		value   uint64
+		applied bool
+		stored  string
+	}{
+		{"missing key", c.SetIfGreater, 10, true, "10"},
+		{"greater", c.SetIfGreater, 20, true, "20"},
+		{"equal", c.SetIfGreater, 20, false, "20"},
+		{"not greater", c.SetIfGreater, 15, false, "20"},
+		{"not less", c.SetIfLess, 30, false, "20"},
+		{"less", c.SetIfLess, 5, true, "5"},
+	} {
+		if applied, err := tt.set("mark", tt.value, 0); applied != tt.applied || err != nil {
+			t.Errorf("%s: applied = %v, %v; want %v", tt.name, applied, err, tt.applied)
+		}
+		if value, _, _ := s.stored("mark"); value != tt.stored {
+			t.Errorf("%s: stored %q, want %q", tt.name, value, tt.stored)
+		}
+	}
+
+	if err := c.Set("text", "not a number", 0); err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := c.SetIfGreater("text", 1, 0); applied || !errors.Is(err, ErrDecodeFailed) {
+		t.Errorf("SetIfGreater() = %v, %v for a non-numeric value, want ErrDecodeFailed", applied, err)
+	}
+}
+
+func TestSetIfGreaterConcurrent(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithPool(8))
+	var wg sync.WaitGroup
+	for i := range uint64(32) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.SetIfGreater("mark", i, 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	// Conflicting writes are retried, so no lower value overwrites a higher one.
+	if value, _, _ := s.stored("mark"); value != "31" {
+		t.Errorf("stored %q after concurrent updates, want the highest value 31", value)
+	}
+}
+
+func TestSetIfGreaterConflicts(t *testing.T) {
+	// Another client always modifies the key between the read and the write.
+	var cas atomic.Int64
+	addr := newScriptedServer(t, func(line string) string {
+		switch {
+		case strings.HasPrefix(line, "gets "):
+			return "VALUE mark 0 1 7\r\n5\r\nEND\r\n"
+		case strings.HasPrefix(line, "cas "):
+			cas.Add(1)
+			return "EXISTS\r\n"
+		}
+		return ""
+	})
+	c := newTestClient(t, []string{addr})
+	if applied, err := c.SetIfGreater("mark", 10, 0); applied || !errors.Is(err, ErrExists) {
+		t.Errorf("SetIfGreater() = %v, %v when every write conflicts, want ErrExists", applied, err)
+	}
+	if got := cas.Load(); got != compareAndSetRetries+1 {
+		t.Errorf("%d cas commands sent, want %d", got, compareAndSetRetries+1)
+	}
+}
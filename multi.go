@@ -0,0 +1,380 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// groupKeys namespaces the given keys and groups them by the server they map to.
+// It returns the groups of keys as stored in memcached, a map from each stored key back to the caller's key,
+// and the errors of keys that could not be routed.
+func (c *Client) groupKeys(keys []string) (groups map[*Server][]string, original map[string]string, errs map[string]error) {
+	groups = make(map[*Server][]string)
+	original = make(map[string]string, len(keys))
+	errs = make(map[string]error)
+	for _, key := range keys {
+		fullKey, err := c.namespacedKey(key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		if _, ok := original[fullKey]; ok {
+			continue
+		}
+		server, err := c.pickServer(fullKey)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		original[fullKey] = key
+		groups[server] = append(groups[server], fullKey)
+	}
+	return
+}
+
+// GetMulti retrieves the values of the given keys with one retrieval command per server.
+// The keys are grouped by server and the servers are queried concurrently; with the binary protocol,
+// each server receives a batch of quiet GetKQ requests terminated by a Noop.
+// Keys that do not exist are absent from the returned map. The error joins the errors of keys that could not be routed
+// and of servers that failed; the values of the other servers are still returned.
+// With WithBatchFailFast, the first network failure of a server aborts the requests to the other servers.
+// Each value is decompressed on its own if it is marked with FlagCompressed, like Get does (see WithCompression), so a batch
+// may mix compressed and uncompressed values; values that fail to decompress are absent and their errors joined to the error.
+func (c *Client) GetMulti(keys []string) (values map[string]string, err error) {
+	defer c.hook("GetMulti", "", &err)()
+	groups, original, errs := c.groupKeys(keys)
+	values = make(map[string]string)
+	for _, keyErr := range errs {
+		err = errors.Join(err, keyErr)
+	}
+	ctx, abort := c.batchContext()
+	defer abort()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for server, group := range groups {
+		wg.Add(1)
+		go func(server *Server, group []string) {
+			defer wg.Done()
+			// Values are copied into the map as each block is read, rather than after the whole response.
+			collect := func(item *Item) {
+				key, ok := original[item.Key]
+				if !ok {
+					return
+				}
+				decodeErr := decompressItem(item)
+				mu.Lock()
+				defer mu.Unlock()
+				if decodeErr != nil {
+					err = errors.Join(err, fmt.Errorf("%s: %w", key, decodeErr))
+					return
+				}
+				values[key] = string(item.Value)
+			}
+			var serverErr error
+			if server.binary {
+				var items []*Item
+				items, serverErr = server.binaryGetMulti(ctx, group)
+				for _, item := range items {
+					collect(item)
+				}
+			} else {
+				serverErr = server.retrieveEach(ctx, c.multiGetCommands("get", group), false, collect)
+			}
+			if isNetworkError(serverErr) {
+				abort()
+			}
+			if serverErr != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				err = errors.Join(err, serverErr)
+			}
+		}(server, group)
+	}
+	wg.Wait()
+	return
+}
+
+// multiGetCommands builds the retrieval commands for the given keys of one server, e.g. "get" or "gat <exptime>" commands.
+// The keys are split into commands of at most WithMultiGetMaxKeys keys and WithMultiGetMaxBytes bytes,
+// which are pipelined in a single round trip; a key too long for the byte limit gets a command of its own.
+func (c *Client) multiGetCommands(prefix string, keys []string) (cmds []string) {
+	var b strings.Builder
+	count := 0
+	for _, key := range keys {
+		// <prefix> <key>*\r\n
+		if count > 0 && (count == c.multiGetMaxKeys || b.Len()+1+len(key)+2 > c.multiGetMaxBytes) {
+			b.WriteString("\r\n")
+			cmds = append(cmds, b.String())
+			b.Reset()
+			count = 0
+		}
+		if count == 0 {
+			b.WriteString(prefix)
+		}
+		b.WriteByte(' ')
+		b.WriteString(key)
+		count++
+	}
+	if count > 0 {
+		b.WriteString("\r\n")
+		cmds = append(cmds, b.String())
+	}
+	return
+}
+
+// batchContext returns the context of the requests a batch operation sends to each server, and the function aborting them.
+// Without WithBatchFailFast, the context is never done and the requests cannot be aborted.
+func (c *Client) batchContext() (ctx context.Context, abort context.CancelFunc) {
+	if !c.batchFailFast {
+		return context.Background(), func() {}
+	}
+	return context.WithCancel(context.Background())
+}
+
+// GetMultiWithMeta retrieves the values of the given keys along with their flags and remaining time to live,
+// e.g. to serve a batch and decide which keys to refresh ahead of their expiration in the same call.
+// Each server receives one quiet meta get command per key with the "v", "f", "t" and "k" flags, terminated by a meta no-op,
+// so it is answered in a single round trip; the servers are queried concurrently.
+// Servers without meta commands, servers behind a proxy that does not forward them and servers using the binary protocol
+// are queried with a multi-key "get" instead, and their items report TTLUnknown.
+// Keys that do not exist are absent from the returned map. Values are decompressed and errors reported like GetMulti.
+func (c *Client) GetMultiWithMeta(keys []string) (items map[string]ItemMeta, err error) {
+	defer c.hook("GetMultiWithMeta", "", &err)()
+	groups, original, errs := c.groupKeys(keys)
+	items = make(map[string]ItemMeta)
+	for _, keyErr := range errs {
+		err = errors.Join(err, keyErr)
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for server, group := range groups {
+		wg.Add(1)
+		go func(server *Server, group []string) {
+			defer wg.Done()
+			found, serverErr := c.metaGetMultiOrFallback(server, group)
+			mu.Lock()
+			defer mu.Unlock()
+			if serverErr != nil {
+				err = errors.Join(err, serverErr)
+				return
+			}
+			for fullKey, meta := range found {
+				key, ok := original[fullKey]
+				if !ok {
+					continue
+				}
+				item := &Item{Value: []byte(meta.Value), Flags: meta.Flags}
+				if decodeErr := decompressItem(item); decodeErr != nil {
+					err = errors.Join(err, fmt.Errorf("%s: %w", key, decodeErr))
+					continue
+				}
+				meta.Value, meta.Flags = string(item.Value), item.Flags
+				items[key] = meta
+			}
+		}(server, group)
+	}
+	wg.Wait()
+	return
+}
+
+// metaGetMultiOrFallback retrieves the given keys from the server with metaGetMulti, or with a multi-key "get"
+// reporting TTLUnknown if the server cannot answer meta commands.
+func (c *Client) metaGetMultiOrFallback(server *Server, keys []string) (items map[string]ItemMeta, err error) {
+	if !server.binary {
+		items, err = server.metaGetMulti(keys)
+		if !errors.Is(err, ErrMetaUnsupported) && !errors.Is(err, ErrUnsupportedByProxy) {
+			return
+		}
+	}
+	var found []*Item
+	if server.binary {
+		found, err = server.binaryGetMulti(context.Background(), keys)
+	} else {
+		found, err = server.retrieve(c.multiGetCommands("get", keys), false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	items = make(map[string]ItemMeta, len(found))
+	for _, item := range found {
+		items[item.Key] = ItemMeta{Value: string(item.Value), Flags: item.Flags, TTL: TTLUnknown}
+	}
+	return
+}
+
+// GetAndTouchMulti retrieves the values of the given keys and updates their expiration time in the same round trip per server,
+// using a multi-key "gat" command. The keys are grouped by server and the servers are queried concurrently.
+// On servers without "gat" support it falls back to a "touch" and a "get" per key.
+// Values are decompressed like GetMulti does. Keys that do not exist are absent from both maps;
+// errs holds the error of every key whose server failed or whose value failed to decompress.
+func (c *Client) GetAndTouchMulti(keys []string, expiration int) (values map[string]string, errs map[string]error) {
+	groups, original, errs := c.groupKeys(keys)
+	values = make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for server, group := range groups {
+		wg.Add(1)
+		go func(server *Server, group []string) {
+			defer wg.Done()
+			items, err := server.retrieve(c.multiGetCommands(fmt.Sprintf("gat %d", expiration), group), false)
+			if errors.Is(err, ErrUnsupportedCommand) {
+				items, err = touchAndGet(server, group, expiration)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				for _, key := range group {
+					errs[original[key]] = err
+				}
+				return
+			}
+			for _, item := range items {
+				key, ok := original[item.Key]
+				if !ok {
+					continue
+				}
+				if err = decompressItem(item); err != nil {
+					errs[key] = err
+					continue
+				}
+				values[key] = string(item.Value)
+			}
+		}(server, group)
+	}
+	wg.Wait()
+	return
+}
+
+// touchAndGet emulates a multi-key "gat" on servers that do not support it with a "touch" and a "get" per key.
+// Keys that do not exist are skipped.
+func touchAndGet(server *Server, keys []string, expiration int) (items []*Item, err error) {
+	for _, key := range keys {
+		// touch <key> <exptime>\r\n
+		resp, err := server.WriteCommand(fmt.Sprintf("touch %s %d\r\n", key, expiration))
+		if err != nil {
+			return nil, err
+		}
+		if !touched(resp) {
+			continue
+		}
+		item, err := server.GetItem(key, false)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return
+}
+
+// GetsMulti retrieves the items of the given keys, including their flags and CAS tokens, with one "gets" command per server.
+// The keys are grouped by server and the servers are queried concurrently; the items carry the caller's keys.
+// Values are decompressed like GetFull does. Keys that do not exist are absent from both maps;
+// errs holds the error of every key that could not be routed, whose server failed or whose value failed to decompress.
+func (c *Client) GetsMulti(keys []string) (items map[string]*Item, errs map[string]error) {
+	return c.getItems(keys, true)
+}
+
+// getItems retrieves the items of the given keys with one "get" or "gets" command per server, as described by GetsMulti.
+// With the binary protocol, the items always carry their CAS tokens.
+func (c *Client) getItems(keys []string, withCAS bool) (items map[string]*Item, errs map[string]error) {
+	groups, original, errs := c.groupKeys(keys)
+	items = make(map[string]*Item)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for server, group := range groups {
+		wg.Add(1)
+		go func(server *Server, group []string) {
+			defer wg.Done()
+			var found []*Item
+			var err error
+			if server.binary {
+				found, err = server.binaryGetMulti(context.Background(), group)
+			} else {
+				verb := "get"
+				if withCAS {
+					verb = "gets"
+				}
+				found, err = server.retrieve(c.multiGetCommands(verb, group), withCAS)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				for _, key := range group {
+					errs[original[key]] = err
+				}
+				return
+			}
+			for _, item := range found {
+				key, ok := original[item.Key]
+				if !ok {
+					continue
+				}
+				if err = decompressItem(item); err != nil {
+					errs[key] = err
+					continue
+				}
+				item.Key = key
+				items[key] = item
+			}
+		}(server, group)
+	}
+	wg.Wait()
+	return
+}
+
+// BatchUpdate applies update to the items of the given keys with optimistic concurrency control.
+// It reads every item with its CAS token using GetsMulti, calls update to modify the item in place, and writes it back with CASItem.
+// If another client modified a key in between, only that key is read again and updated again, up to retries more times.
+// Returning an error from update leaves the key unchanged and reports the error for the key.
+//
+// BatchUpdate is not a transaction: each key is updated atomically on its own, but there is no atomicity across keys.
+// Other clients can observe some keys updated and others not, and a key that fails does not undo the updates of the others.
+// The expiration time of an item cannot be read back, so update must set Expiration unless the item should never expire.
+//
+// results holds the outcome of every key: nil on success, ErrNotFound for a missing key, ErrExists if every attempt
+// conflicted with another write, or the error of update or of the server.
+func (c *Client) BatchUpdate(keys []string, retries int, update func(item *Item) error) (results map[string]error) {
+	items, errs := c.GetsMulti(keys)
+	results = make(map[string]error, len(keys))
+	for _, key := range keys {
+		if _, done := results[key]; done {
+			continue
+		}
+		item, ok := items[key]
+		switch {
+		case errs[key] != nil:
+			results[key] = errs[key]
+		case !ok:
+			results[key] = ErrNotFound
+		default:
+			results[key] = c.updateItem(item, retries, update)
+		}
+	}
+	return
+}
+
+// updateItem applies update to an item read with its CAS token and writes it back with CASItem.
+// On a conflict, the item is read again with GetFull and the update is retried up to retries times.
+func (c *Client) updateItem(item *Item, retries int, update func(item *Item) error) (err error) {
+	for attempt := 0; ; attempt++ {
+		if err = update(item); err != nil {
+			return
+		}
+		if err = c.CASItem(item); !errors.Is(err, ErrExists) || attempt >= retries {
+			return
+		}
+		var found bool
+		if item, found, err = c.GetFull(item.Key); err != nil {
+			return
+		}
+		if !found {
+			return ErrNotFound
+		}
+	}
+}
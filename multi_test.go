@@ -0,0 +1,566 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetMultiThousandKeys(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		if err := c.Set(keys[i], fmt.Sprintf("value-%d", i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values, err := c.GetMulti(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != len(keys) {
+		t.Fatalf("GetMulti() returned %d values, want %d", len(values), len(keys))
+	}
+	for i, key := range keys {
+		if want := fmt.Sprintf("value-%d", i); values[key] != want {
+			t.Errorf("values[%q] = %q, want %q", key, values[key], want)
+		}
+	}
+	// The keys are split into commands of defaultMultiGetMaxKeys keys.
+	gets := 0
+	for _, cmd := range s.received() {
+		if strings.HasPrefix(cmd, "get ") {
+			gets++
+		}
+	}
+	if want := len(keys) / defaultMultiGetMaxKeys; gets != want {
+		t.Errorf("sent %d get commands, want %d", gets, want)
+	}
+}
+
+func TestMultiGetLimits(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"WithMultiGetMaxKeys(0)":   WithMultiGetMaxKeys(0),
+		"WithMultiGetMaxKeys(-1)":  WithMultiGetMaxKeys(-1),
+		"WithMultiGetMaxBytes(0)":  WithMultiGetMaxBytes(0),
+		"WithMultiGetMaxBytes(-1)": WithMultiGetMaxBytes(-1),
+	} {
+		if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), opt); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("%s error = %v, want ErrInvalidOption", name, err)
+		}
+	}
+
+	s := newFakeServer(t)
+	// "gets k-0 k-1\r\n" is 14 bytes, so the byte limit allows two keys of three characters.
+	c := newTestClient(t, []string{s.addr()}, WithMultiGetMaxKeys(3), WithMultiGetMaxBytes(14))
+	long := strings.Repeat("x", 20)
+	keys := []string{"k-0", "k-1", "k-2", long, "k-3"}
+	for _, key := range keys {
+		if err := c.Set(key, "value", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items, errs := c.GetsMulti(keys)
+	if len(errs) > 0 || len(items) != len(keys) {
+		t.Fatalf("GetsMulti() = %d items, %v; want %d", len(items), errs, len(keys))
+	}
+	var gets []string
+	for _, cmd := range s.received() {
+		if strings.HasPrefix(cmd, "gets ") {
+			gets = append(gets, cmd)
+		}
+	}
+	// The key longer than the byte limit is still sent, in a command of its own.
+	if want := []string{"gets k-0 k-1", "gets k-2", "gets " + long, "gets k-3"}; !slices.Equal(gets, want) {
+		t.Errorf("sent %q, want %q", gets, want)
+	}
+
+	s = newFakeServer(t)
+	c = newTestClient(t, []string{s.addr()}, WithMultiGetMaxKeys(2))
+	if _, err := c.GetMulti([]string{"a", "b", "c", "d", "e"}); err != nil {
+		t.Fatal(err)
+	}
+	gets = nil
+	for _, cmd := range s.received() {
+		if strings.HasPrefix(cmd, "get ") {
+			gets = append(gets, cmd)
+		}
+	}
+	if want := []string{"get a b", "get c d", "get e"}; !slices.Equal(gets, want) {
+		t.Errorf("sent %q, want %q", gets, want)
+	}
+}
+
+func TestRetrieveEachReusesScratchBuffer(t *testing.T) {
+	var resp strings.Builder
+	keys := make([]string, 1000)
+	value := strings.Repeat("v", 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		fmt.Fprintf(&resp, "VALUE %s 0 %d\r\n%s\r\n", keys[i], len(value), value)
+	}
+	resp.WriteString("END\r\n")
+	server := newServerOn(&fragmentedConn{fragments: []string{resp.String()}})
+	// Every block is read into the same buffer, so the memory does not grow with the number of blocks.
+	buffers := make(map[*byte]bool)
+	count := 0
+	err := server.retrieveEach(context.Background(), []string{"get " + strings.Join(keys, " ") + "\r\n"}, false, func(item *Item) {
+		if string(item.Value) != value {
+			t.Errorf("item %q = %q, want %q", item.Key, item.Value, value)
+		}
+		buffers[&item.Value[0]] = true
+		count++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(keys) {
+		t.Errorf("read %d items, want %d", count, len(keys))
+	}
+	if len(buffers) != 1 {
+		t.Errorf("the blocks were read into %d buffers, want 1", len(buffers))
+	}
+}
+
+func TestGetMultiMixedCompression(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"text":   {WithCompression(64)},
+		"binary": {WithCompression(64), WithBinaryProtocol()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newFakeServer(t)
+			c := newTestClient(t, []string{s.addr()}, opts...)
+			large := strings.Repeat("compressible ", 100)
+			if err := c.SetAuto("large", large, FlagRaw, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.SetAuto("small", "small", FlagRaw, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Set("plain", "plain", 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, flags, _ := s.stored("large"); flags&FlagCompressed == 0 {
+				t.Fatalf("the value was stored with the flags %#x, want it compressed", flags)
+			}
+			values, err := c.GetMulti([]string{"large", "small", "plain", "missing"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{"large": large, "small": "small", "plain": "plain"}
+			if len(values) != len(want) {
+				t.Errorf("GetMulti() returned %d values, want %d", len(values), len(want))
+			}
+			for key, value := range want {
+				if values[key] != value {
+					t.Errorf("values[%q] = %q, want %q", key, values[key], value)
+				}
+			}
+			// A value marked compressed that is not gzip is reported rather than returned as is.
+			if err = c.SetWithFlags("corrupt", "not gzip", FlagCompressed, 0); err != nil {
+				t.Fatal(err)
+			}
+			values, err = c.GetMulti([]string{"corrupt", "plain"})
+			if !errors.Is(err, ErrDecodeFailed) {
+				t.Errorf("GetMulti() error = %v, want ErrDecodeFailed", err)
+			}
+			if _, ok := values["corrupt"]; ok || values["plain"] != "plain" {
+				t.Errorf("GetMulti() = %q, want only the plain value", values)
+			}
+		})
+	}
+}
+
+func TestGetMultiOneGetPerServer(t *testing.T) {
+	servers := []*fakeServer{newFakeServer(t), newFakeServer(t), newFakeServer(t)}
+	addrs := []string{servers[0].addr(), servers[1].addr(), servers[2].addr()}
+	c := newTestClient(t, addrs)
+	var keys []string
+	want := make(map[string]string)
+	for i := range 30 {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		// Every third key is missing.
+		if i%3 == 0 {
+			continue
+		}
+		want[key] = fmt.Sprintf("value-%d", i)
+		if err := c.Set(key, want[key], 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values, err := c.GetMulti(keys)
+	if err != nil {
+		t.Fatalf("GetMulti() error = %v, want missing keys to be absent rather than errors", err)
+	}
+	if len(values) != len(want) {
+		t.Errorf("GetMulti() returned %d values, want %d", len(values), len(want))
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("values[%q] = %q, want %q", key, values[key], value)
+		}
+	}
+	sent := 0
+	for i, s := range servers {
+		var gets []string
+		for _, cmd := range s.received() {
+			if strings.HasPrefix(cmd, "get ") {
+				gets = append(gets, cmd)
+			}
+		}
+		if len(gets) != 1 {
+			t.Errorf("server %d received %d get commands, want 1", i, len(gets))
+			continue
+		}
+		// The command carries exactly the keys that map to the server.
+		for _, key := range strings.Fields(gets[0])[1:] {
+			if server, err := c.pickServer(key); err != nil || server.Address != addrs[i] {
+				t.Errorf("server %d received the key %q of another server", i, key)
+			}
+			sent++
+		}
+	}
+	if sent != len(keys) {
+		t.Errorf("sent %d keys, want %d", sent, len(keys))
+	}
+}
+
+func TestGettersDecompress(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithCompression(64))
+	value := strings.Repeat("compressible ", 100)
+	if err := c.SetAuto("key", value, FlagRaw, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, flags, _ := s.stored("key"); flags != FlagCompressed {
+		t.Fatalf("the value was stored with the flags %#x, want it compressed", flags)
+	}
+	// Every getter returns the same value for the key, and the flags of its codec.
+	got := map[string]string{}
+	got["Get"], _ = c.Get("key")
+	got["Gets"], _, _ = c.Gets("key")
+	got["GetRouted"], _ = c.GetRouted("key", "key")
+	got["GetWithFlags"], _, _ = c.GetWithFlags("key")
+	if item, found, err := c.GetFull("key"); err == nil && found {
+		got["GetFull"] = string(item.Value)
+		if item.Flags != FlagRaw {
+			t.Errorf("GetFull() flags = %#x, want %#x", item.Flags, FlagRaw)
+		}
+	}
+	values, _ := c.GetMulti([]string{"key"})
+	got["GetMulti"] = values["key"]
+	items, _ := c.GetsMulti([]string{"key"})
+	if item := items["key"]; item != nil {
+		got["GetsMulti"] = string(item.Value)
+	}
+	touched, _ := c.GetAndTouchMulti([]string{"key"}, 0)
+	got["GetAndTouchMulti"] = touched["key"]
+	metas, _ := c.GetMultiWithMeta([]string{"key"})
+	got["GetMultiWithMeta"] = metas["key"].Value
+	p := c.Pipeline()
+	p.Get("key")
+	if results, err := p.Exec(); err == nil {
+		got["Pipeline.Get"] = results[0].Value
+	}
+	for getter, v := range got {
+		if v != value {
+			t.Errorf("%s() = %d bytes, want the %d bytes of the value", getter, len(v), len(value))
+		}
+	}
+	if _, flags, err := c.GetWithFlags("key"); err != nil || flags != FlagRaw {
+		t.Errorf("GetWithFlags() flags = %#x, %v; want %#x", flags, err, FlagRaw)
+	}
+
+	// A value marked compressed that is not gzip fails every getter alike.
+	if err := c.SetWithFlags("corrupt", "not gzip", FlagCompressed, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("corrupt"); !errors.Is(err, ErrDecodeFailed) {
+		t.Errorf("Get() error = %v, want ErrDecodeFailed", err)
+	}
+	if _, err := c.GetMulti([]string{"corrupt"}); !errors.Is(err, ErrDecodeFailed) {
+		t.Errorf("GetMulti() error = %v, want ErrDecodeFailed", err)
+	}
+	if _, errs := c.GetsMulti([]string{"corrupt"}); !errors.Is(errs["corrupt"], ErrDecodeFailed) {
+		t.Errorf("GetsMulti() error = %v, want ErrDecodeFailed", errs["corrupt"])
+	}
+}
+
+func TestSetMultiPerItemExpiration(t *testing.T) {
+	fakes := map[string]*fakeServer{}
+	var addrs []string
+	for range 2 {
+		s := newFakeServer(t)
+		fakes[s.addr()] = s
+		addrs = append(addrs, s.addr())
+	}
+	c := newTestClient(t, addrs)
+	items := make([]*Item, 8)
+	for i := range items {
+		items[i] = &Item{Key: fmt.Sprintf("key-%d", i), Value: []byte("value"), Flags: uint32(i), Expiration: 100 + i}
+	}
+	if errs := c.SetMulti(items); len(errs) != 0 {
+		t.Fatalf("SetMulti() = %v", errs)
+	}
+	down := ""
+	for _, item := range items {
+		server, err := c.pickServer(item.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each command carries the flags and expiration of its own item.
+		want := fmt.Sprintf("set %s %d %d 5", item.Key, item.Flags, item.Expiration)
+		if !slices.Contains(fakes[server.Address].received(), want) {
+			t.Errorf("%s did not receive %q", server.Address, want)
+		}
+		down = server.Address
+	}
+
+	// Memcached has no cross-key atomicity: the items of the other server are still stored.
+	fakes[down].close()
+	errs := c.SetMulti(append(items, &Item{Key: "bad key", Value: []byte("value")}))
+	if !errors.Is(errs["bad key"], ErrInvalidKey) {
+		t.Errorf("SetMulti() error = %v for an invalid key, want ErrInvalidKey", errs["bad key"])
+	}
+	for _, item := range items {
+		server, _ := c.pickServer(item.Key)
+		if err, failed := errs[item.Key]; failed != (server.Address == down) || (failed && !isNetworkError(err)) {
+			t.Errorf("SetMulti() error = %v for %s on %s, want a network error only on the closed server %s", err, item.Key, server.Address, down)
+		}
+	}
+}
+
+func TestGetAndTouchMulti(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	for _, key := range []string{"a", "b"} {
+		if err := c.Set(key, "value-"+key, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values, errs := c.GetAndTouchMulti([]string{"a", "b", "missing"}, 100)
+	if len(errs) != 0 || len(values) != 2 || values["a"] != "value-a" || values["b"] != "value-b" {
+		t.Errorf("GetAndTouchMulti() = %v, %v; want both stored values and no missing key", values, errs)
+	}
+	if cmds := s.received(); !slices.Contains(cmds, "gat 100 a b missing") {
+		t.Errorf("GetAndTouchMulti() sent %q, want a single multi-key gat", cmds)
+	}
+
+	// Without "gat", every key is touched and then read, and keys that cannot be touched are skipped.
+	var mu sync.Mutex
+	var sent []string
+	addr := newScriptedServer(t, func(line string) string {
+		mu.Lock()
+		sent = append(sent, line)
+		mu.Unlock()
+		switch line {
+		case "touch a 100":
+			return "TOUCHED\r\n"
+		case "get a":
+			return "VALUE a 0 7\r\nvalue-a\r\nEND\r\n"
+		case "touch missing 100":
+			return "NOT_FOUND\r\n"
+		}
+		return "ERROR\r\n"
+	})
+	c = newTestClient(t, []string{addr})
+	values, errs = c.GetAndTouchMulti([]string{"a", "missing"}, 100)
+	if len(errs) != 0 || len(values) != 1 || values["a"] != "value-a" {
+		t.Errorf("GetAndTouchMulti() = %v, %v without gat; want only the touched key", values, errs)
+	}
+	mu.Lock()
+	if slices.Contains(sent, "get missing") {
+		t.Errorf("GetAndTouchMulti() read a key it could not touch: %q", sent)
+	}
+	mu.Unlock()
+
+	// A failed server reports every key routed to it.
+	c = newTestClient(t, []string{newFakeServer(t).addr()})
+	c.servers[0].Close()
+	_, errs = c.GetAndTouchMulti([]string{"a", "b"}, 100)
+	if len(errs) != 2 || !errors.Is(errs["a"], ErrClosed) {
+		t.Errorf("GetAndTouchMulti() errors = %v for a closed server, want ErrClosed for both keys", errs)
+	}
+}
+
+func TestBatchUpdate(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	other := newTestClient(t, []string{s.addr()})
+	for _, key := range []string{"conflict-once", "rejected", "always-conflict"} {
+		if err := c.Set(key, "1", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errUpdate := errors.New("refused by update")
+	attempts := map[string]int{}
+	results := c.BatchUpdate([]string{"conflict-once", "missing", "rejected", "always-conflict"}, 1, func(item *Item) error {
+		attempts[item.Key]++
+		switch {
+		case item.Key == "rejected":
+			return errUpdate
+		case item.Key == "always-conflict", item.Key == "conflict-once" && attempts[item.Key] == 1:
+			// Another client writes the key between the read and the CAS.
+			if err := other.Set(item.Key, "5", 0); err != nil {
+				return err
+			}
+		}
+		item.Value = append(item.Value, '0')
+		return nil
+	})
+	want := map[string]error{"conflict-once": nil, "missing": ErrNotFound, "rejected": errUpdate, "always-conflict": ErrExists}
+	for key, err := range want {
+		if got, ok := results[key]; !ok || !errors.Is(got, err) || (got == nil) != (err == nil) {
+			t.Errorf("BatchUpdate()[%q] = %v, want %v", key, got, err)
+		}
+	}
+	// The conflicting key was read again, so the update applies to the value of the other client.
+	for key, value := range map[string]string{"conflict-once": "50", "rejected": "1", "always-conflict": "5"} {
+		if got, _, _ := s.stored(key); got != value {
+			t.Errorf("%s = %q after BatchUpdate, want %q", key, got, value)
+		}
+	}
+	if attempts["always-conflict"] != 2 {
+		t.Errorf("always-conflict was updated %d times with 1 retry, want 2", attempts["always-conflict"])
+	}
+}
+
+func TestGetMultiWithMeta(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	if errs := c.SetMulti([]*Item{
+		{Key: "expiring", Value: []byte("a"), Flags: 5, Expiration: 60},
+		{Key: "forever", Value: []byte("b")},
+	}); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	items, err := c.GetMultiWithMeta([]string{"expiring", "forever", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := items["expiring"]; item.Value != "a" || item.Flags != 5 || item.TTL <= 0 || item.TTL > time.Minute {
+		t.Errorf("expiring = %+v, want its value, flags and up to a minute to live", item)
+	}
+	if item := items["forever"]; item.Value != "b" || item.TTL != TTLNever {
+		t.Errorf("forever = %+v, want TTLNever", item)
+	}
+	if _, ok := items["missing"]; ok || len(items) != 2 {
+		t.Errorf("GetMultiWithMeta() = %v, want the missing key absent", items)
+	}
+
+	// Servers without meta commands answer every request of the batch with ERROR, and are queried with "get" instead.
+	old := newScriptedServer(t, func(line string) string {
+		switch {
+		case line == "mn" || strings.HasPrefix(line, "mg "):
+			return "ERROR\r\n"
+		case strings.HasPrefix(line, "get "):
+			return "VALUE expiring 5 1\r\na\r\nEND\r\n"
+		}
+		return "ERROR\r\n"
+	})
+	c = newTestClient(t, []string{old})
+	items, err = c.GetMultiWithMeta([]string{"expiring", "missing"})
+	if item := items["expiring"]; err != nil || len(items) != 1 || item.Value != "a" || item.Flags != 5 || item.TTL != TTLUnknown {
+		t.Errorf("GetMultiWithMeta() = %v, %v from a server without meta commands, want the value with TTLUnknown", items, err)
+	}
+	// Every ERROR was read, so the connection is still in sync.
+	if value, err := c.Get("expiring"); err != nil || value != "a" {
+		t.Errorf("Get() = %q, %v after the fallback", value, err)
+	}
+
+	malformed := newScriptedServer(t, func(line string) string {
+		if strings.HasPrefix(line, "mg ") {
+			return "VA 1 fnan t10 kexpiring\r\na\r\n"
+		}
+		return "MN\r\n"
+	})
+	c = newTestClient(t, []string{malformed})
+	if _, err := c.GetMultiWithMeta([]string{"expiring"}); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("GetMultiWithMeta() error = %v for malformed flags, want ErrUnexpectedResponse", err)
+	}
+}
+
+func TestBatchFailFast(t *testing.T) {
+	// The failing server reads the request, and closes the connection once the other server received its own.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Read(make([]byte, 512))
+				time.Sleep(100 * time.Millisecond)
+			}()
+		}
+	}()
+	down := ln.Addr().String()
+	hung := newScriptedServer(t, func(line string) string { return "" })
+	c := newTestClient(t, []string{down, hung}, WithBatchFailFast(), WithSingleConnection(), WithCircuitBreaker(1, time.Minute))
+	keys := make(map[string]string)
+	for i := 0; len(keys) < 2; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		server, err := c.pickServer(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[server.Address] = key
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.GetMulti([]string{keys[down], keys[hung]})
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("GetMulti() kept waiting for the hung server after the other one failed")
+	}
+	if !errors.Is(err, ErrReadFailed) || !errors.Is(err, ErrBatchAborted) {
+		t.Errorf("GetMulti() error = %v, want the failure and ErrBatchAborted", err)
+	}
+	// Only the failure counts against its server, and the aborted connection was closed.
+	for _, server := range c.servers {
+		if want := map[string]BreakerState{down: BreakerOpen, hung: BreakerClosed}[server.Address]; server.State() != want {
+			t.Errorf("%s breaker = %v, want %v", server.Address, server.State(), want)
+		}
+		if server.Address == hung {
+			conn := <-server.pool.conns
+			if conn != nil && conn.conn != nil {
+				t.Error("the connection of the aborted request is still open")
+			}
+			server.pool.conns <- conn
+		}
+	}
+
+	// SetMulti aborts the same way. The open breaker would route the key of the failed server elsewhere, so it uses a client without one.
+	c = newTestClient(t, []string{down, hung}, WithBatchFailFast())
+	errs := make(chan map[string]error, 1)
+	go func() {
+		errs <- c.SetMulti([]*Item{{Key: keys[down], Value: []byte("a")}, {Key: keys[hung], Value: []byte("b")}})
+	}()
+	select {
+	case got := <-errs:
+		if !errors.Is(got[keys[down]], ErrReadFailed) || !errors.Is(got[keys[hung]], ErrBatchAborted) {
+			t.Errorf("SetMulti() = %v, want the failure and ErrBatchAborted", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SetMulti() kept waiting for the hung server after the other one failed")
+	}
+}
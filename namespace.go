@@ -0,0 +1,150 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// namespace groups keys under a version token stored in memcached itself,
+// so that all clients sharing the namespace can invalidate the whole group at once.
+type namespace struct {
+	name     string
+	localTTL time.Duration // How long the version token is cached locally before it is fetched again.
+
+	mu        sync.Mutex
+	version   string    // The locally cached version token.
+	fetchedAt time.Time // When the version token was last fetched.
+}
+
+// versionKey returns the key the version token of the namespace is stored under.
+func (ns *namespace) versionKey() string {
+	return ns.name + ":version"
+}
+
+// namespacedKey returns the key actually stored in memcached for the given key.
+// With a namespace, it is "<namespace>:<version>:<key>"; otherwise the key is returned unchanged.
+func (c *Client) namespacedKey(key string) (fullKey string, err error) {
+	if c.namespace == nil {
+		fullKey = key
+		return
+	}
+	version, err := c.namespaceVersion()
+	if err != nil {
+		return
+	}
+	fullKey = c.namespace.name + ":" + version + ":" + key
+	return
+}
+
+// cachedNamespacedKey returns the key namespacedKey would return, without a round trip to fetch the version token.
+// The locally cached token is used even if it expired; before the first fetch, the current Unix time stands in for the token,
+// which is how the initial token is created.
+func (c *Client) cachedNamespacedKey(key string) string {
+	if c.namespace == nil {
+		return key
+	}
+	ns := c.namespace
+	ns.mu.Lock()
+	version := ns.version
+	ns.mu.Unlock()
+	if version == "" {
+		version = fmt.Sprint(time.Now().Unix())
+	}
+	return ns.name + ":" + version + ":" + key
+}
+
+// namespaceVersion returns the current version token of the namespace.
+// The token is cached locally for the configured TTL; once expired it is fetched again, and created if missing.
+func (c *Client) namespaceVersion() (version string, err error) {
+	ns := c.namespace
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if ns.version != "" && time.Since(ns.fetchedAt) < ns.localTTL {
+		version = ns.version
+		return
+	}
+
+	key := ns.versionKey()
+	server, err := c.pickServer(key)
+	if err != nil {
+		return
+	}
+	version, _, err = server.GetValue(key, false)
+	if errors.Is(err, ErrNotFound) {
+		version, err = initNamespaceVersion(server, key)
+	}
+	if err != nil {
+		return
+	}
+	ns.version = version
+	ns.fetchedAt = time.Now()
+	return
+}
+
+// initNamespaceVersion creates the version token if it does not exist yet and returns the stored token.
+// The initial token is the current Unix time rather than a fixed value,
+// so that a token evicted from the cache cannot bring back keys of an earlier generation.
+func initNamespaceVersion(server *Server, key string) (version string, err error) {
+	initial := fmt.Sprint(time.Now().Unix())
+	// add <key> <flags> <exptime> <bytes>\r\n<data>\r\n
+	command := storageCommand("add", &Item{Key: key, Value: []byte(initial)})
+	resp, err := server.WriteCommand(command)
+	if err != nil {
+		return
+	}
+	switch resp {
+	case "STORED":
+		version = initial
+	case "NOT_STORED":
+		// Another client created the token concurrently.
+		version, _, err = server.GetValue(key, false)
+	default:
+		err = ErrStoreFailed
+	}
+	return
+}
+
+// InvalidateNamespace invalidates every key of the client's namespace by incrementing the shared version token.
+// Other clients using the same namespace observe the invalidation once their locally cached token expires.
+// It returns ErrNoNamespace if the client is not configured with WithNamespace.
+func (c *Client) InvalidateNamespace() (err error) {
+	defer c.hook("InvalidateNamespace", "", &err)()
+	ns := c.namespace
+	if ns == nil {
+		err = ErrNoNamespace
+		return
+	}
+	key := ns.versionKey()
+	server, err := c.pickServer(key)
+	if err != nil {
+		return
+	}
+	// incr <key> <delta>\r\n
+	resp, err := server.WriteCommand(fmt.Sprintf("incr %s 1\r\n", key))
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	version := resp
+	if resp == "NOT_FOUND" {
+		version, err = initNamespaceVersion(server, key)
+		if err != nil {
+			return
+		}
+	} else if _, perr := strconv.ParseUint(resp, 10, 64); perr != nil {
+		err = ErrUnexpectedResponse
+		return
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.version = version
+	ns.fetchedAt = time.Now()
+	// Entries of the local fallback cache belong to the previous version.
+	if c.fallback != nil {
+		c.fallback.purge()
+	}
+	return
+}
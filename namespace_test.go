@@ -0,0 +1,63 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNamespaceSharedVersion(t *testing.T) {
+	s := newFakeServer(t)
+	for _, opt := range []Option{WithNamespace("bad name", time.Second), WithNamespace("app", 0)} {
+		if _, err := NewClientWithOptions(WithServers(s.addr()), opt); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("NewClientWithOptions() error = %v, want ErrInvalidOption", err)
+		}
+	}
+	if err := newTestClient(t, []string{s.addr()}).InvalidateNamespace(); !errors.Is(err, ErrNoNamespace) {
+		t.Errorf("InvalidateNamespace() error = %v without a namespace, want ErrNoNamespace", err)
+	}
+
+	const localTTL = 100 * time.Millisecond
+	a := newTestClient(t, []string{s.addr()}, WithNamespace("app", localTTL))
+	b := newTestClient(t, []string{s.addr()}, WithNamespace("app", localTTL))
+	if err := a.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	// Both clients read the same version token from the cache.
+	if value, err := b.Get("key"); err != nil || value != "value" {
+		t.Fatalf("Get() = %q, %v from another client of the namespace", value, err)
+	}
+	if err := a.InvalidateNamespace(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v right after InvalidateNamespace, want ErrNotFound", err)
+	}
+	// The other client keeps its locally cached token until it expires.
+	if value, err := b.Get("key"); err != nil || value != "value" {
+		t.Errorf("Get() = %q, %v within the local TTL of the other client", value, err)
+	}
+	time.Sleep(localTTL)
+	if _, err := b.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v once the local token expired, want ErrNotFound", err)
+	}
+
+	plain := newTestClient(t, []string{s.addr()})
+	// A token that is not a counter cannot be incremented.
+	if err := plain.Set("app:version", "bogus", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.InvalidateNamespace(); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("InvalidateNamespace() error = %v for a non-numeric token, want ErrUnexpectedResponse", err)
+	}
+	// An evicted token is created again.
+	if err := plain.Delete("app:version"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.InvalidateNamespace(); err != nil {
+		t.Errorf("InvalidateNamespace() error = %v for a missing token", err)
+	}
+	if _, _, ok := s.stored("app:version"); !ok {
+		t.Error("InvalidateNamespace() did not recreate the missing token")
+	}
+}
@@ -0,0 +1,693 @@
+package memcache
+
+import (
+	"crypto/tls"
+	"hash/crc32"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Option configures a Client created by NewClientWithOptions.
+// It returns an error if the supplied configuration is invalid.
+type Option func(c *Client) error
+
+// WithServers adds the memcached server addresses the client connects to.
+func WithServers(addresses ...string) Option {
+	return func(c *Client) error {
+		c.addresses = append(c.addresses, addresses...)
+		return nil
+	}
+}
+
+// WithLatencyAwareReads enables routing reads away from slow servers.
+// When the moving average latency of the server a key maps to exceeds threshold,
+// Get is sent to the next server on the ring if it is faster. Writes, and Gets whose CAS token belongs to the primary, always go to the primary server.
+// A replica only holds the value if it has also been written there, so this is strictly opt-in.
+func WithLatencyAwareReads(threshold time.Duration) Option {
+	return func(c *Client) error {
+		if threshold <= 0 {
+			return ErrInvalidOption
+		}
+		c.latencyThreshold = threshold
+		return nil
+	}
+}
+
+// WithPipelineDepth limits the number of commands a Pipeline queues before it flushes automatically.
+// An automatic flush reads the responses of the flushed batch before more commands are queued,
+// which bounds the memory used by the request buffer and the pending responses.
+func WithPipelineDepth(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.pipelineDepth = n
+		return nil
+	}
+}
+
+// WithWeightedRing selects servers with a ketama-style consistent-hash ring instead of modulo hashing.
+// Adding or removing a server then only remaps the keys near its points on the ring,
+// and each server receives a share of keys proportional to its weight (see Client.SetWeight).
+func WithWeightedRing() Option {
+	return func(c *Client) error {
+		c.weightedRing = true
+		return nil
+	}
+}
+
+// WithVirtualNodes sets the number of points, or virtual nodes, a server of weight 1 gets on the consistent-hash ring,
+// and enables the ring like WithWeightedRing. More points spread keys more evenly across servers, so that adding or removing
+// one of N servers remaps closer to 1/N of the keys, at the cost of memory and of a longer rebuild when servers change.
+// n must be positive; the default is 160, as in ketama. Clients sharing a cache should use the same value,
+// otherwise they map keys to different servers.
+func WithVirtualNodes(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.virtualNodes = n
+		c.weightedRing = true
+		return nil
+	}
+}
+
+// WithSingleConnection guarantees that all commands to a server are strictly serialized on one connection,
+// even if connection pooling is configured. Order-dependent workloads such as sequences of Append calls rely on this.
+// The tradeoff is throughput: concurrent callers targeting the same server wait for each other.
+// It takes precedence over WithPool.
+func WithSingleConnection() Option {
+	return func(c *Client) error {
+		c.singleConnection = true
+		return nil
+	}
+}
+
+// WithPool keeps up to size connections per server so that concurrent commands to the same server run in parallel
+// instead of waiting for each other. Connections beyond the first are opened lazily when concurrent commands need them.
+// Connections are not tied to keys: every command takes whichever connection is free, so concurrent reads of a single
+// hot key with Get or GetMulti also spread over the whole pool.
+// The size caps the number of open connections to a server, like WithMaxOpenConns; callers beyond it wait for a free connection.
+// See WithMaxIdleConns to close connections left idle after a burst.
+// The size must be positive; the default is a single connection per server.
+func WithPool(size int) Option {
+	return func(c *Client) error {
+		if size <= 0 {
+			return ErrInvalidOption
+		}
+		c.poolSize = size
+		return nil
+	}
+}
+
+// WithMaxIdleConns limits the number of open connections a server keeps while they are idle: a connection given back to
+// the pool beyond n idle ones is closed, and dialed again when concurrent commands need it. This frees the connections
+// opened by a burst of concurrency with WithPool, at the cost of a dial when the next burst comes.
+// n must be positive; by default, every connection opened stays open.
+func WithMaxIdleConns(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.maxIdleConns = n
+		return nil
+	}
+}
+
+// WithMaxOpenConns caps the number of connections open to each server at n, like the size of WithPool, under the name
+// database/sql uses: commands beyond the cap wait for a connection to be given back, see WithAcquireTimeout.
+// Without WithPool, the pool holds n connections; with it, the smaller of both applies. WithSingleConnection takes precedence.
+// n must be positive.
+func WithMaxOpenConns(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.maxOpenConns = n
+		return nil
+	}
+}
+
+// WithAcquireTimeout bounds the time a command waits for a free connection when all connections to its server are in use
+// (see WithPool and WithMaxOpenConns); past it, the command fails with ErrPoolTimeout without being sent.
+// The timeout must be positive; by default, commands wait until a connection is free.
+func WithAcquireTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return ErrInvalidOption
+		}
+		c.acquireTimeout = timeout
+		return nil
+	}
+}
+
+// WithTimeout bounds the time spent dialing a server and every network read and write of a command,
+// so that an unresponsive server fails with an error wrapping os.ErrDeadlineExceeded instead of blocking.
+// The timeout bounds each write of the data block of SetStream rather than the whole copy, so a slow reader does not cut it off.
+// WithDialTimeout, WithReadTimeout and WithWriteTimeout override it for dialing, reads and writes respectively.
+// The timeout must be positive; by default there is none.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return ErrInvalidOption
+		}
+		c.connConfig.timeout = timeout
+		return nil
+	}
+}
+
+// WithDialTimeout bounds the time spent dialing a server, including the TLS handshake of WithTLS,
+// so that an unreachable server fails within it instead of the timeout of the operating system.
+// It takes precedence over WithTimeout for dialing. The timeout must be positive.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return ErrInvalidOption
+		}
+		c.connConfig.dialTimeout = timeout
+		return nil
+	}
+}
+
+// WithReadTimeout bounds every network read of a command, so that a server that accepts a command but never answers it
+// fails with an error wrapping os.ErrDeadlineExceeded instead of blocking. The timeout restarts with every read,
+// so a large response arriving steadily is not cut off. It takes precedence over WithTimeout for reads. The timeout must be positive.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return ErrInvalidOption
+		}
+		c.connConfig.readTimeout = timeout
+		return nil
+	}
+}
+
+// WithWriteTimeout bounds every network write of a command, so that a server that stops reading, e.g. because it is overloaded,
+// fails with an error wrapping os.ErrDeadlineExceeded instead of blocking once the socket buffers are full.
+// The timeout restarts with every write, so the data block of SetStream copied from a slow reader is bounded per write,
+// not as a whole. It takes precedence over WithTimeout for writes.
+// The timeout must be positive.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return ErrInvalidOption
+		}
+		c.connConfig.writeTimeout = timeout
+		return nil
+	}
+}
+
+// WithHasher sets the function hashing keys to select their server, e.g. to place keys like the clients of another library.
+// It takes precedence over WithCRC32Table. Changing it moves most keys to different servers.
+func WithHasher(hasher func(key string) uint32) Option {
+	return func(c *Client) error {
+		if hasher == nil {
+			return ErrInvalidOption
+		}
+		c.hasher = hasher
+		return nil
+	}
+}
+
+// WithCircuitBreaker enables a circuit breaker per server.
+// After threshold consecutive network failures the server is considered down for cooldown:
+// commands to it fail fast with ErrServerUnavailable and reads with retries skip it.
+// Once the cooldown has elapsed, the next command probes the server and closes the breaker on success.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) error {
+		if threshold <= 0 || cooldown <= 0 {
+			return ErrInvalidOption
+		}
+		c.breakerThreshold = threshold
+		c.breakerCooldown = cooldown
+		return nil
+	}
+}
+
+// WithBreakerListener sets a function called on every transition of the circuit breaker of a server (see WithCircuitBreaker),
+// e.g. to update service discovery or raise an alert when a server trips open or recovers.
+// It is called from a dedicated goroutine, in the order of the transitions, so that a slow listener never blocks commands;
+// the goroutine is stopped by Client.Close. It has no effect without WithCircuitBreaker.
+func WithBreakerListener(listener func(addr string, from, to BreakerState)) Option {
+	return func(c *Client) error {
+		if listener == nil {
+			return ErrInvalidOption
+		}
+		c.breakerEvents = newBreakerEvents(listener)
+		return nil
+	}
+}
+
+// WithMinHealthyServers makes write operations fail fast with ErrInsufficientServers while fewer than n servers are available,
+// that is pass their health check and have a circuit breaker that allows commands, to avoid writing to a degraded cluster.
+// Reads still proceed. It is meant to be combined with WithCircuitBreaker or WithHealthCheck; n must be positive
+// and at most the number of servers.
+func WithMinHealthyServers(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.minHealthyServers = n
+		return nil
+	}
+}
+
+// WithReadRetries retries a failed read on up to n following servers on the ring.
+// Only network failures are retried, and servers whose circuit breaker is open are skipped.
+// Writes are never re-routed to avoid diverging copies, so a replica only has the value if it was written there as well.
+func WithReadRetries(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.readRetries = n
+		return nil
+	}
+}
+
+// WithSlidingExpiration makes every successful Get refresh the expiration time of the key to ttl seconds,
+// using a "gat" (get and touch) command in the same round trip, which suits session-like caches.
+// On servers without "gat" support it falls back to a "touch" followed by a "get". Misses do not create keys.
+// The ttl must be positive.
+func WithSlidingExpiration(ttl int) Option {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return ErrInvalidOption
+		}
+		c.slidingExpiration = ttl
+		return nil
+	}
+}
+
+// WithCodec sets the codec SetObject and GetObject use to encode and decode values, e.g. one wrapping msgpack or protobuf.
+// By default, clients use JSONCodec.
+func WithCodec(codec Codec) Option {
+	return func(c *Client) error {
+		if codec == nil {
+			return ErrInvalidOption
+		}
+		c.codec = codec
+		return nil
+	}
+}
+
+// WithCodecRegistry sets the registry SetAuto and GetAuto use to encode and decode values by their flags.
+// By default, clients use DefaultCodecRegistry.
+func WithCodecRegistry(r *CodecRegistry) Option {
+	return func(c *Client) error {
+		if r == nil {
+			return ErrInvalidOption
+		}
+		c.codecs = r
+		return nil
+	}
+}
+
+// WithNamespace prefixes every key with the namespace and a version token, as "<name>:<version>:<key>".
+// The version token is stored in memcached, so Client.InvalidateNamespace invalidates the namespace for every client sharing it.
+// To avoid an extra get per operation, the token is cached locally for localTTL; other clients may keep
+// using the previous version for up to that long after an invalidation.
+func WithNamespace(name string, localTTL time.Duration) Option {
+	return func(c *Client) error {
+		if validateKey(name) != nil || localTTL <= 0 {
+			return ErrInvalidOption
+		}
+		c.namespace = &namespace{
+			name:     name,
+			localTTL: localTTL,
+		}
+		return nil
+	}
+}
+
+// WithReconnectBackoff spaces out reconnect attempts to a failing server with exponential backoff.
+// After a failed attempt, the next one waits up to base, doubling with every consecutive failure up to maxDelay, with jitter.
+// While waiting, operations on that connection fail fast with the error of the last attempt.
+func WithReconnectBackoff(base, maxDelay time.Duration) Option {
+	return func(c *Client) error {
+		if base <= 0 || maxDelay < base {
+			return ErrInvalidOption
+		}
+		c.connConfig.backoffBase = base
+		c.connConfig.backoffMax = maxDelay
+		return nil
+	}
+}
+
+// WithProxyMode adjusts the client for servers that are reached through a proxy, either ProxyMcrouter or ProxyTwemproxy.
+// Commands the proxy cannot handle fail with ErrUnsupportedByProxy without being sent, and stats parsing tolerates the proxy's own lines.
+//
+// Behind mcrouter, stats reports the statistics of mcrouter itself, flush_all only works if flushing is enabled in mcrouter,
+// and verbosity, watch and lru_crawler are unsupported.
+// Behind twemproxy, only storage, retrieval, delete, incr/decr and touch commands are supported:
+// stats, version (and so PingLatency), flush_all, verbosity, watch, lru_crawler, gat/gats and meta commands are not.
+func WithProxyMode(proxy string) Option {
+	return func(c *Client) error {
+		if _, ok := unsupportedByProxy[proxy]; !ok {
+			return ErrInvalidOption
+		}
+		c.proxy = proxy
+		return nil
+	}
+}
+
+// WithBinaryProtocol makes the client speak the binary protocol instead of the default text protocol.
+// Get, Gets, GetMulti, Set, SetAuto, Add, Replace, CAS, Delete and health checks are supported;
+// other commands fail with ErrUnsupportedByProtocol without being sent.
+func WithBinaryProtocol() Option {
+	return func(c *Client) error {
+		c.binary = true
+		return nil
+	}
+}
+
+// WithSocketBufferSizes sets the sizes of the socket receive and send buffers of every connection in bytes.
+// Larger buffers reduce round trips for large values. Both sizes must be positive; they only apply to TCP connections.
+func WithSocketBufferSizes(read, write int) Option {
+	return func(c *Client) error {
+		if read <= 0 || write <= 0 {
+			return ErrInvalidOption
+		}
+		c.connConfig.readBuffer = read
+		c.connConfig.writeBuffer = write
+		return nil
+	}
+}
+
+// WithCRC32Table sets the CRC32 table used to hash keys for server selection, e.g. crc32.MakeTable(crc32.Castagnoli),
+// to place keys on the same servers as other clients of an existing fleet. The default is the IEEE polynomial.
+// Changing it moves most keys to different servers.
+func WithCRC32Table(table *crc32.Table) Option {
+	return func(c *Client) error {
+		if table == nil {
+			return ErrInvalidOption
+		}
+		c.crcTable = table
+		return nil
+	}
+}
+
+// WithHealthCheck starts a background goroutine that pings every server at the given interval.
+// A server whose ping fails is treated as unavailable until a later ping succeeds, so commands to it fail fast
+// with ErrServerUnavailable and reads with retries skip it, instead of discovering the failure on the request path.
+// Pings also feed the circuit breaker when it is enabled. Servers are pinged concurrently, and servers behind
+// a proxy that does not forward the ping, such as twemproxy with WithProxyMode, are not probed.
+// The goroutine is stopped by Client.Close.
+func WithHealthCheck(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return ErrInvalidOption
+		}
+		c.healthInterval = interval
+		return nil
+	}
+}
+
+// WithAcceptingConnsCheck makes the background health check of WithHealthCheck also read the "accepting_conns" stat
+// of every server that answers its ping, and treat a server that refuses new connections as unavailable,
+// so that commands stop being routed to an overloaded server. It has no effect without WithHealthCheck.
+func WithAcceptingConnsCheck() Option {
+	return func(c *Client) error {
+		c.checkAcceptingConns = true
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report events such as health transitions of servers.
+// By default nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) error {
+		if logger == nil {
+			return ErrInvalidOption
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithHook adds a hook called around client operations, e.g. to create a tracing span per operation.
+// The hook receives the name of the Client method, the key, and the address of the server, and its done function receives
+// the final error. Several hooks can be added; they are called in order, and their done functions in reverse order.
+// Operations returning per-key errors, such as SetMulti, GetAndTouchMulti, and pipelines are not hooked.
+func WithHook(h Hook) Option {
+	return func(c *Client) error {
+		if h == nil {
+			return ErrInvalidOption
+		}
+		c.hooks = append(c.hooks, h)
+		return nil
+	}
+}
+
+// WithCompression makes SetAuto gzip-compress encoded values of at least threshold bytes and mark them with FlagCompressed.
+// A value is only stored compressed if that makes it smaller; incompressible values are stored as is.
+// Every getter, from Get and GetMulti to GetAuto, decompresses marked values regardless of this option,
+// except GetStream, which copies values as stored. The threshold must be positive.
+func WithCompression(threshold int) Option {
+	return func(c *Client) error {
+		if threshold <= 0 {
+			return ErrInvalidOption
+		}
+		c.compressionThreshold = threshold
+		return nil
+	}
+}
+
+// WithChunking makes Set split values longer than chunkSize bytes across several keys, so that values larger than
+// the item size limit of the server can be cached. The chunks are stored under "<key>:1", "<key>:2", and so on,
+// and a manifest with their count, total length and checksum is stored under the key itself; Get reassembles them.
+//
+// Chunked values are not atomic: a concurrent Set of the same key can leave chunks of two writes behind,
+// which Get detects and reports as ErrCorruptChunks, and an evicted chunk makes the whole value a miss.
+// Delete and WithSlidingExpiration only apply to the manifest; orphaned chunks are left to expire.
+// The chunk size must be positive.
+func WithChunking(chunkSize int) Option {
+	return func(c *Client) error {
+		if chunkSize <= 0 {
+			return ErrInvalidOption
+		}
+		c.chunkSize = chunkSize
+		return nil
+	}
+}
+
+// WithAutoChunking makes Set split values that exceed the item size limit of their server like WithChunking,
+// reading the limit from the item_size_max setting of each server instead of using a fixed chunk size.
+// The setting is cached per server and read again after reconnecting, so raising the limit with -I takes effect
+// without reconfiguring the client. Servers whose settings cannot be read are assumed to allow 1 MB items.
+// WithChunking takes precedence over it.
+func WithAutoChunking() Option {
+	return func(c *Client) error {
+		c.autoChunking = true
+		return nil
+	}
+}
+
+// WithValidateOnBorrow makes the client check a pooled connection that was idle for longer than idleThreshold
+// with a "version" round trip (a Noop request with the binary protocol) before using it for a command.
+// A connection that fails the check is replaced by a new one, so the first command after an idle period does not
+// fail because the server or a firewall dropped the connection meanwhile. Connections used more recently are not checked.
+// The threshold must be positive.
+func WithValidateOnBorrow(idleThreshold time.Duration) Option {
+	return func(c *Client) error {
+		if idleThreshold <= 0 {
+			return ErrInvalidOption
+		}
+		c.validateIdle = idleThreshold
+		return nil
+	}
+}
+
+// WithExtraAllowlist restricts Client.Extra and Client.ExtraLine to the given command names, such as "stats" or "version".
+// Other commands are rejected with ErrCommandNotAllowed without being sent. By default every command is allowed.
+func WithExtraAllowlist(commands ...string) Option {
+	return func(c *Client) error {
+		if len(commands) == 0 {
+			return ErrInvalidOption
+		}
+		if c.extraAllowlist == nil {
+			c.extraAllowlist = make(map[string]bool, len(commands))
+		}
+		for _, command := range commands {
+			c.extraAllowlist[command] = true
+		}
+		return nil
+	}
+}
+
+// WithExtraTimeout bounds the time Extra and ExtraLine, of the client and of its servers, wait for the whole response,
+// so that a command whose response never ends the way it is read fails with a timeout instead of hanging.
+// It takes precedence over WithTimeout for these methods. The timeout must be positive; by default there is none.
+func WithExtraTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return ErrInvalidOption
+		}
+		c.extraTimeout = timeout
+		return nil
+	}
+}
+
+// WithReplication stores every value written with Set on n servers: the server its key maps to and the following
+// n-1 servers on the ring, which WithReadRetries also falls back to. Delete removes the key from all of them.
+// Other writes only reach the primary server. Replicas that are unavailable are skipped, and their copies may
+// become stale; failed writes to replicas are reported along with the result of the primary server.
+// n must be positive; 1 disables replication.
+func WithReplication(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.replicas = n
+		return nil
+	}
+}
+
+// WithReadRepair makes Get compare the value it read from the primary server of a key with the copies on the replicas
+// configured with WithReplication, and rewrite the copies that are missing or differ with the value of the primary.
+// This costs an extra read per replica on every Get, plus a meta get for the time to live and a write per repaired copy,
+// so it suits read paths where consistency matters more than latency. It has no effect without WithReplication.
+func WithReadRepair() Option {
+	return func(c *Client) error {
+		c.readRepair = true
+		return nil
+	}
+}
+
+// WithRejectDuplicateServers makes NewClientWithOptions fail with ErrDuplicateServer if an address is given more than once,
+// comparing addresses with their host lowercased. By default, repeated addresses are silently merged into one server.
+func WithRejectDuplicateServers() Option {
+	return func(c *Client) error {
+		c.rejectDuplicates = true
+		return nil
+	}
+}
+
+// WithBatchFailFast makes batch operations all-or-nothing: GetMulti, SetMulti and pipelines abort their in-flight requests
+// to the other servers as soon as one server fails with a network error, instead of collecting partial results.
+// Aborting closes the connections of the aborted requests, which are reported with ErrBatchAborted, so the call returns
+// without waiting for slow servers when a node is clearly down. Aborted requests do not count against the circuit breaker.
+func WithBatchFailFast() Option {
+	return func(c *Client) error {
+		c.batchFailFast = true
+		return nil
+	}
+}
+
+// WithVerifyOnConnect makes every newly dialed connection send a "version" command, or a binary Noop request, and check
+// that the endpoint answers like memcached before it is used. A misconfigured address, e.g. the port of an HTTP server,
+// then fails NewClientWithOptions with ErrNotMemcached instead of failing the first command with a confusing error.
+// It adds a round trip to every dial. Behind a proxy that does not forward "version", such as twemproxy with WithProxyMode,
+// connections are not verified, since the proxy would close them on the probe.
+func WithVerifyOnConnect() Option {
+	return func(c *Client) error {
+		c.verifyOnConnect = true
+		return nil
+	}
+}
+
+// WithMultiGetMaxKeys sets the maximum number of keys sent in one multi-key retrieval command by GetMulti,
+// GetsMulti, GetAndTouchMulti and the operations built on them; the keys of a server beyond it are split into
+// several commands, which are still sent in one round trip. n must be positive; the default is 100.
+func WithMultiGetMaxKeys(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.multiGetMaxKeys = n
+		return nil
+	}
+}
+
+// WithMultiGetMaxBytes sets the maximum length in bytes of one multi-key retrieval command, to match the command line
+// limit of the servers or the MTU of the network. Keys are split like WithMultiGetMaxKeys; a single key longer than the
+// limit is still sent, in a command of its own. n must be positive; the default is 8 KiB.
+func WithMultiGetMaxBytes(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.multiGetMaxBytes = n
+		return nil
+	}
+}
+
+// WithLocalAddr sets the source address of every connection to the servers, so that cache traffic leaves a multi-homed host
+// through a given interface. A zero port lets the system pick one. NewClientWithOptions returns ErrInvalidOption if the address
+// is of another IP family than a server given by its IP address.
+func WithLocalAddr(addr *net.TCPAddr) Option {
+	return func(c *Client) error {
+		if addr == nil {
+			return ErrInvalidOption
+		}
+		c.connConfig.localAddr = addr
+		return nil
+	}
+}
+
+// WithLocalFallback keeps up to size values in an in-process cache evicting the least recently used one, as a last resort
+// during a cache outage: a Get that fails because the servers of its key cannot be reached is answered from it,
+// for up to ttl after the value was read or written. The cache is filled by successful calls to Get and Set,
+// and Delete removes keys from it. Other operations neither use nor update it.
+//
+// Values served from the cache may be stale: writes of other clients, expirations, evictions and invalidations
+// of the namespace by other clients are not seen, so ttl bounds how old a served value can be.
+// By default, Set and Delete fail during an outage and are dropped; see WithLocalFallbackWriteQueue to queue them.
+// size and ttl must be positive.
+func WithLocalFallback(size int, ttl time.Duration) Option {
+	return func(c *Client) error {
+		if size <= 0 || ttl <= 0 {
+			return ErrInvalidOption
+		}
+		c.fallbackSize = size
+		c.fallbackTTL = ttl
+		return nil
+	}
+}
+
+// WithLocalFallbackWriteQueue makes Set and Delete queue their write during an outage instead of dropping it, for up to n keys,
+// and report it with ErrWriteQueued joined with the error of the write. Only the last write of a key is kept, it is applied
+// to the local fallback cache right away, and it is replayed in the background once a Get, Set or Delete reaches the servers again.
+// Queued writes are best effort: they are lost if the process exits first, writes beyond n keys are dropped,
+// and a replayed write may overwrite a newer value written meanwhile by another client.
+// n must be positive. It has no effect without WithLocalFallback.
+func WithLocalFallbackWriteQueue(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return ErrInvalidOption
+		}
+		c.fallbackQueue = n
+		return nil
+	}
+}
+
+// WithTLS makes every connection to the servers use TLS with the given configuration, as required by managed offerings
+// and by memcached 1.6 and later started with TLS enabled. Connections re-established after a failure use TLS as well.
+// Unless config sets ServerName, the server certificate is verified against the host of each server address.
+// The handshake is bounded by WithTimeout like dialing. config must not be nil, and must not be modified once passed.
+func WithTLS(config *tls.Config) Option {
+	return func(c *Client) error {
+		if config == nil {
+			return ErrInvalidOption
+		}
+		c.connConfig.tlsConfig = config
+		return nil
+	}
+}
+
+// WithSASL makes every connection authenticate with SASL PLAIN and the given credentials right after it is dialed,
+// as hosted memcached providers and memcached started with -S require. Connections re-established after a failure
+// authenticate again before any command is sent. Memcached only accepts SASL over the binary protocol,
+// so it enables WithBinaryProtocol. Rejected credentials fail NewClientWithOptions, or the command that dialed, with ErrAuthFailed.
+// PLAIN sends the password as is, so it should be combined with WithTLS outside of trusted networks. username must not be empty.
+func WithSASL(username, password string) Option {
+	return func(c *Client) error {
+		if username == "" {
+			return ErrInvalidOption
+		}
+		c.binary = true
+		c.connConfig.auth = saslPlain(username, password)
+		return nil
+	}
+}
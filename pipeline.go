@@ -0,0 +1,242 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Pipeline queues commands and sends them to the memcached servers in batches, one round trip per server.
+// Commands run when Exec is called, or automatically whenever the number of queued commands reaches
+// the depth configured with WithPipelineDepth. A Pipeline is not safe for concurrent use.
+type Pipeline struct {
+	client  *Client
+	depth   int              // Maximum number of queued commands before an automatic flush; zero means unlimited.
+	ops     []pipelineOp     // Commands queued since the last flush.
+	results []PipelineResult // Results of the commands already flushed.
+}
+
+// PipelineResult is the outcome of a single command sent through a Pipeline.
+type PipelineResult struct {
+	Key   string // The key the command operated on.
+	Value string // The retrieved value, for Get.
+	Err   error  // The error of the command, if any.
+}
+
+// pipelineOp is a command queued in a Pipeline along with the parser of its response.
+type pipelineOp struct {
+	key     string // The key as given by the caller.
+	fullKey string // The key actually stored in memcached.
+	write   bool   // Whether the command modifies data.
+	command string
+	parse   func(reader *bufio.Reader) (value string, err error)
+	err     error // An error that prevented the command from being built.
+}
+
+// Pipeline returns a new Pipeline that sends its commands through the client.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{
+		client: c,
+		depth:  c.pipelineDepth,
+	}
+}
+
+// Set queues a "set" command to store a key-value pair.
+func (p *Pipeline) Set(key, value string, expiration int) {
+	p.queue(key, true, func(key string) string {
+		// set <key> <flags> <exptime> <bytes>\r\n<data>\r\n
+		return fmt.Sprintf("set %s 0 %d %d\r\n%s\r\n", key, expiration, len(value), value)
+	}, parseStored)
+}
+
+// SetItem queues a "set" command to store the item with its own flags and expiration.
+func (p *Pipeline) SetItem(item *Item) {
+	p.queue(item.Key, true, func(key string) string {
+		stored := *item
+		stored.Key = key
+		return storageCommand("set", &stored)
+	}, parseStored)
+}
+
+// Add queues an "add" command to store a key-value pair only if the key does not already exist.
+func (p *Pipeline) Add(key, value string, expiration int) {
+	p.queue(key, true, func(key string) string {
+		// add <key> <flags> <exptime> <bytes>\r\n<data>\r\n
+		return fmt.Sprintf("add %s 0 %d %d\r\n%s\r\n", key, expiration, len(value), value)
+	}, parseStored)
+}
+
+// Replace queues a "replace" command to update the value of an existing key.
+func (p *Pipeline) Replace(key, value string, expiration int) {
+	p.queue(key, true, func(key string) string {
+		// replace <key> <flags> <exptime> <bytes>\r\n<data>\r\n
+		return fmt.Sprintf("replace %s 0 %d %d\r\n%s\r\n", key, expiration, len(value), value)
+	}, parseStored)
+}
+
+// Delete queues a "delete" command to remove the key.
+func (p *Pipeline) Delete(key string) {
+	p.queue(key, true, deleteCommand, func(reader *bufio.Reader) (value string, err error) {
+		line, err := readLine(reader)
+		if err != nil {
+			return
+		}
+		if line != "DELETED" {
+			err = deleteError(line)
+		}
+		return
+	})
+}
+
+// Touch queues a "touch" command to update the expiration time of the key, acknowledged like Client.Touch.
+func (p *Pipeline) Touch(key string, expiration int) {
+	p.queue(key, true, func(key string) string {
+		// touch <key> <exptime>\r\n
+		return fmt.Sprintf("touch %s %d\r\n", key, expiration)
+	}, parseStatus(touched, ErrStoreFailed))
+}
+
+// Get queues a "get" command to retrieve the value associated with the key, decompressed like Client.Get does.
+// A missing key is reported as ErrNotFound in its result.
+func (p *Pipeline) Get(key string) {
+	p.queue(key, false, func(key string) string {
+		// get <key>\r\n
+		return fmt.Sprintf("get %s\r\n", key)
+	}, func(reader *bufio.Reader) (value string, err error) {
+		item, err := readItem(reader, false)
+		if err != nil {
+			return
+		}
+		if err = decompressItem(item); err != nil {
+			return
+		}
+		value = string(item.Value)
+		return
+	})
+}
+
+// Exec sends all queued commands and returns the results of every command queued since the previous Exec, in order.
+// Errors of individual commands are reported in their results; err is the joined error of all failed commands.
+func (p *Pipeline) Exec() (results []PipelineResult, err error) {
+	p.flush()
+	results = p.results
+	p.results = nil
+	for _, r := range results {
+		if r.Err != nil {
+			err = errors.Join(err, r.Err)
+		}
+	}
+	return
+}
+
+// queue adds a command built for the key actually stored in memcached to the pipeline,
+// and flushes the pipeline if the configured depth is reached. Write marks commands that modify data.
+func (p *Pipeline) queue(key string, write bool, build func(key string) string, parse func(reader *bufio.Reader) (string, error)) {
+	op := pipelineOp{key: key, write: write, parse: parse}
+	op.fullKey, op.err = p.client.namespacedKey(key)
+	if op.err == nil {
+		op.command = build(op.fullKey)
+	}
+	p.ops = append(p.ops, op)
+	if p.depth > 0 && len(p.ops) >= p.depth {
+		p.flush()
+	}
+}
+
+// flush sends the queued commands grouped by server and reads all their responses before returning.
+func (p *Pipeline) flush() {
+	if len(p.ops) == 0 {
+		return
+	}
+	ops := p.ops
+	p.ops = nil
+	results := make([]PipelineResult, len(ops))
+
+	// Writes are rejected as a whole while too few servers are available.
+	quorumErr := p.client.checkQuorum()
+	// Group the commands by server while keeping their order.
+	groups := make(map[*Server][]int)
+	for i, op := range ops {
+		results[i].Key = op.key
+		if op.err != nil {
+			results[i].Err = op.err
+			continue
+		}
+		if op.write && quorumErr != nil {
+			results[i].Err = quorumErr
+			continue
+		}
+		server, err := p.client.pickServer(op.fullKey)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		groups[server] = append(groups[server], i)
+	}
+
+	ctx, abort := p.client.batchContext()
+	defer abort()
+	var wg sync.WaitGroup
+	for server, indices := range groups {
+		wg.Add(1)
+		go func(server *Server, indices []int) {
+			defer wg.Done()
+			var cmd []byte
+			for _, i := range indices {
+				cmd = append(cmd, ops[i].command...)
+			}
+			done := 0
+			err := server.doContext(ctx, cmd, func(reader *bufio.Reader) error {
+				for _, i := range indices {
+					value, err := ops[i].parse(reader)
+					// A failed read leaves the rest of the responses unreadable.
+					if errors.Is(err, ErrReadFailed) || errors.Is(err, ErrUnexpectedResponse) {
+						return err
+					}
+					results[i].Value = value
+					results[i].Err = err
+					done++
+				}
+				return nil
+			})
+			if isNetworkError(err) {
+				abort()
+			}
+			if err != nil {
+				for _, i := range indices[done:] {
+					results[i].Err = err
+				}
+			}
+		}(server, indices)
+	}
+	wg.Wait()
+	p.results = append(p.results, results...)
+}
+
+// parseStored parses the response of a storage command.
+func parseStored(reader *bufio.Reader) (value string, err error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return
+	}
+	if line != "STORED" {
+		err = storeError(line)
+	}
+	return
+}
+
+// parseStatus returns a parser that expects a single response line for which ok reports success, e.g. touched,
+// and reports failure otherwise.
+func parseStatus(ok func(line string) bool, failure error) func(reader *bufio.Reader) (string, error) {
+	return func(reader *bufio.Reader) (value string, err error) {
+		line, err := readLine(reader)
+		if err != nil {
+			return
+		}
+		if !ok(line) {
+			err = failure
+		}
+		return
+	}
+}
@@ -0,0 +1,45 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPipelineDepthFlushes(t *testing.T) {
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithPipelineDepth(0)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithPipelineDepth(0) error = %v, want ErrInvalidOption", err)
+	}
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithPipelineDepth(2))
+	if err := c.Set("taken", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	p := c.Pipeline()
+	p.Set("a", "1", 0)
+	p.Add("taken", "2", 0)
+	// Reaching the depth flushed both commands and read their responses.
+	if len(p.ops) != 0 || len(p.results) != 2 {
+		t.Fatalf("%d queued and %d flushed commands at the depth, want 0 and 2", len(p.ops), len(p.results))
+	}
+	if value, _, ok := s.stored("a"); !ok || value != "1" {
+		t.Errorf("stored(a) = %q, %v after the automatic flush", value, ok)
+	}
+	p.Get("a")
+	if len(p.ops) != 1 {
+		t.Fatalf("%d queued commands below the depth, want 1", len(p.ops))
+	}
+	results, err := p.Exec()
+	// The failed Add is reported in its own result and in the joined error, and later commands still run.
+	if !errors.Is(err, ErrNotStored) {
+		t.Errorf("Exec() error = %v, want ErrNotStored", err)
+	}
+	want := []PipelineResult{{Key: "a"}, {Key: "taken", Err: ErrNotStored}, {Key: "a", Value: "1"}}
+	if len(results) != len(want) {
+		t.Fatalf("Exec() returned %d results, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		if r.Key != want[i].Key || r.Value != want[i].Value || !errors.Is(r.Err, want[i].Err) || (r.Err == nil) != (want[i].Err == nil) {
+			t.Errorf("result %d = %+v, want %+v", i, r, want[i])
+		}
+	}
+}
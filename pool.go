@@ -0,0 +1,128 @@
+package memcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// pool holds the connections to a server and hands each one to a single command at a time.
+// It has a fixed number of slots; a slot without an open connection gets one that dials lazily on its first use.
+type pool struct {
+	address string
+	config  connConfig
+	conns   chan *Conn // Free slots; nil slots have no connection yet.
+	closed  atomic.Bool
+
+	validateIdle time.Duration          // Idle time after which a connection is validated before use; zero disables validation.
+	validate     func(conn *Conn) error // Checks an idle connection with a cheap round trip.
+
+	maxIdle int          // Maximum number of open connections kept while idle; zero keeps every one.
+	idle    atomic.Int64 // Number of free slots holding an open connection.
+
+	acquireTimeout time.Duration // Time get waits for a free slot; zero waits indefinitely.
+}
+
+// newPool creates a pool of size connections to the given address.
+// The first connection is dialed right away so that an unreachable server is reported immediately.
+func newPool(address string, config connConfig, size int) (p *pool, err error) {
+	conn, err := newConn(address, config)
+	if err != nil {
+		return
+	}
+	p = &pool{
+		address: address,
+		config:  config,
+		conns:   make(chan *Conn, size),
+	}
+	p.conns <- conn
+	p.idle.Add(1)
+	for range size - 1 {
+		p.conns <- nil
+	}
+	return
+}
+
+// get waits for a free slot and returns its connection.
+// It returns ErrClosed once the pool is closed, and ErrPoolTimeout if no slot frees up within acquireTimeout.
+func (p *pool) get() (conn *Conn, err error) {
+	if p.closed.Load() {
+		err = ErrClosed
+		return
+	}
+	if p.acquireTimeout > 0 {
+		timer := time.NewTimer(p.acquireTimeout)
+		defer timer.Stop()
+		select {
+		case conn = <-p.conns:
+		case <-timer.C:
+			err = ErrPoolTimeout
+			return
+		}
+	} else {
+		conn = <-p.conns
+	}
+	if p.closed.Load() {
+		p.conns <- conn
+		conn = nil
+		err = ErrClosed
+		return
+	}
+	if conn == nil {
+		conn = &Conn{addr: p.address, config: p.config}
+		return
+	}
+	if conn.conn != nil {
+		p.idle.Add(-1)
+	}
+	// A connection that sat idle may have been dropped by the server or a middlebox without notice.
+	// If the check fails, it is closed, and the command dials a fresh one instead of failing.
+	if p.validateIdle > 0 && conn.conn != nil && time.Since(conn.lastUsed) > p.validateIdle {
+		if p.validate(conn) != nil {
+			conn.Close()
+		}
+	}
+	return
+}
+
+// put returns a connection obtained from get to the pool.
+// Beyond maxIdle idle connections, the connection is closed and its slot dials again on its next use.
+func (p *pool) put(conn *Conn) {
+	conn.lastUsed = time.Now()
+	if p.closed.Load() {
+		conn.Close()
+	}
+	if conn.conn != nil && !p.keepIdle() {
+		conn.Close()
+	}
+	p.conns <- conn
+}
+
+// keepIdle counts a connection given back to the pool as idle, and reports false if maxIdle connections already are.
+func (p *pool) keepIdle() bool {
+	for {
+		n := p.idle.Load()
+		if p.maxIdle > 0 && n >= int64(p.maxIdle) {
+			return false
+		}
+		if p.idle.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// close closes every connection of the pool, waiting for the connections in use to be returned.
+// Closing an already closed pool is a no-op.
+func (p *pool) close() {
+	if !p.closed.CompareAndSwap(false, true) {
+		return
+	}
+	for range cap(p.conns) {
+		if conn := <-p.conns; conn != nil {
+			conn.Close()
+		}
+	}
+	// Give the slots back so that callers that were waiting for one observe the closed pool instead of blocking.
+	for range cap(p.conns) {
+		p.conns <- nil
+	}
+}
@@ -0,0 +1,159 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxOpenConns(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want int64
+	}{
+		{"alone", []Option{WithMaxOpenConns(3)}, 3},
+		{"below the pool", []Option{WithPool(8), WithMaxOpenConns(2)}, 2},
+		{"above the pool", []Option{WithPool(2), WithMaxOpenConns(8)}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t)
+			s.delay.Store(int64(5 * time.Millisecond))
+			c := newTestClient(t, []string{s.addr()}, tt.opts...)
+			var wg sync.WaitGroup
+			for range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := c.Get("key"); !errors.Is(err, ErrNotFound) {
+						t.Errorf("Get() error = %v, want ErrNotFound", err)
+					}
+				}()
+			}
+			wg.Wait()
+			if got := s.accepted.Load(); got != tt.want {
+				t.Errorf("the server accepted %d connections, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithMaxOpenConns(1), WithAcquireTimeout(50*time.Millisecond))
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server := c.servers[0]
+		// Hold the only connection while the slow command runs.
+		conn, err := server.acquire()
+		close(started)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		time.Sleep(300 * time.Millisecond)
+		server.release(conn, &err)
+	}()
+	<-started
+	start := time.Now()
+	if _, err := c.Get("key"); !errors.Is(err, ErrPoolTimeout) {
+		t.Errorf("Get() error = %v while every connection is in use, want ErrPoolTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("Get() waited %v, want about 50ms", elapsed)
+	}
+	<-done
+	if _, err := c.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v once the connection is free, want ErrNotFound", err)
+	}
+	for _, opt := range []Option{WithMaxOpenConns(0), WithAcquireTimeout(0)} {
+		if _, err := NewClientWithOptions(WithServers(s.addr()), opt); !errors.Is(err, ErrInvalidOption) {
+			t.Errorf("NewClientWithOptions() error = %v, want ErrInvalidOption", err)
+		}
+	}
+}
+
+func BenchmarkGetConcurrent(b *testing.B) {
+	const callers = 100
+	for _, size := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("MaxOpenConns%d", size), func(b *testing.B) {
+			s := newFakeServer(b)
+			// A round trip on loopback is too fast for the connections to matter without some server latency.
+			s.delay.Store(int64(100 * time.Microsecond))
+			c := newTestClient(b, []string{s.addr()}, WithMaxOpenConns(size))
+			if err := c.Set("key", "value", 0); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for i := range callers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range b.N / callers {
+						if _, err := c.Get("key"); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+					// The remainder of b.N is spread over the first callers.
+					if i < b.N%callers {
+						if _, err := c.Get("key"); err != nil {
+							b.Error(err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestValidateOnBorrow(t *testing.T) {
+	if _, err := NewClientWithOptions(WithServers("127.0.0.1:11211"), WithValidateOnBorrow(0)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithValidateOnBorrow(0) error = %v, want ErrInvalidOption", err)
+	}
+	const idle = 50 * time.Millisecond
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithSingleConnection(), WithValidateOnBorrow(idle))
+	versions := func() (n int) {
+		for _, cmd := range s.received() {
+			if cmd == "version" {
+				n++
+			}
+		}
+		return
+	}
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	// Recently used connections are not checked.
+	if _, err := c.Get("key"); err != nil {
+		t.Fatal(err)
+	}
+	if n := versions(); n != 0 {
+		t.Errorf("%d version checks for a recently used connection, want 0", n)
+	}
+
+	// The server drops the idle connection without notice, so the check replaces it before the command.
+	s.dropConns()
+	time.Sleep(2 * idle)
+	if value, err := c.Get("key"); err != nil || value != "value" {
+		t.Errorf("Get() = %q, %v after the idle connection was dropped, want the value", value, err)
+	}
+	if n := versions(); n != 0 {
+		t.Errorf("%d version checks reached the server, want the check to fail on the dropped connection", n)
+	}
+	time.Sleep(2 * idle)
+	if _, err := c.Get("key"); err != nil {
+		t.Fatal(err)
+	}
+	if n := versions(); n != 1 {
+		t.Errorf("%d version checks after an idle period, want 1", n)
+	}
+}
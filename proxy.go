@@ -0,0 +1,46 @@
+package memcache
+
+import "strings"
+
+// Proxies supported by WithProxyMode.
+const (
+	ProxyMcrouter  = "mcrouter"
+	ProxyTwemproxy = "twemproxy"
+)
+
+// unsupportedByProxy lists, per proxy, the commands that cannot be sent through it.
+// Twemproxy closes the connection on commands it does not know, so they are rejected before being sent.
+var unsupportedByProxy = map[string]map[string]bool{
+	ProxyMcrouter: {
+		"verbosity":   true,
+		"watch":       true,
+		"lru_crawler": true,
+	},
+	ProxyTwemproxy: {
+		"stats":       true,
+		"version":     true,
+		"flush_all":   true,
+		"verbosity":   true,
+		"watch":       true,
+		"lru_crawler": true,
+		"gat":         true,
+		"gats":        true,
+		"mg":          true,
+		"ms":          true,
+		"md":          true,
+		"ma":          true,
+		"mn":          true,
+	},
+}
+
+// checkProxy returns ErrUnsupportedByProxy if the command cannot be sent through the proxy in front of the server.
+func (s *Server) checkProxy(cmd string) (err error) {
+	if s.proxy == "" {
+		return
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(cmd), " ")
+	if unsupportedByProxy[s.proxy][name] {
+		err = ErrUnsupportedByProxy
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyOnConnectBehindTwemproxy(t *testing.T) {
+	s := newFakeServer(t)
+	// Twemproxy closes the connection on commands it does not know.
+	s.closeConnOn("version")
+	c := newTestClient(t, []string{s.addr()}, WithProxyMode(ProxyTwemproxy), WithVerifyOnConnect())
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v; want %q", got, err, "value")
+	}
+	if _, err := c.Version(s.addr()); !errors.Is(err, ErrUnsupportedByProxy) {
+		t.Errorf("Version() error = %v, want ErrUnsupportedByProxy", err)
+	}
+}
+
+func TestVerifyOnConnectDirect(t *testing.T) {
+	s := newFakeServer(t)
+	s.closeConnOn("version")
+	if _, err := NewClientWithOptions(WithServers(s.addr()), WithVerifyOnConnect()); !errors.Is(err, ErrNotMemcached) {
+		t.Fatalf("NewClientWithOptions() error = %v, want ErrNotMemcached", err)
+	}
+}
+
+func TestSetNoReplyBehindTwemproxy(t *testing.T) {
+	s := newFakeServer(t)
+	s.closeConnOn("mn")
+	c := newTestClient(t, []string{s.addr()}, WithProxyMode(ProxyTwemproxy))
+	if err := c.SetNoReply("key", "value", 0); !errors.Is(err, ErrUnsupportedByProxy) {
+		t.Fatalf("SetNoReply() error = %v, want ErrUnsupportedByProxy", err)
+	}
+	// The connection was left in sync, so the next command is answered normally.
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v; want %q", got, err, "value")
+	}
+	for _, cmd := range s.received() {
+		if cmd == "mn" || cmd == "set key 0 0 5 noreply" {
+			t.Errorf("the proxy received %q", cmd)
+		}
+	}
+}
+
+func TestWriteBatcherBehindTwemproxy(t *testing.T) {
+	s := newFakeServer(t)
+	s.closeConnOn("mn")
+	c := newTestClient(t, []string{s.addr()}, WithProxyMode(ProxyTwemproxy))
+	var errs []error
+	b, err := c.NewWriteBatcher(WriteBatcherConfig{OnError: func(err error) { errs = append(errs, err) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Set("key", "value", 0); !errors.Is(err, ErrUnsupportedByProxy) {
+		t.Fatalf("Set() error = %v, want ErrUnsupportedByProxy", err)
+	}
+	b.Close()
+	if len(errs) != 0 || len(s.received()) != 0 {
+		t.Errorf("the batch was flushed through the proxy: %v, %q", errs, s.received())
+	}
+}
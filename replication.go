@@ -0,0 +1,79 @@
+package memcache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// replicate applies a write that succeeded on the primary server of a key to its replicas, the following servers
+// on the ring up to the number of copies configured with WithReplication. The key is already namespaced.
+// Replicas that are unavailable are skipped, leaving a stale copy for read repair to fix.
+// It returns the joined errors of the replicas.
+func (c *Client) replicate(key string, write func(server *Server) error) (err error) {
+	if c.replicas <= 1 {
+		return
+	}
+	servers, err := c.pickServers(key, c.replicas)
+	if err != nil {
+		return
+	}
+	for _, server := range servers[1:] {
+		if !server.available() {
+			continue
+		}
+		if werr := write(server); werr != nil {
+			err = errors.Join(err, fmt.Errorf("%s: %w", server.Address, werr))
+		}
+	}
+	return
+}
+
+// repairReplicas compares the item read from a server with the copies on the replicas of its key, and rewrites
+// the replicas whose copy is missing or differs. It only runs with WithReadRepair and WithReplication, and only
+// if the item was read from the primary server. The key of the item is already namespaced.
+//
+// CAS tokens are assigned by each server independently and cannot be compared across servers, so copies are compared
+// by value and flags, and the copy of the primary server wins, since writes reach it first. The remaining time to live
+// of the item is read with a meta get command so that repaired copies expire with it; servers without meta commands
+// are not repaired. Failures are ignored: the read already succeeded, and a later read tries again.
+func (c *Client) repairReplicas(server *Server, item *Item) {
+	if !c.readRepair || c.replicas <= 1 {
+		return
+	}
+	servers, err := c.pickServers(item.Key, c.replicas)
+	if err != nil || servers[0] != server {
+		return
+	}
+	expiration := 0
+	ttlRead := false
+	for _, replica := range servers[1:] {
+		if !replica.available() {
+			continue
+		}
+		current, err := replica.GetItem(item.Key, false)
+		if err == nil && current.Flags == item.Flags && bytes.Equal(current.Value, item.Value) {
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if !ttlRead {
+			ttl, err := server.ttl(item.Key)
+			if err != nil {
+				return
+			}
+			switch {
+			case ttl == TTLNever:
+				expiration = NeverExpire
+			case ttl <= 0:
+				// The item is about to expire, and a zero expiration would keep the copies forever.
+				return
+			default:
+				expiration = ExpirationFromDuration(ttl)
+			}
+			ttlRead = true
+		}
+		storeItem(replica, &Item{Key: item.Key, Value: item.Value, Flags: item.Flags, Expiration: expiration})
+	}
+}
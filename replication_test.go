@@ -0,0 +1,49 @@
+package memcache
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// firstServer places every key on the first server, so that the second one holds the replicas.
+func firstServer(key string) uint32 { return 0 }
+
+func TestReadRepairSkipsFailedReads(t *testing.T) {
+	// The primary server answers with a value but no "END" line; its TTL would allow a repair.
+	primary := newScriptedServer(t, func(line string) string {
+		if strings.HasPrefix(line, "mg ") {
+			return "HD t-1\r\n"
+		}
+		return "VALUE key 0 5\r\nvalue\r\nBOGUS\r\n"
+	})
+	replica := newFakeServer(t)
+	c := newTestClient(t, []string{primary, replica.addr()}, WithHasher(firstServer), WithReplication(2), WithReadRepair())
+	if _, err := c.Get("key"); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Fatalf("Get() error = %v, want ErrUnexpectedResponse", err)
+	}
+	if n := replica.itemCount(); n != 0 {
+		t.Errorf("the replica holds %d items after a failed read, want 0", n)
+	}
+}
+
+func TestReadRepairKeepsNeverExpire(t *testing.T) {
+	primary, replica := newFakeServer(t), newFakeServer(t)
+	c := newTestClient(t, []string{primary.addr(), replica.addr()}, WithHasher(firstServer), WithReplication(2), WithReadRepair())
+	// The value only reaches the primary server.
+	if err := storeItem(c.servers[0], &Item{Key: "key", Value: []byte("value"), Expiration: NeverExpire}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v; want %q", got, err, "value")
+	}
+	replica.mu.Lock()
+	item, ok := replica.items["key"]
+	replica.mu.Unlock()
+	if !ok {
+		t.Fatal("the replica was not repaired")
+	}
+	if item.expiration != 0 {
+		t.Errorf("the repaired copy expires at %d, want never", item.expiration)
+	}
+}
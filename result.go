@@ -0,0 +1,173 @@
+package memcache
+
+import "errors"
+
+// KeyStatus is the outcome of a batch operation for a single key.
+type KeyStatus int
+
+const (
+	StatusHit   KeyStatus = iota + 1 // The key was found, or the write was applied.
+	StatusMiss                       // The key does not exist, or the server declined the write, e.g. with NOT_STORED or NOT_FOUND.
+	StatusError                      // The operation failed, e.g. because the server could not be reached.
+)
+
+// String returns the name of the status.
+func (s KeyStatus) String() string {
+	switch s {
+	case StatusHit:
+		return "hit"
+	case StatusMiss:
+		return "miss"
+	case StatusError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// KeyResult is the outcome of a batch operation for a single key.
+type KeyResult struct {
+	Key    string    // The key as given by the caller.
+	Status KeyStatus // Whether the key was a hit, a miss, or failed.
+	Item   *Item     // The retrieved item, for hits of retrieval operations.
+	Err    error     // The error, for misses of write operations and for failures.
+}
+
+// MultiResult holds the per-key outcomes of a batch operation such as GetBatch, SetBatch or DeleteBatch.
+// Unlike a map of values, it tells keys that do not exist apart from keys whose server failed.
+type MultiResult struct {
+	keys    []string // The distinct keys in the order they were given.
+	results map[string]KeyResult
+}
+
+// newMultiResult creates an empty result for the given keys; duplicate keys are kept once.
+func newMultiResult(keys []string) *MultiResult {
+	r := &MultiResult{results: make(map[string]KeyResult, len(keys))}
+	for _, key := range keys {
+		if _, ok := r.results[key]; ok {
+			continue
+		}
+		r.keys = append(r.keys, key)
+		r.results[key] = KeyResult{Key: key}
+	}
+	return r
+}
+
+// set records the outcome of a key.
+func (r *MultiResult) set(key string, status KeyStatus, item *Item, err error) {
+	r.results[key] = KeyResult{Key: key, Status: status, Item: item, Err: err}
+}
+
+// setWrite records the outcome of a write: StatusHit on success, StatusMiss if the server declined it with ErrStoreFailed,
+// and StatusError otherwise.
+func (r *MultiResult) setWrite(key string, err error) {
+	switch {
+	case err == nil:
+		r.set(key, StatusHit, nil, nil)
+	case errors.Is(err, ErrStoreFailed):
+		r.set(key, StatusMiss, nil, err)
+	default:
+		r.set(key, StatusError, nil, err)
+	}
+}
+
+// Keys returns the distinct keys of the operation, in the order they were given.
+func (r *MultiResult) Keys() []string {
+	return append([]string(nil), r.keys...)
+}
+
+// Get returns the outcome of the given key, and false if the key was not part of the operation.
+func (r *MultiResult) Get(key string) (result KeyResult, ok bool) {
+	result, ok = r.results[key]
+	return
+}
+
+// Hits returns the items of the keys that were found, keyed by key. For write operations, the items are nil.
+func (r *MultiResult) Hits() map[string]*Item {
+	hits := make(map[string]*Item)
+	for _, key := range r.keys {
+		if result := r.results[key]; result.Status == StatusHit {
+			hits[key] = result.Item
+		}
+	}
+	return hits
+}
+
+// Misses returns the keys that do not exist, or whose write the server declined, in the order they were given.
+func (r *MultiResult) Misses() (keys []string) {
+	for _, key := range r.keys {
+		if r.results[key].Status == StatusMiss {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+// Errors returns the errors of the keys that failed, keyed by key.
+func (r *MultiResult) Errors() map[string]error {
+	errs := make(map[string]error)
+	for _, key := range r.keys {
+		if result := r.results[key]; result.Status == StatusError {
+			errs[key] = result.Err
+		}
+	}
+	return errs
+}
+
+// Err returns the joined errors of the keys that failed, or nil if no key failed. Misses are not errors.
+func (r *MultiResult) Err() (err error) {
+	for _, key := range r.keys {
+		if result := r.results[key]; result.Status == StatusError {
+			err = errors.Join(err, result.Err)
+		}
+	}
+	return
+}
+
+// GetBatch retrieves the items of the given keys like GetMulti, and reports every key as a hit, a miss, or an error.
+func (c *Client) GetBatch(keys []string) (result *MultiResult) {
+	items, errs := c.getItems(keys, false)
+	result = newMultiResult(keys)
+	for _, key := range result.keys {
+		if err, failed := errs[key]; failed {
+			result.set(key, StatusError, nil, err)
+		} else if item, ok := items[key]; ok {
+			result.set(key, StatusHit, item, nil)
+		} else {
+			result.set(key, StatusMiss, nil, nil)
+		}
+	}
+	return
+}
+
+// SetBatch stores every item like SetMulti, and reports every key as a hit if it was stored,
+// a miss if the server declined to store it, or an error.
+func (c *Client) SetBatch(items []*Item) (result *MultiResult) {
+	p := c.Pipeline()
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+		p.SetItem(item)
+	}
+	return writeResult(keys, p)
+}
+
+// DeleteBatch removes the given keys with a pipelined "delete" command per key, one round trip per server,
+// and reports every key as a hit if it was deleted, a miss if it did not exist, or an error.
+func (c *Client) DeleteBatch(keys []string) (result *MultiResult) {
+	p := c.Pipeline()
+	for _, key := range keys {
+		p.Delete(key)
+	}
+	return writeResult(keys, p)
+}
+
+// writeResult runs the pipelined writes of the given keys and collects their outcomes.
+// If a key was written more than once, the outcome of its last write is kept.
+func writeResult(keys []string, p *Pipeline) (result *MultiResult) {
+	results, _ := p.Exec()
+	result = newMultiResult(keys)
+	for _, r := range results {
+		result.setWrite(r.Key, r.Err)
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestMultiResultStatuses(t *testing.T) {
+	up, down := newFakeServer(t), newFakeServer(t)
+	c := newTestClient(t, []string{up.addr(), down.addr()})
+	// Pick two keys on the server that stays up and one on the server that goes away.
+	var onUp []string
+	lost := ""
+	for i := 0; len(onUp) < 2 || lost == ""; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if server, _ := c.pickServer(key); server.Address == up.addr() {
+			onUp = append(onUp, key)
+		} else {
+			lost = key
+		}
+	}
+	hit, miss := onUp[0], onUp[1]
+	set := c.SetBatch([]*Item{{Key: hit, Value: []byte("value")}, {Key: "bad key", Value: []byte("value")}})
+	if _, ok := set.Hits()[hit]; !ok || !errors.Is(set.Errors()["bad key"], ErrInvalidKey) {
+		t.Fatalf("SetBatch() hits %v and errors %v, want %s stored and the invalid key failed", set.Hits(), set.Errors(), hit)
+	}
+	down.close()
+
+	get := c.GetBatch([]string{hit, miss, lost, hit})
+	if keys := get.Keys(); !slices.Equal(keys, []string{hit, miss, lost}) {
+		t.Errorf("Keys() = %q, want the distinct keys in order", keys)
+	}
+	if item := get.Hits()[hit]; item == nil || string(item.Value) != "value" {
+		t.Errorf("Hits() = %v, want %s", get.Hits(), hit)
+	}
+	// A missing key is not an error, and a key whose server failed is not a miss.
+	if misses := get.Misses(); !slices.Equal(misses, []string{miss}) {
+		t.Errorf("Misses() = %q, want [%s]", misses, miss)
+	}
+	if errs := get.Errors(); len(errs) != 1 || errs[lost] == nil || get.Err() == nil {
+		t.Errorf("Errors() = %v, Err() = %v; want the error of %s", errs, get.Err(), lost)
+	}
+	if r, ok := get.Get(lost); !ok || r.Status != StatusError {
+		t.Errorf("Get(%q) = %+v, %v; want StatusError", lost, r, ok)
+	}
+	if _, ok := get.Get("other"); ok {
+		t.Error("Get() found a key that was not part of the operation")
+	}
+
+	del := c.DeleteBatch([]string{hit, miss})
+	if r, _ := del.Get(hit); r.Status != StatusHit {
+		t.Errorf("DeleteBatch() status of %s = %v, want hit", hit, r.Status)
+	}
+	if r, _ := del.Get(miss); r.Status != StatusMiss || del.Err() != nil {
+		t.Errorf("DeleteBatch() status of %s = %v with Err() = %v, want a miss and no error", miss, r.Status, del.Err())
+	}
+}
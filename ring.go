@@ -0,0 +1,90 @@
+package memcache
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// defaultVirtualNodes is the number of points a server with weight 1 gets on the ring by default, as in ketama.
+const defaultVirtualNodes = 160
+
+// ringPoint is a point on the consistent-hash ring owned by a server.
+type ringPoint struct {
+	hash   uint32
+	server *Server
+}
+
+// ring is a ketama-style consistent-hash ring.
+// Each server gets a number of points proportional to its weight, so adding or removing a server only remaps the keys around its points.
+type ring struct {
+	points []ringPoint
+}
+
+// newRing builds a ring from the given servers and their weights, giving each server virtualNodes points per unit of weight.
+func newRing(servers []*Server, virtualNodes int) (r *ring) {
+	r = &ring{}
+	for _, server := range servers {
+		// Like ketama, every MD5 digest of "<address>-<index>" yields four points.
+		var digest [md5.Size]byte
+		for i := range server.weight * virtualNodes {
+			if i%4 == 0 {
+				digest = md5.Sum(fmt.Appendf(nil, "%s-%d", server.Address, i/4))
+			}
+			r.points = append(r.points, ringPoint{
+				hash:   binary.LittleEndian.Uint32(digest[i%4*4:]),
+				server: server,
+			})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i].hash < r.points[j].hash
+	})
+	return
+}
+
+// lookup returns up to n distinct servers for the given key hash, walking the ring clockwise from it.
+func (r *ring) lookup(hash uint32, n int) (servers []*Server) {
+	if len(r.points) == 0 {
+		return
+	}
+	start := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	seen := make(map[*Server]struct{}, n)
+	for i := range r.points {
+		if len(servers) == n {
+			break
+		}
+		server := r.points[(start+i)%len(r.points)].server
+		if _, ok := seen[server]; ok {
+			continue
+		}
+		seen[server] = struct{}{}
+		servers = append(servers, server)
+	}
+	return
+}
+
+// RingEntry is a point on the consistent-hash ring and the address of the server owning it.
+type RingEntry struct {
+	Hash    uint32 // Position of the point on the ring; keys whose hash is at most Hash, down to the previous point, map to it.
+	Address string // Address of the server owning the point.
+}
+
+// RingState returns a copy of the points of the consistent-hash ring, sorted by hash, taken under the read lock.
+// Each server owns a number of points proportional to its weight, so the result shows how keys are distributed.
+// It is only meaningful with WithWeightedRing; with modulo hashing there is no ring and it returns nil.
+func (c *Client) RingState() (entries []RingEntry) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.ring == nil {
+		return
+	}
+	entries = make([]RingEntry, len(c.ring.points))
+	for i, point := range c.ring.points {
+		entries[i] = RingEntry{Hash: point.hash, Address: point.server.Address}
+	}
+	return
+}
@@ -0,0 +1,180 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"testing"
+)
+
+// ringDistribution returns the address of the server each key maps to on a ring of the given servers.
+func ringDistribution(servers []*Server, keys []string) (distribution map[string]string) {
+	r := newRing(servers, defaultVirtualNodes)
+	distribution = make(map[string]string, len(keys))
+	for _, key := range keys {
+		distribution[key] = r.lookup(crc32.ChecksumIEEE([]byte(key)), 1)[0].Address
+	}
+	return
+}
+
+func TestRingRemapsOneNth(t *testing.T) {
+	const n = 5
+	servers := make([]*Server, n)
+	for i := range servers {
+		servers[i] = &Server{Address: fmt.Sprintf("10.0.0.%d:11211", i+1), weight: 1}
+	}
+	keys := make([]string, 5000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	all := ringDistribution(servers, keys)
+	for i, server := range servers {
+		// Removing one of the servers, or adding it back, remaps only its own keys, about a fifth of them.
+		others := append(append([]*Server(nil), servers[:i]...), servers[i+1:]...)
+		without := ringDistribution(others, keys)
+		moved := 0
+		for key, addr := range all {
+			switch {
+			case addr == server.Address:
+				moved++
+				if without[key] == server.Address {
+					t.Fatalf("key %q is still mapped to the removed server", key)
+				}
+			case without[key] != addr:
+				t.Errorf("key %q moved from %s to %s, neither of which changed", key, addr, without[key])
+			}
+		}
+		if got := float64(moved) / float64(len(keys)); math.Abs(got-1.0/n) > 0.3/n {
+			t.Errorf("removing %s remapped %.3f of the keys, want about %.3f", server.Address, got, 1.0/n)
+		}
+	}
+}
+
+func TestWithVirtualNodes(t *testing.T) {
+	addrs := []string{newFakeServer(t).addr(), newFakeServer(t).addr()}
+	c := newTestClient(t, addrs, WithVirtualNodes(40))
+	if got := len(c.RingState()); got != 2*40 {
+		t.Errorf("the ring has %d points, want %d", got, 2*40)
+	}
+	if _, err := NewClientWithOptions(WithServers(addrs...), WithVirtualNodes(0)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("WithVirtualNodes(0) error = %v, want ErrInvalidOption", err)
+	}
+}
+
+func TestRingState(t *testing.T) {
+	addrs := []string{newFakeServer(t).addr(), newFakeServer(t).addr()}
+	if entries := newTestClient(t, addrs).RingState(); entries != nil {
+		t.Errorf("RingState() = %d entries with modulo hashing, want nil", len(entries))
+	}
+
+	c := newTestClient(t, addrs, WithVirtualNodes(8))
+	entries := c.RingState()
+	if len(entries) != 2*8 {
+		t.Fatalf("RingState() = %d entries, want %d", len(entries), 2*8)
+	}
+	for i, entry := range entries {
+		if entry.Address != addrs[0] && entry.Address != addrs[1] {
+			t.Errorf("entry %d is owned by %q, want one of %v", i, entry.Address, addrs)
+		}
+		if i > 0 && entries[i-1].Hash > entry.Hash {
+			t.Errorf("entry %d has hash %d after %d, want the entries sorted", i, entry.Hash, entries[i-1].Hash)
+		}
+	}
+	// The result is a copy, so changing it or the ring leaves the other unchanged.
+	entries[0].Address = "modified"
+	if c.RingState()[0].Address == "modified" {
+		t.Error("changing the result of RingState() changed the ring")
+	}
+	if err := c.SetWeight(addrs[0], 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(c.RingState()); got != 3*8 || len(entries) != 2*8 {
+		t.Errorf("RingState() = %d entries after SetWeight and the earlier copy %d, want %d and %d", got, len(entries), 3*8, 2*8)
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	addrs := []string{newFakeServer(t).addr(), newFakeServer(t).addr()}
+	c := newTestClient(t, addrs, WithWeightedRing())
+	for _, tt := range []struct {
+		addr   string
+		weight int
+		want   error
+	}{
+		{addrs[0], 0, ErrInvalidWeight},
+		{addrs[0], -1, ErrInvalidWeight},
+		{"127.0.0.1:1", 2, ErrNotFound},
+	} {
+		if err := c.SetWeight(tt.addr, tt.weight); !errors.Is(err, tt.want) {
+			t.Errorf("SetWeight(%q, %d) error = %v, want %v", tt.addr, tt.weight, err, tt.want)
+		}
+	}
+	if err := c.SetWeight(addrs[0], 3); err != nil {
+		t.Fatal(err)
+	}
+	points := make(map[string]int)
+	for _, entry := range c.RingState() {
+		points[entry.Address]++
+	}
+	if points[addrs[0]] != 3*defaultVirtualNodes || points[addrs[1]] != defaultVirtualNodes {
+		t.Errorf("the rebuilt ring has %d and %d points, want %d and %d", points[addrs[0]], points[addrs[1]], 3*defaultVirtualNodes, defaultVirtualNodes)
+	}
+	// The heavier server receives about three quarters of the keys.
+	heavy := 0
+	const keys = 4000
+	for i := range keys {
+		server, err := c.pickServer(fmt.Sprintf("key-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server.Address == addrs[0] {
+			heavy++
+		}
+	}
+	if got := float64(heavy) / keys; math.Abs(got-0.75) > 0.1 {
+		t.Errorf("the server of weight 3 received %.3f of the keys, want about 0.75", got)
+	}
+}
+
+func TestKeyDistribution(t *testing.T) {
+	addrs := []string{newFakeServer(t).addr(), newFakeServer(t).addr()}
+	c := newTestClient(t, addrs, WithWeightedRing())
+	keys := []string{"bad key", ""}
+	for i := range 3000 {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	before := c.KeyDistribution(keys)
+	// Keys that cannot be routed are absent.
+	if _, ok := before["bad key"]; ok || len(before) != len(keys)-2 {
+		t.Fatalf("KeyDistribution() mapped %d of %d keys, want every valid key", len(before), len(keys))
+	}
+	for _, key := range keys[2:10] {
+		if server, err := c.pickServer(key); err != nil || server.Address != before[key] {
+			t.Errorf("KeyDistribution()[%q] = %s, but the key is routed to %v", key, before[key], server)
+		}
+	}
+
+	added := newFakeServer(t).addr()
+	if err := c.AddServer(added); err != nil {
+		t.Fatal(err)
+	}
+	moved := 0
+	for key, addr := range c.KeyDistribution(keys) {
+		if addr == before[key] {
+			continue
+		}
+		moved++
+		if addr != added {
+			t.Fatalf("key %q moved from %s to %s, not to the added server", key, before[key], addr)
+		}
+	}
+	if got := float64(moved) / float64(len(before)); math.Abs(got-1.0/3) > 0.15 {
+		t.Errorf("adding a third server moved %.3f of the keys, want about 0.333", got)
+	}
+
+	c.Close()
+	if got := c.KeyDistribution(keys); len(got) != 0 {
+		t.Errorf("KeyDistribution() mapped %d keys after Close, want none", len(got))
+	}
+}
@@ -0,0 +1,57 @@
+package memcache
+
+// SetRouted stores a key-value pair like Set, but on the server selected by routeKey instead of key.
+// Keys stored under the same routing key live on the same server, which groups related keys without
+// embedding hash tags in them. The value must be read back with GetRouted and the same routing key.
+//
+// Only SetRouted and GetRouted route on a separate key: Delete, Touch, multi-key operations such as
+// GetMulti and SetMulti, and pipelines always route on the key itself, and cannot reach routed keys
+// unless both keys happen to map to the same server.
+func (c *Client) SetRouted(routeKey, key, value string, expiration int) (err error) {
+	defer c.hook("SetRouted", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	// Only the routing key is validated by server selection.
+	if err = validateKey(key); err != nil {
+		return
+	}
+	server, err := c.pickWriteServer(routeKey)
+	if err != nil {
+		return
+	}
+	return storeItem(server, &Item{Key: key, Value: []byte(value), Expiration: expiration})
+}
+
+// GetRouted retrieves the value stored with SetRouted under key, from the server selected by routeKey.
+// Like Get, it decompresses values marked with FlagCompressed and retries network failures on the following servers
+// when WithReadRetries is enabled.
+func (c *Client) GetRouted(routeKey, key string) (value string, err error) {
+	defer c.hook("GetRouted", key, &err)()
+	key, err = c.namespacedKey(key)
+	if err != nil {
+		return
+	}
+	// Only the routing key is validated by server selection.
+	if err = validateKey(key); err != nil {
+		return
+	}
+	servers, err := c.pickReadServers(routeKey)
+	if err != nil {
+		return
+	}
+	for _, server := range servers {
+		var item *Item
+		item, err = server.GetItem(key, false)
+		if err == nil {
+			err = decompressItem(item)
+			value = string(item.Value)
+		}
+		// Only network failures are retried on the next server.
+		if !isNetworkError(err) {
+			return
+		}
+	}
+	return
+}
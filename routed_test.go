@@ -0,0 +1,53 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRoutedKeysLiveOnTheRouteServer(t *testing.T) {
+	fakes := map[string]*fakeServer{}
+	var addrs []string
+	for range 3 {
+		s := newFakeServer(t)
+		fakes[s.addr()] = s
+		addrs = append(addrs, s.addr())
+	}
+	c := newTestClient(t, addrs)
+	route, err := c.pickServer("group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Find a key that maps to another server on its own.
+	key := ""
+	for i := 0; key == ""; i++ {
+		if server, _ := c.pickServer(fmt.Sprintf("item-%d", i)); server != route {
+			key = fmt.Sprintf("item-%d", i)
+		}
+	}
+	if err = c.SetRouted("group", key, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	for addr, s := range fakes {
+		if _, _, ok := s.stored(key); ok != (addr == route.Address) {
+			t.Errorf("%s holds the key = %v, want it only on the server of the routing key", addr, ok)
+		}
+	}
+	if value, err := c.GetRouted("group", key); err != nil || value != "value" {
+		t.Errorf("GetRouted() = %q, %v", value, err)
+	}
+	// Operations routing on the key itself cannot reach it.
+	if _, err := c.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v for a routed key, want ErrNotFound", err)
+	}
+
+	for _, tt := range []struct{ route, key string }{{"group", "bad key"}, {"bad route", key}} {
+		if err := c.SetRouted(tt.route, tt.key, "value", 0); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("SetRouted(%q, %q) error = %v, want ErrInvalidKey", tt.route, tt.key, err)
+		}
+		if _, err := c.GetRouted(tt.route, tt.key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("GetRouted(%q, %q) error = %v, want ErrInvalidKey", tt.route, tt.key, err)
+		}
+	}
+}
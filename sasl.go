@@ -0,0 +1,36 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"time"
+)
+
+// saslPlain returns the function authenticating a newly dialed connection with a binary SASL Auth request
+// of the PLAIN mechanism, whose single step carries "\x00<username>\x00<password>".
+// It returns ErrAuthFailed if the server rejects the credentials.
+func saslPlain(username, password string) func(conn net.Conn) error {
+	return func(conn net.Conn) (err error) {
+		if err = conn.SetDeadline(time.Now().Add(defaultPingTimeout)); err != nil {
+			return
+		}
+		req := &binaryRequest{opcode: opSASLAuth, key: "PLAIN", value: []byte("\x00" + username + "\x00" + password)}
+		if _, err = conn.Write(req.appendTo(nil)); err != nil {
+			return errors.Join(ErrWriteFailed, err)
+		}
+		res, err := readBinaryResponse(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		switch res.status {
+		case statusNoError:
+		case statusAuthError, statusAuthContinue:
+			// PLAIN completes in a single step, so a request for another step is a rejection as well.
+			return errors.Join(ErrAuthFailed, errors.New(string(res.value)))
+		default:
+			return res.err()
+		}
+		return conn.SetDeadline(time.Time{})
+	}
+}
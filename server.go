@@ -2,56 +2,252 @@ package memcache
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Server represents a memcached server with its address, connection, and a mutex for thread-safety.
 type Server struct {
-	Address string     // The network address of the memcached server.
-	conn    *Conn      // The connection to the memcached server.
-	mu      sync.Mutex // Mutex to ensure thread-safe operations on the server connection.
+	Address      string         // The network address of the memcached server.
+	pool         *pool          // The connections to the memcached server, each used by one command at a time.
+	latency      latencyTracker // Moving average of the round-trip latency of commands.
+	weight       int            // Relative share of keys on the weighted ring; guarded by the client's lock.
+	breaker      *breaker       // Circuit breaker tracking network failures; nil when disabled.
+	proxy        string         // The proxy in front of the server, if any (see WithProxyMode).
+	binary       bool           // Whether the server is spoken to with the binary protocol (see WithBinaryProtocol).
+	extraTimeout time.Duration  // Bound of Extra and ExtraLine; zero disables it (see WithExtraTimeout).
+	gets         binaryQueue    // Binary Get requests waiting to share a round trip.
+
+	itemSizeMax  atomic.Int64                 // Cached item_size_max setting; zero until read after a connection is dialed.
+	capabilities atomic.Pointer[Capabilities] // Cached snapshot of ServerCapabilities; nil until taken after a connection is dialed.
+
+	unhealthy atomic.Bool // Whether the last health check failed.
 }
 
 // NewServer creates a new Server instance using the provided address.
 // It establishes a connection to the server and returns an error if the connection fails.
 func NewServer(address string) (s *Server, err error) {
-	conn, err := NewConn(address)
+	return newServer(address, connConfig{}, 1)
+}
+
+// newServer creates a new Server instance with a pool of poolSize connections that use the given settings.
+func newServer(address string, config connConfig, poolSize int) (s *Server, err error) {
+	server := &Server{
+		Address: address,
+		weight:  1,
+	}
+	// A new connection may reach a restarted server with other settings, so the cached ones are dropped.
+	config.onConnect = func() {
+		server.itemSizeMax.Store(0)
+		server.capabilities.Store(nil)
+	}
+	if server.pool, err = newPool(address, config, poolSize); err != nil {
+		return
+	}
+	s = server
+	return
+}
+
+// validateOnBorrow makes the pool check connections that were idle for longer than idle before handing them out (see WithValidateOnBorrow).
+func (s *Server) validateOnBorrow(idle time.Duration) {
+	s.pool.validateIdle = idle
+	s.pool.validate = s.validateConn
+}
+
+// validateConn checks an open connection with a cheap round trip, a "version" command or a binary Noop request.
+// It uses the network connection directly, so that a failure is reported instead of being retried on a new connection.
+func (s *Server) validateConn(conn *Conn) (err error) {
+	deadline := time.Now().Add(defaultPingTimeout)
+	if !conn.deadline.IsZero() && conn.deadline.Before(deadline) {
+		deadline = conn.deadline
+	}
+	if err = conn.conn.SetDeadline(deadline); err != nil {
+		return
+	}
+	// Restore the deadline of the connection; a zero deadline clears it.
+	defer conn.conn.SetDeadline(conn.deadline)
+	// Error lines pending after commands with "noreply" would be taken for the response of the ping, so syncing also validates it.
+	if conn.unsynced {
+		return s.sync(conn)
+	}
+	return ping(conn.conn, s.binary)
+}
+
+// ping sends a "version" command, or a binary Noop request, on a network connection and reads the response.
+// It returns ErrUnexpectedResponse if the response is not the one memcached sends.
+func ping(conn net.Conn, binary bool) (err error) {
+	req := []byte("version\r\n")
+	if binary {
+		req = (&binaryRequest{opcode: opNoop}).appendTo(nil)
+	}
+	if _, err = conn.Write(req); err != nil {
+		return
+	}
+	reader := bufio.NewReader(conn)
+	if binary {
+		var res *binaryResponse
+		if res, err = readBinaryResponse(reader); err == nil && res.opcode != opNoop {
+			err = ErrUnexpectedResponse
+		}
+		return
+	}
+	line, err := readLine(reader)
 	if err != nil {
 		return
 	}
-	s = &Server{
-		Address: address,
-		conn:    conn,
-		mu:      sync.Mutex{},
+	if !strings.HasPrefix(line, "VERSION") {
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("%q", line))
 	}
 	return
 }
 
+// verifyMemcached returns the check of WithVerifyOnConnect for connections speaking the given protocol:
+// it pings a newly dialed endpoint within defaultPingTimeout, and returns ErrNotMemcached if it does not answer like memcached.
+func verifyMemcached(binary bool) func(conn net.Conn) error {
+	return func(conn net.Conn) (err error) {
+		if err = conn.SetDeadline(time.Now().Add(defaultPingTimeout)); err != nil {
+			return
+		}
+		if err = ping(conn, binary); err != nil {
+			return errors.Join(ErrNotMemcached, err)
+		}
+		return conn.SetDeadline(time.Time{})
+	}
+}
+
+// acquire takes a connection from the pool for a single command, after syncing it if commands with "noreply" were sent on it.
+// The connection must be given back with release, deferred with a pointer to the named error result of the command.
+func (s *Server) acquire() (conn *Conn, err error) {
+	if conn, err = s.pool.get(); err != nil || !conn.unsynced {
+		return
+	}
+	if err = s.sync(conn); err != nil {
+		s.release(conn, &err)
+		conn = nil
+	}
+	return
+}
+
+// sync reads the responses still pending on a connection that commands with "noreply" were sent on.
+// Memcached still answers such a command when it fails, e.g. with "SERVER_ERROR out of memory storing object",
+// and the error line would be mistaken for the response of the next command. sync sends a meta no-op ("mn")
+// and discards every line up to its "MN" response, or up to the "ERROR" a server without meta commands answers it with.
+func (s *Server) sync(conn *Conn) (err error) {
+	// mn\r\n
+	if _, err = conn.Write([]byte("mn\r\n")); err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	reader := conn.bufferedReader()
+	for {
+		var line string
+		if line, err = readLine(reader); err != nil {
+			return
+		}
+		// Failed commands are answered with CLIENT_ERROR or SERVER_ERROR, so a bare ERROR is the unknown barrier.
+		if line == "MN" || line == "ERROR" {
+			conn.unsynced = false
+			return
+		}
+	}
+}
+
+// release gives a connection taken with acquire back to the pool.
+// A connection whose command failed midway is never reused: after a failed write or read, or after ErrUnexpectedResponse,
+// part of the request or of the response may still be pending and would be mistaken for the response of the next command.
+// The connection is closed, even if it was re-established during the command, and the next command using its slot dials a fresh one.
+func (s *Server) release(conn *Conn, err *error) {
+	if isConnError(*err) {
+		conn.Close()
+	}
+	s.pool.put(conn)
+}
+
+// errDesync marks an error answered by the server after which the rest of the response is unknown, so that release
+// discards the connection. Unlike ErrReadFailed, it does not count against the server in the circuit breaker.
+var errDesync = errors.New("connection out of sync")
+
+// isConnError reports whether err leaves the connection of the failed command in an unknown state.
+// Errors reported by the server in a complete response, such as ErrNotFound, leave it in sync.
+func isConnError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, ErrWriteFailed) || errors.Is(err, ErrReadFailed) || errors.Is(err, ErrUnexpectedResponse) ||
+		errors.Is(err, ErrBatchAborted) || errors.Is(err, errDesync) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
 // WriteCommand sends a command string to the memcached server and reads a single-line response.
-// It locks the connection for thread-safety, and returns the trimmed response or an error.
+// It locks the connection for thread-safety, and returns the response trimmed as a control line (see trimControlLine) or an error.
+// It is meant for commands answered by a status line; it must not be used for commands whose response carries a data block.
 func (s *Server) WriteCommand(cmd string) (res string, err error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if err = s.checkText(cmd); err != nil {
+		return
+	}
+	conn, err := s.acquire()
+	if err != nil {
+		return
+	}
+	defer s.release(conn, &err)
+	defer s.observe(time.Now(), &err)
 
-	// Write the command to the server.
-	_, err = s.conn.Write([]byte(cmd))
+	// Write the command to the server, without retrying commands that must not be applied twice.
+	if isIdempotent(cmd) {
+		_, err = conn.Write([]byte(cmd))
+	} else {
+		_, err = conn.writeOnce([]byte(cmd))
+	}
 	if err != nil {
 		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
-	// Create a buffered reader for reading the response.
-	reader := bufio.NewReader(s.conn)
+	// Read the response through the buffered reader of the connection.
+	reader := conn.bufferedReader()
 	response, err := reader.ReadString('\n')
 	if err != nil {
 		err = errors.Join(ErrReadFailed, err)
 		return
 	}
-	// Trim any extra whitespace from the response.
-	res = strings.TrimSpace(response)
+	res = trimControlLine(response)
+	// After a data block of the wrong length, the server parses the leftover bytes as further commands,
+	// so the connection is out of sync and must be replaced.
+	if res == "CLIENT_ERROR bad data chunk" {
+		conn.reconnect()
+	}
+	return
+}
+
+// send writes a command that has no response, such as a command with "noreply", without reading from the connection.
+// The connection is marked unsynced, so that the next command expecting a response first discards
+// the error lines the server may have sent for it (see sync); consecutive sends never wait for the server.
+// It returns ErrUnsupportedByProxy behind a proxy that cannot pass the meta no-op sync relies on.
+func (s *Server) send(cmd string) (err error) {
+	if err = s.checkText(cmd); err != nil {
+		return
+	}
+	// Without the barrier, the error lines of the command would be taken for the responses of later commands.
+	if err = s.checkProxy("mn"); err != nil {
+		return
+	}
+	conn, err := s.pool.get()
+	if err != nil {
+		return
+	}
+	defer s.release(conn, &err)
+	defer s.observe(time.Now(), &err)
+
+	_, err = conn.Write([]byte(cmd))
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	conn.unsynced = true
 	return
 }
 
@@ -59,8 +255,19 @@ func (s *Server) WriteCommand(cmd string) (res string, err error) {
 // If withCAS is true, it sends a "gets" command to also retrieve the CAS token; otherwise, it uses "get".
 // It returns the value, CAS token (if requested), and an error if any.
 func (s *Server) GetValue(key string, withCAS bool) (value string, cas uint64, err error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if s.binary {
+		item, err := s.binaryGet(key)
+		if err != nil {
+			return "", 0, err
+		}
+		return string(item.Value), item.CAS, nil
+	}
+	conn, err := s.acquire()
+	if err != nil {
+		return
+	}
+	defer s.release(conn, &err)
+	defer s.observe(time.Now(), &err)
 
 	// Determine the command based on whether CAS is needed.
 	var cmd string
@@ -69,19 +276,511 @@ func (s *Server) GetValue(key string, withCAS bool) (value string, cas uint64, e
 	} else {
 		cmd = fmt.Sprintf("get %s\r\n", key)
 	}
-	_, err = s.conn.Write([]byte(cmd))
+	_, err = conn.Write([]byte(cmd))
 	if err != nil {
 		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
 
-	reader := bufio.NewReader(s.conn)
+	reader := conn.bufferedReader()
 	line, err := reader.ReadString('\n')
 	if err != nil {
 		err = errors.Join(ErrReadFailed, err)
 		return
 	}
-	line = strings.TrimSpace(line)
+	header, byteCount, err := parseValueLine(line, withCAS)
+	if err != nil {
+		return
+	}
+	cas = header.CAS
+	// Read the data block which includes the terminating "\r\n".
+	// A large block arrives over several reads, so a single Read could return only part of it.
+	data := make([]byte, byteCount+2)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	if string(data[byteCount:]) != "\r\n" {
+		err = ErrUnexpectedResponse
+		return
+	}
+	// Extract the actual value by trimming the trailing "\r\n".
+	value = string(data[:byteCount])
+	// Read the terminating "END" line.
+	endLine, err := reader.ReadString('\n')
+	if err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	if trimControlLine(endLine) != "END" {
+		err = ErrUnexpectedResponse
+		return
+	}
+	return
+}
+
+// GetStream retrieves the value associated with the given key and writes it to dst in chunks
+// instead of allocating the whole value in memory.
+// It validates the byte count and the terminating "END" line, and returns the number of bytes written and an error if any.
+// An error writing to dst is returned as is, once the rest of the value was read and discarded: it tells nothing
+// about the server, so it neither counts against its circuit breaker nor discards the connection.
+func (s *Server) GetStream(key string, dst io.Writer) (n int64, err error) {
+	dest := &streamDestination{w: dst}
+	if n, err = s.getStream(key, dest); err == nil {
+		err = dest.err
+	}
+	return
+}
+
+// streamDestination records the first error of the destination of GetStream,
+// so that it can be told apart from the errors of the server.
+type streamDestination struct {
+	w   io.Writer
+	err error
+}
+
+func (d *streamDestination) Write(p []byte) (n int, err error) {
+	if n, err = d.w.Write(p); err != nil && d.err == nil {
+		d.err = err
+	}
+	return
+}
+
+// getStream is GetStream without the error of the destination, which is recorded by dest instead.
+func (s *Server) getStream(key string, dest *streamDestination) (n int64, err error) {
+	if err = s.checkText("get"); err != nil {
+		return
+	}
+	conn, err := s.acquire()
+	if err != nil {
+		return
+	}
+	defer s.release(conn, &err)
+	defer s.observe(time.Now(), &err)
+
+	// get <key>\r\n
+	cmd := fmt.Sprintf("get %s\r\n", key)
+	_, err = conn.Write([]byte(cmd))
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+
+	reader := conn.bufferedReader()
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	_, byteCount, err := parseValueLine(line, false)
+	if err != nil {
+		return
+	}
+	// Copy the data block straight to the destination.
+	block := &io.LimitedReader{R: reader, N: int64(byteCount)}
+	n, err = io.Copy(dest, block)
+	if dest.err != nil {
+		// The rest of the data block is drained, so that the connection stays in sync.
+		_, err = io.Copy(io.Discard, block)
+	}
+	if err == nil && block.N > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		// The rest of the data block is still pending on the connection, so it can no longer be used.
+		conn.reconnect()
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	// Read the "\r\n" terminating the data block.
+	trailer := make([]byte, 2)
+	_, err = io.ReadFull(reader, trailer)
+	if err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	if string(trailer) != "\r\n" {
+		err = ErrUnexpectedResponse
+		return
+	}
+	// Read the terminating "END" line.
+	endLine, err := reader.ReadString('\n')
+	if err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	if trimControlLine(endLine) != "END" {
+		err = ErrUnexpectedResponse
+		return
+	}
+	return
+}
+
+// SetStream stores exactly length bytes read from r under the given key using a "set" command,
+// without buffering the whole value in memory. The expiration parameter specifies the time until the key expires.
+// It returns ErrLengthMismatch if r provides fewer or more than length bytes, and the raw response line otherwise.
+func (s *Server) SetStream(key string, r io.Reader, length int, expiration int) (res string, err error) {
+	if length < 0 {
+		err = ErrLengthMismatch
+		return
+	}
+	if err = s.checkText("set"); err != nil {
+		return
+	}
+	conn, err := s.acquire()
+	if err != nil {
+		return
+	}
+	defer s.release(conn, &err)
+	defer s.observe(time.Now(), &err)
+
+	// set <key> <flags> <exptime> <bytes>\r\n<data>\r\n
+	header := fmt.Sprintf("set %s 0 %d %d\r\n", key, expiration, length)
+	_, err = conn.Write([]byte(header))
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	// The data block is copied to the underlying connection directly,
+	// because retrying a chunk on a new connection would corrupt the framing.
+	n, err := io.CopyN(deadlineWriter{conn}, r, int64(length))
+	if err != nil {
+		// The server is still waiting for the rest of the data block, so the connection can no longer be used.
+		conn.reconnect()
+		if n < int64(length) && errors.Is(err, io.EOF) {
+			err = ErrLengthMismatch
+			return
+		}
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	// A reader providing more than length bytes is rejected as well. The server is still waiting for the terminator
+	// of the data block, so closing the connection discards the value instead of storing a truncated one.
+	var probe [1]byte
+	if extra, perr := io.ReadFull(r, probe[:]); extra > 0 || !errors.Is(perr, io.EOF) {
+		conn.reconnect()
+		err = ErrLengthMismatch
+		if extra == 0 {
+			err = errors.Join(ErrLengthMismatch, perr)
+		}
+		return
+	}
+	_, err = conn.write([]byte("\r\n"))
+	if err != nil {
+		conn.reconnect()
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+
+	reader := conn.bufferedReader()
+	response, err := reader.ReadString('\n')
+	if err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	res = trimControlLine(response)
+	return
+}
+
+// do sends a raw command to the memcached server and passes a buffered reader of the connection to read.
+// The connection stays locked until read returns, so the response is consumed by the caller that sent the command.
+func (s *Server) do(cmd []byte, read func(reader *bufio.Reader) error) (err error) {
+	return s.doContext(context.Background(), cmd, read)
+}
+
+// doContext is do for a request that is aborted once ctx is done (see roundTripContext).
+func (s *Server) doContext(ctx context.Context, cmd []byte, read func(reader *bufio.Reader) error) (err error) {
+	if err = s.checkText(string(cmd)); err != nil {
+		return
+	}
+	return s.roundTripContext(ctx, cmd, read)
+}
+
+// roundTrip writes a raw request of either protocol to the memcached server and passes a buffered reader of the connection to read.
+func (s *Server) roundTrip(req []byte, read func(reader *bufio.Reader) error) (err error) {
+	return s.roundTripContext(context.Background(), req, read)
+}
+
+// roundTripContext is roundTrip for a request that is aborted once ctx is done, e.g. by WithBatchFailFast.
+// Aborting closes the socket, which unblocks a pending write or read; the request is not resent on a new connection,
+// and the connection is discarded by release. It returns ErrBatchAborted joined with the error of ctx if the request was aborted.
+func (s *Server) roundTripContext(ctx context.Context, req []byte, read func(reader *bufio.Reader) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		err = errors.Join(ErrBatchAborted, err)
+		return
+	}
+	conn, err := s.acquire()
+	if err != nil {
+		return
+	}
+	defer s.release(conn, &err)
+	if ctx.Done() == nil {
+		return s.exchange(conn, req, read)
+	}
+	defer s.observe(time.Now(), &err)
+	if err = conn.connect(); err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	raw := conn.conn
+	defer context.AfterFunc(ctx, func() {
+		raw.Close()
+	})()
+	// The failure caused by the abort is replaced before it is observed, so that it does not count against the server.
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = errors.Join(ErrBatchAborted, ctx.Err())
+		}
+	}()
+
+	_, err = conn.writeOnce(req)
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	return read(conn.bufferedReader())
+}
+
+// exchange is roundTrip for callers that already acquired a connection.
+func (s *Server) exchange(conn *Conn, req []byte, read func(reader *bufio.Reader) error) (err error) {
+	defer s.observe(time.Now(), &err)
+
+	_, err = conn.Write(req)
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	return read(conn.bufferedReader())
+}
+
+// readLine reads a single control line of a response and returns it trimmed (see trimControlLine).
+func readLine(reader *bufio.Reader) (line string, err error) {
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	line = trimControlLine(line)
+	return
+}
+
+// trimControlLine removes the line terminator and surrounding whitespace from a control line,
+// such as a status line, a "VALUE" header or a "STAT" line.
+// Data blocks are read by their length instead, and are never trimmed: leading and trailing spaces belong to the value.
+func trimControlLine(line string) string {
+	return strings.TrimSpace(line)
+}
+
+// hasStatus reports whether a control line reports the given status keyword, such as "OK".
+// The keyword must be the first token of the line: some compatible servers and proxies append further tokens to it,
+// while error responses such as "ERROR", "CLIENT_ERROR <error>" or "SERVER_ERROR <error>" never match.
+func hasStatus(line, status string) bool {
+	token, _, _ := strings.Cut(line, " ")
+	return token == status
+}
+
+// trimTerminator removes only the line terminator from a line that may carry value bytes.
+func trimTerminator(line string) string {
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r")
+}
+
+// GetItem retrieves the item stored under the given key, including its flags.
+// If withCAS is true, it sends a "gets" command to also retrieve the CAS token; otherwise, it uses "get".
+// It returns ErrNotFound if the key does not exist.
+func (s *Server) GetItem(key string, withCAS bool) (item *Item, err error) {
+	if s.binary {
+		// Binary responses always carry the CAS token.
+		return s.binaryGet(key)
+	}
+	// get <key>\r\n or gets <key>\r\n
+	cmd := fmt.Sprintf("get %s\r\n", key)
+	if withCAS {
+		cmd = fmt.Sprintf("gets %s\r\n", key)
+	}
+	err = s.do([]byte(cmd), func(reader *bufio.Reader) (err error) {
+		item, err = readItem(reader, withCAS)
+		return
+	})
+	return
+}
+
+// getAndTouch retrieves the item stored under the given key and updates its expiration time with a "gat" command.
+// On servers without "gat" support it falls back to a "touch" and a "get".
+// It returns ErrNotFound if the key does not exist.
+func (s *Server) getAndTouch(key string, expiration int) (item *Item, err error) {
+	if s.binary {
+		return s.binaryGetAndTouch(key, expiration)
+	}
+	// gat <exptime> <key>\r\n
+	items, err := s.retrieve([]string{fmt.Sprintf("gat %d %s\r\n", expiration, key)}, false)
+	if errors.Is(err, ErrUnsupportedCommand) {
+		items, err = touchAndGet(s, []string{key}, expiration)
+	}
+	if err != nil {
+		return
+	}
+	if len(items) == 0 {
+		err = ErrNotFound
+		return
+	}
+	item = items[0]
+	return
+}
+
+// retrieve sends retrieval commands such as "get", "gets" or "gat" for any number of keys and reads every returned item.
+// Several commands, e.g. the chunks of a large multi-key retrieval (see Client.multiGetCommands), are written at once
+// and their responses read in order, in a single round trip. Keys that do not exist are simply absent from the result.
+// It returns ErrUnsupportedCommand if the server does not know the command.
+func (s *Server) retrieve(cmds []string, withCAS bool) (items []*Item, err error) {
+	err = s.do([]byte(strings.Join(cmds, "")), func(reader *bufio.Reader) error {
+		return readResponses(reader, len(cmds), func() error {
+			found, err := readItems(reader, withCAS)
+			items = append(items, found...)
+			return err
+		})
+	})
+	return
+}
+
+// retrieveEach sends retrieval commands like retrieve, but passes every item to fn as soon as its "VALUE" block is read
+// instead of collecting them. The data blocks are read into one scratch buffer grown to the largest block, so the Value of
+// an item is only valid until fn returns; fn must copy whatever it keeps.
+// This keeps the memory of a multi-key retrieval bounded by the results the caller keeps, however many blocks it returns.
+func (s *Server) retrieveEach(ctx context.Context, cmds []string, withCAS bool, fn func(item *Item)) (err error) {
+	return s.doContext(ctx, []byte(strings.Join(cmds, "")), func(reader *bufio.Reader) error {
+		var scratch []byte
+		return readResponses(reader, len(cmds), func() error {
+			return eachItem(reader, func(line string) (err error) {
+				var item *Item
+				if item, scratch, err = readItemBlockInto(reader, line, withCAS, scratch); err == nil {
+					fn(item)
+				}
+				return
+			})
+		})
+	})
+}
+
+// readResponses calls read for each of n pipelined commands.
+// A server that does not know a command answers each of them with a single "ERROR" line, so after ErrUnsupportedCommand
+// the lines of the remaining commands are read too, to leave the connection in sync.
+func readResponses(reader *bufio.Reader, n int, read func() error) (err error) {
+	for i := range n {
+		if err = read(); err == nil {
+			continue
+		}
+		if errors.Is(err, ErrUnsupportedCommand) {
+			for range n - i - 1 {
+				if _, lerr := readLine(reader); lerr != nil {
+					return lerr
+				}
+			}
+		}
+		return
+	}
+	return
+}
+
+// readItems reads the "VALUE" blocks of a retrieval command until the terminating "END" line.
+func readItems(reader *bufio.Reader, withCAS bool) (items []*Item, err error) {
+	err = eachItem(reader, func(line string) (err error) {
+		item, err := readItemBlock(reader, line, withCAS)
+		if err == nil {
+			items = append(items, item)
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// eachItem reads the lines of a retrieval response until the terminating "END" line,
+// calling block for every "VALUE" header line to read the data block that follows it.
+func eachItem(reader *bufio.Reader, block func(line string) error) (err error) {
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return nil
+		}
+		if line == "ERROR" {
+			return ErrUnsupportedCommand
+		}
+		if err = block(line); err != nil {
+			return err
+		}
+	}
+}
+
+// readItemBlock parses a "VALUE" header line and reads the data block that follows it.
+func readItemBlock(reader *bufio.Reader, line string, withCAS bool) (item *Item, err error) {
+	item, _, err = readItemBlockInto(reader, line, withCAS, nil)
+	return
+}
+
+// readItemBlockInto is readItemBlock reading the data block into buf, which is grown to fit the block and returned.
+// The Value of the item aliases buf. On any error, the item is nil, so that a partially read item cannot be used.
+func readItemBlockInto(reader *bufio.Reader, line string, withCAS bool, buf []byte) (item *Item, out []byte, err error) {
+	parsed, byteCount, err := parseValueLine(line, withCAS)
+	if err != nil {
+		return
+	}
+	// Read the data block which includes the terminating "\r\n".
+	if cap(buf) < byteCount+2 {
+		buf = make([]byte, byteCount+2)
+	}
+	out = buf
+	data := buf[:byteCount+2]
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	if string(data[byteCount:]) != "\r\n" {
+		err = ErrUnexpectedResponse
+		return
+	}
+	parsed.Value = data[:byteCount]
+	item = parsed
+	return
+}
+
+// readItem reads the response of a single-key "get" or "gets" command, including the terminating "END" line.
+// It returns ErrNotFound if the key does not exist.
+func readItem(reader *bufio.Reader, withCAS bool) (item *Item, err error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return
+	}
+	item, err = readItemBlock(reader, line, withCAS)
+	if err != nil {
+		return
+	}
+	// Read the terminating "END" line. Without it, the item is not returned, like readItemBlock does on errors.
+	endLine, err := readLine(reader)
+	if err == nil && endLine != "END" {
+		err = ErrUnexpectedResponse
+	}
+	if err != nil {
+		item = nil
+	}
+	return
+}
+
+// parseValueLine parses a "VALUE <key> <flags> <bytes> [<cas unique>]" response header line.
+// It returns an Item holding the key, flags and CAS token, along with the length of the data block that follows.
+// It returns ErrNotFound if the line is the "END" marker of an empty result.
+// If withCAS is true, the CAS token is also parsed; otherwise it is zero.
+// A malformed header is reported as ErrUnexpectedResponse: the length of the data block that follows is unknown,
+// so the connection must be discarded.
+func parseValueLine(line string, withCAS bool) (item *Item, byteCount int, err error) {
+	line = trimControlLine(line)
 	// If the response indicates the key was not found, return an error.
 	if line == "END" {
 		err = ErrNotFound
@@ -93,42 +792,34 @@ func (s *Server) GetValue(key string, withCAS bool) (value string, cas uint64, e
 		err = ErrUnexpectedResponse
 		return
 	}
+	flags, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		err = errors.Join(ErrUnexpectedResponse, err)
+		return
+	}
+	item = &Item{
+		Key:   parts[1],
+		Flags: uint32(flags),
+	}
 	// If CAS is requested, extract the CAS token.
 	if withCAS {
 		if len(parts) < 5 {
 			err = ErrUnexpectedResponse
 			return
 		}
-		cas, err = strconv.ParseUint(parts[4], 10, 64)
+		item.CAS, err = strconv.ParseUint(parts[4], 10, 64)
 		if err != nil {
-			err = errors.Join(ErrInternal, err)
+			err = errors.Join(ErrUnexpectedResponse, err)
 			return
 		}
 	}
-
 	// Determine the length of the data block.
-	byteCount, err := strconv.Atoi(parts[3])
-	if err != nil {
-		err = errors.Join(ErrInternal, err)
-		return
+	byteCount, err = strconv.Atoi(parts[3])
+	if err == nil && byteCount < 0 {
+		err = fmt.Errorf("negative length %d", byteCount)
 	}
-	// Read the data block which includes the terminating "\r\n".
-	data := make([]byte, byteCount+2)
-	_, err = reader.Read(data)
 	if err != nil {
-		err = errors.Join(ErrReadFailed, err)
-		return
-	}
-	// Extract the actual value by trimming the trailing "\r\n".
-	value = string(data[:byteCount])
-	// Read the terminating "END" line.
-	endLine, err := reader.ReadString('\n')
-	if err != nil {
-		err = errors.Join(ErrReadFailed, err)
-		return
-	}
-	if strings.TrimSpace(endLine) != "END" {
-		err = ErrUnexpectedResponse
+		err = errors.Join(ErrUnexpectedResponse, err)
 		return
 	}
 	return
@@ -137,17 +828,32 @@ func (s *Server) GetValue(key string, withCAS bool) (value string, cas uint64, e
 // GetStats sends a "stats" command to the memcached server to retrieve various statistics.
 // It returns a map of statistic keys to their values and an error if encountered.
 func (s *Server) GetStats() (stats map[string]string, err error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.GetStatsGroup("")
+}
+
+// GetStatsGroup sends a "stats <group>" command, e.g. "settings" or "slabs", to retrieve a group of statistics.
+// An empty group retrieves the general statistics like GetStats.
+// It returns a map of statistic keys to their values and an error if encountered.
+func (s *Server) GetStatsGroup(group string) (stats map[string]string, err error) {
+	if err = s.checkText("stats"); err != nil {
+		return
+	}
+	conn, err := s.acquire()
+	if err != nil {
+		return
+	}
+	defer s.release(conn, &err)
+	defer s.observe(time.Now(), &err)
 
-	cmd := "stats\r\n"
-	_, err = s.conn.Write([]byte(cmd))
+	// stats [<group>]\r\n
+	cmd := strings.TrimSpace("stats "+group) + "\r\n"
+	_, err = conn.Write([]byte(cmd))
 	if err != nil {
 		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
 
-	reader := bufio.NewReader(s.conn)
+	reader := conn.bufferedReader()
 	// Read each line until the "END" marker is found.
 	for {
 		line, err := reader.ReadString('\n')
@@ -155,12 +861,19 @@ func (s *Server) GetStats() (stats map[string]string, err error) {
 			err = errors.Join(ErrReadFailed, err)
 			return nil, err
 		}
-		line = strings.TrimSpace(line)
+		line = trimControlLine(line)
 		if line == "END" {
 			break
 		}
 		// Each stat line is expected to have the format: "STAT <key> <value>"
 		parts := strings.SplitN(line, " ", 3)
+		// Proxies add their own lines and may report stats without a value, so be lenient with them.
+		if s.proxy != "" {
+			if parts[0] != "STAT" || len(parts) < 2 {
+				continue
+			}
+			parts = append(parts, "")
+		}
 		if len(parts) < 3 {
 			err = ErrUnexpectedResponse
 			return nil, err
@@ -180,37 +893,283 @@ func (s *Server) GetStats() (stats map[string]string, err error) {
 	return
 }
 
-// Close terminates the connection to the memcached server.
+// Ping sends a "version" command to the memcached server and measures the round-trip time.
+// If timeout is positive, the connection deadline is set so that an unresponsive server returns an error instead of blocking.
+// It returns the measured latency and an error if any.
+func (s *Server) Ping(timeout time.Duration) (rtt time.Duration, err error) {
+	// Ping supports both protocols, so only the proxy is checked.
+	if err = s.checkProxy("version"); err != nil {
+		return
+	}
+	conn, err := s.acquire()
+	if err != nil {
+		return
+	}
+	defer s.release(conn, &err)
+
+	if timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			err = errors.Join(ErrInternal, err)
+			return
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	start := time.Now()
+	defer s.observe(start, &err)
+	if s.binary {
+		_, err = conn.Write((&binaryRequest{opcode: opVersion}).appendTo(nil))
+		if err != nil {
+			err = errors.Join(ErrWriteFailed, err)
+			return
+		}
+		var res *binaryResponse
+		res, err = readBinaryResponse(conn.bufferedReader())
+		if err != nil {
+			return
+		}
+		rtt = time.Since(start)
+		if res.opcode != opVersion || res.status != statusNoError {
+			err = ErrUnexpectedResponse
+		}
+		return
+	}
+	// version\r\n
+	_, err = conn.Write([]byte("version\r\n"))
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	reader := conn.bufferedReader()
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		err = errors.Join(ErrReadFailed, err)
+		return
+	}
+	rtt = time.Since(start)
+	if !strings.HasPrefix(line, "VERSION") {
+		err = ErrUnexpectedResponse
+		return
+	}
+	return
+}
+
+// Latency returns the moving average round-trip latency of commands sent to the server.
+// It returns zero if no command has completed yet.
+func (s *Server) Latency() time.Duration {
+	return s.latency.value()
+}
+
+// State returns the state of the circuit breaker of the server.
+// It is always BreakerClosed when the circuit breaker is disabled.
+func (s *Server) State() BreakerState {
+	if s.breaker == nil {
+		return BreakerClosed
+	}
+	return s.breaker.current()
+}
+
+// Healthy reports whether the last background health check of the server succeeded.
+// It is always true when health checks are disabled.
+func (s *Server) Healthy() bool {
+	return !s.unhealthy.Load()
+}
+
+// available reports whether commands may be sent to the server according to its health check and circuit breaker.
+func (s *Server) available() bool {
+	if s.unhealthy.Load() {
+		return false
+	}
+	return s.breaker == nil || s.breaker.allow()
+}
+
+// observe records the latency of a command that started at start and, if enabled, its outcome in the circuit breaker.
+// Only write and read failures count as failures; protocol-level errors such as ErrNotFound mean the server is up.
+// It is meant to be deferred with a pointer to the named error result of the command.
+func (s *Server) observe(start time.Time, err *error) {
+	s.latency.observe(time.Since(start))
+	if s.breaker == nil {
+		return
+	}
+	if isNetworkError(*err) {
+		s.breaker.failure()
+		return
+	}
+	// An aborted request tells nothing about the server.
+	if errors.Is(*err, ErrBatchAborted) {
+		return
+	}
+	s.breaker.success()
+}
+
+// isIdempotent reports whether cmd can safely be resent after a write failure.
+// Sending incr, decr, append or prepend twice would apply the change twice.
+func isIdempotent(cmd string) bool {
+	name, _, _ := strings.Cut(cmd, " ")
+	switch name {
+	case "incr", "decr", "append", "prepend":
+		return false
+	}
+	return true
+}
+
+// isNetworkError reports whether err means that the server could not be reached.
+func isNetworkError(err error) bool {
+	return errors.Is(err, ErrWriteFailed) || errors.Is(err, ErrReadFailed) || errors.Is(err, ErrServerUnavailable)
+}
+
+// Watch sends a "watch" command for the given event classes (e.g. "fetchers", "mutations", "evictions")
+// and passes every streamed log line to fn until fn returns false, ctx is cancelled, or the connection fails.
+// Because watch turns the connection into a stream, it uses a dedicated connection that is closed on return.
+// It returns ctx.Err() if the context was cancelled, and nil if fn stopped the stream.
+func (s *Server) Watch(ctx context.Context, classes []string, fn func(line string) bool) (err error) {
+	if err = s.checkText("watch"); err != nil {
+		return
+	}
+	conn, err := newConn(s.Address, s.pool.config)
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	defer conn.conn.Close()
+	// Closing the connection unblocks the pending read once the context is cancelled.
+	stop := context.AfterFunc(ctx, func() {
+		conn.conn.Close()
+	})
+	defer stop()
+
+	// watch [<class> ...]\r\n
+	cmd := strings.TrimSpace("watch "+strings.Join(classes, " ")) + "\r\n"
+	// The dedicated connection is written to directly so that a failure is never retried on a new connection.
+	_, err = conn.conn.Write([]byte(cmd))
+	if err != nil {
+		err = errors.Join(ErrWriteFailed, err)
+		return
+	}
+	reader := bufio.NewReader(conn.conn)
+	line, err := readLine(reader)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return
+	}
+	if !hasStatus(line, "OK") {
+		err = ErrUnexpectedResponse
+		return
+	}
+	for {
+		line, err = readLine(reader)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return
+		}
+		if !fn(line) {
+			return
+		}
+	}
+}
+
+// checkText returns an error if the text protocol command cmd cannot be sent to the server.
+// The server fixes the protocol of a connection on its first byte, so a connection in binary mode must never receive text commands.
+func (s *Server) checkText(cmd string) error {
+	if s.binary {
+		return ErrUnsupportedByProtocol
+	}
+	return s.checkProxy(cmd)
+}
+
+// Close terminates the connections to the memcached server.
+// It waits for the commands in progress, if any, and closing an already closed server is a no-op.
+// Commands sent after Close return ErrClosed.
 func (s *Server) Close() {
-	s.conn.conn.Close()
+	s.pool.close()
 }
 
 // Extra sends a custom command (cmd) to the memcached server and collects multi-line responses.
 // It continues reading until an "END" line is encountered, then returns the concatenated response or an error.
+// Only the line terminators are removed, so that data blocks in the response keep their leading and trailing spaces.
+// Commands answered by a single line never send "END", so they must be sent with ExtraLine instead.
+// A command the server does not know is answered by a bare "ERROR" line and reported as ErrUnsupportedCommand,
+// and a command the server rejects with "CLIENT_ERROR" or "SERVER_ERROR" is reported as ErrCommandFailed.
+// It returns ErrDeleteTime without sending the command if cmd is a "delete" command with a time argument.
+// The whole command is bounded by WithExtraTimeout of the client the server belongs to.
 func (s *Server) Extra(cmd string) (res string, err error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.extra(cmd, readExtraLines)
+}
+
+// ExtraLine sends a custom command answered by a single line, such as "version" or "verbosity", and returns that line
+// trimmed as a control line (see trimControlLine). Unlike Extra, it does not wait for an "END" line.
+// It returns ErrDeleteTime without sending the command if cmd is a "delete" command with a time argument.
+// Like Extra, it is bounded by WithExtraTimeout.
+func (s *Server) ExtraLine(cmd string) (res string, err error) {
+	return s.extra(cmd, readLine)
+}
 
-	_, err = s.conn.Write([]byte(cmd))
+// extra sends a custom command and reads its response with read, bounded by the Extra timeout of the server if it is positive.
+func (s *Server) extra(cmd string, read func(reader *bufio.Reader) (string, error)) (res string, err error) {
+	if err = s.checkText(cmd); err != nil {
+		return
+	}
+	if err = checkDeleteForm(cmd); err != nil {
+		return
+	}
+	conn, err := s.acquire()
+	if err != nil {
+		return
+	}
+	defer s.release(conn, &err)
+
+	if s.extraTimeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(s.extraTimeout)); err != nil {
+			err = errors.Join(ErrInternal, err)
+			return
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	_, err = conn.Write([]byte(cmd))
 	if err != nil {
 		err = errors.Join(ErrWriteFailed, err)
 		return
 	}
+	return read(conn.bufferedReader())
+}
 
-	reader := bufio.NewReader(s.conn)
-	// Read lines until "END" is encountered.
-	for {
+// readExtraLines reads response lines until an "END" line and returns them joined with "\n".
+// Error responses consist of a single line without "END", so they are returned as errors as soon as they are read.
+func readExtraLines(reader *bufio.Reader) (res string, err error) {
+	for first := true; ; first = false {
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			err = errors.Join(ErrReadFailed, err)
 			return res, err
 		}
-		line = strings.TrimSpace(line)
+		line = trimTerminator(line)
 		if line == "END" {
-			break
+			return res, nil
+		}
+		if first {
+			if err = errorResponse(line); err != nil {
+				return "", err
+			}
 		}
 		res += line + "\n"
 	}
+}
 
-	return
+// errorResponse maps the error responses a server sends instead of the response of a command to errors:
+// "ERROR" for an unknown command is ErrUnsupportedCommand, and "CLIENT_ERROR <error>" or "SERVER_ERROR <error>"
+// is ErrCommandFailed joined with the line. It returns nil for any other line.
+func errorResponse(line string) error {
+	line = trimControlLine(line)
+	if line == "ERROR" {
+		return ErrUnsupportedCommand
+	}
+	if hasStatus(line, "CLIENT_ERROR") || hasStatus(line, "SERVER_ERROR") {
+		return errors.Join(ErrCommandFailed, errors.New(line))
+	}
+	return nil
 }
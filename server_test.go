@@ -0,0 +1,547 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingWriter accepts limit bytes and then fails every write with err.
+type failingWriter struct {
+	limit int
+	err   error
+	buf   bytes.Buffer
+}
+
+func (w *failingWriter) Write(p []byte) (n int, err error) {
+	if room := w.limit - w.buf.Len(); len(p) > room {
+		w.buf.Write(p[:room])
+		return room, w.err
+	}
+	return w.buf.Write(p)
+}
+
+func TestGetStreamDestinationError(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithCircuitBreaker(1, time.Minute))
+	value := strings.Repeat("x", 100<<10)
+	if err := c.Set("key", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	errDisk := errors.New("disk full")
+	dst := &failingWriter{limit: 1000, err: errDisk}
+	n, err := c.GetStream("key", dst)
+	if !errors.Is(err, errDisk) || errors.Is(err, ErrReadFailed) {
+		t.Fatalf("GetStream() error = %v, want the error of the destination only", err)
+	}
+	if n != 1000 {
+		t.Errorf("GetStream() = %d bytes, want 1000", n)
+	}
+	// The connection stayed in sync and the server is still considered healthy.
+	got, err := c.Get("key")
+	if err != nil || got != value {
+		t.Fatalf("Get() = %d bytes, %v; want the stored value", len(got), err)
+	}
+	if accepted := s.accepted.Load(); accepted != 1 {
+		t.Errorf("server accepted %d connections, want 1", accepted)
+	}
+}
+
+func TestSetStreamLengthMismatch(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	tests := []struct {
+		name   string
+		value  string
+		length int
+		err    error
+	}{
+		{"exact", "hello", 5, nil},
+		{"short", "hell", 5, ErrLengthMismatch},
+		{"long", "hello!", 5, ErrLengthMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "stream-" + tt.name
+			err := c.SetStream(key, strings.NewReader(tt.value), tt.length, 0)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("SetStream() error = %v, want %v", err, tt.err)
+			}
+			got, err := c.Get(key)
+			if tt.err != nil {
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get() = %q, %v; want ErrNotFound after a rejected stream", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.value {
+				t.Errorf("Get() = %q, %v; want %q", got, err, tt.value)
+			}
+		})
+	}
+}
+
+// slowReader delivers n chunks of size bytes, waiting delay before each of them.
+type slowReader struct {
+	n, size int
+	delay   time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (n int, err error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	r.n--
+	time.Sleep(r.delay)
+	return copy(p, bytes.Repeat([]byte("x"), r.size)), nil
+}
+
+// zeroReader is an endless source of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (n int, err error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestSetStreamSlowSource(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"WithWriteTimeout": WithWriteTimeout(100 * time.Millisecond),
+		"WithTimeout":      WithTimeout(100 * time.Millisecond),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newFakeServer(t)
+			c := newTestClient(t, []string{s.addr()}, opt)
+			// Copying the whole data block takes longer than the timeout, but no single write does.
+			src := &slowReader{n: 8, size: 1024, delay: 30 * time.Millisecond}
+			start := time.Now()
+			if err := c.SetStream("key", src, 8*1024, 0); err != nil {
+				t.Fatalf("SetStream() error = %v, want nil for a source slower than the timeout", err)
+			}
+			if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+				t.Fatalf("SetStream() took %v, want longer than the timeout", elapsed)
+			}
+			if got, err := c.Get("key"); err != nil || got != strings.Repeat("x", 8*1024) {
+				t.Errorf("Get() = %d bytes, %v; want %d", len(got), err, 8*1024)
+			}
+		})
+	}
+}
+
+func TestSetStreamUnresponsiveServer(t *testing.T) {
+	t.Run("never reads", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				t.Cleanup(func() { conn.Close() })
+			}
+		}()
+		c := newTestClient(t, []string{ln.Addr().String()}, WithWriteTimeout(100*time.Millisecond))
+		// The data block is larger than the socket buffers, so the writes block once they are full.
+		const length = 64 << 20
+		start := time.Now()
+		err = c.SetStream("key", io.LimitReader(zeroReader{}, length), length, 0)
+		if !errors.Is(err, ErrWriteFailed) || !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("SetStream() error = %v, want a write timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("SetStream() gave up after %v", elapsed)
+		}
+	})
+	t.Run("never replies", func(t *testing.T) {
+		addr := newScriptedServer(t, func(line string) string { return "" })
+		c := newTestClient(t, []string{addr}, WithReadTimeout(100*time.Millisecond))
+		err := c.SetStream("key", strings.NewReader("value"), 5, 0)
+		if !errors.Is(err, ErrReadFailed) || !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("SetStream() error = %v, want a read timeout", err)
+		}
+	})
+}
+
+func TestGetDesyncUsesFreshConnection(t *testing.T) {
+	var gets atomic.Int64
+	addr := newScriptedServer(t, func(line string) string {
+		if gets.Add(1) == 1 {
+			// The data block is longer than its header says, so the rest of it is still pending after the value.
+			return "VALUE key 0 5\r\nvalue-and-more\r\nEND\r\n"
+		}
+		return "VALUE key 0 5\r\nvalue\r\nEND\r\n"
+	})
+	c := newTestClient(t, []string{addr}, WithSingleConnection())
+	if _, err := c.Get("key"); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Fatalf("Get() error = %v, want ErrUnexpectedResponse", err)
+	}
+	// The pending bytes would be read as the response of the next command on the same connection.
+	if got, err := c.Get("key"); err != nil || got != "value" {
+		t.Fatalf("Get() = %q, %v after a desync; want %q", got, err, "value")
+	}
+}
+
+func TestServerExtraTimeout(t *testing.T) {
+	// The server reads the commands but never answers them.
+	addr := newScriptedServer(t, func(line string) string { return "" })
+	c := newTestClient(t, []string{addr}, WithExtraTimeout(100*time.Millisecond))
+	server := c.servers[0]
+	for name, extra := range map[string]func(cmd string) (string, error){"Extra": server.Extra, "ExtraLine": server.ExtraLine} {
+		start := time.Now()
+		if _, err := extra("stats\r\n"); !errors.Is(err, ErrReadFailed) {
+			t.Errorf("%s() error = %v, want ErrReadFailed", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s() returned after %v, want about 100ms", name, elapsed)
+		}
+	}
+	for name, extra := range map[string]func(addr, cmd string) (string, error){"Extra": c.Extra, "ExtraLine": c.ExtraLine} {
+		if _, err := extra(addr, "stats\r\n"); !errors.Is(err, ErrWriteFailed) {
+			t.Errorf("Client.%s() error = %v after a timeout, want ErrWriteFailed", name, err)
+		}
+	}
+}
+
+func TestClientExtraErrorResponses(t *testing.T) {
+	addr := newScriptedServer(t, func(line string) string {
+		if line == "bogus" {
+			return "ERROR\r\n"
+		}
+		return "CLIENT_ERROR bad command line format\r\n"
+	})
+	c := newTestClient(t, []string{addr})
+	tests := []struct {
+		cmd  string
+		want error
+	}{
+		{"bogus\r\n", ErrUnsupportedCommand},
+		{"stats bogus\r\n", ErrCommandFailed},
+	}
+	for _, tt := range tests {
+		_, err := c.Extra(addr, tt.cmd)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Extra(%q) error = %v, want %v", tt.cmd, err, tt.want)
+		}
+		// The command was sent and answered.
+		if errors.Is(err, ErrWriteFailed) {
+			t.Errorf("Extra(%q) error = %v, want no ErrWriteFailed", tt.cmd, err)
+		}
+	}
+}
+
+func TestHasStatus(t *testing.T) {
+	tests := []struct {
+		line   string
+		status string
+		want   bool
+	}{
+		{"OK", "OK", true},
+		{"OK flushed 3 items", "OK", true},
+		{"TOUCHED", "TOUCHED", true},
+		{"OKAY", "OK", false},
+		{"ERROR", "OK", false},
+		{"CLIENT_ERROR OK", "OK", false},
+		{"SERVER_ERROR out of memory", "OK", false},
+		{"", "OK", false},
+	}
+	for _, tt := range tests {
+		if got := hasStatus(tt.line, tt.status); got != tt.want {
+			t.Errorf("hasStatus(%q, %q) = %v, want %v", tt.line, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestStatusResponses(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		resp string
+		ok   bool
+	}{
+		{"strict", "OK\r\n", true},
+		{"lenient", "OK proxied\r\n", true},
+		{"error", "ERROR\r\n", false},
+		{"client error", "CLIENT_ERROR bad command line format\r\n", false},
+		{"server error", "SERVER_ERROR out of memory\r\n", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := newScriptedServer(t, func(line string) string {
+				if strings.HasPrefix(line, "touch ") && tt.resp == "OK\r\n" {
+					return "TOUCHED\r\n"
+				}
+				return tt.resp
+			})
+			c := newTestClient(t, []string{addr})
+			for name, err := range map[string]error{
+				"FlushAll":  c.FlushAll(0),
+				"Verbosity": c.Verbosity(1),
+				"Touch":     c.Touch("key", 10),
+			} {
+				if (err == nil) != tt.ok {
+					t.Errorf("%s() error = %v for %q", name, err, tt.resp)
+				}
+			}
+		})
+	}
+}
+
+func TestTouchStatusesAgree(t *testing.T) {
+	for _, tt := range []struct {
+		resp string
+		ok   bool
+	}{
+		{"TOUCHED", true},
+		{"OK", true},
+		{"NOT_FOUND", false},
+		{"ERROR", false},
+	} {
+		t.Run(tt.resp, func(t *testing.T) {
+			addr := newScriptedServer(t, func(line string) string { return tt.resp + "\r\n" })
+			c := newTestClient(t, []string{addr})
+			p := c.Pipeline()
+			p.Touch("key", 10)
+			results, _ := p.Exec()
+			// The same status is reported alike by the client and by a pipeline.
+			for name, err := range map[string]error{"Client.Touch": c.Touch("key", 10), "Pipeline.Touch": results[0].Err} {
+				if (err == nil) != tt.ok {
+					t.Errorf("%s() error = %v for %q", name, err, tt.resp)
+				}
+			}
+		})
+	}
+}
+
+func TestReadItemErrorsReturnNoItem(t *testing.T) {
+	for _, resp := range []string{
+		"VALUE key 0 5\r\nvalue\r\nBOGUS\r\n",
+		"VALUE key 0 5\r\nvalue\r\n",
+		"VALUE key 0 5\r\nval",
+		"VALUE key 0 5\r\nvalueXX",
+	} {
+		item, err := readItem(bufio.NewReader(strings.NewReader(resp)), false)
+		if err == nil || item != nil {
+			t.Errorf("readItem(%q) = %v, %v; want a nil item and an error", resp, item, err)
+		}
+	}
+}
+
+func TestVerifyOnConnectHTTPServer(t *testing.T) {
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(h.Close)
+	addr := h.Listener.Addr().String()
+	for name, opts := range map[string][]Option{
+		"text":   {WithServers(addr), WithVerifyOnConnect()},
+		"binary": {WithServers(addr), WithVerifyOnConnect(), WithBinaryProtocol()},
+	} {
+		start := time.Now()
+		if _, err := NewClientWithOptions(opts...); !errors.Is(err, ErrNotMemcached) {
+			t.Errorf("%s: NewClientWithOptions() error = %v for an HTTP server, want ErrNotMemcached", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > defaultPingTimeout+time.Second {
+			t.Errorf("%s: NewClientWithOptions() took %v", name, elapsed)
+		}
+	}
+	s := newFakeServer(t)
+	newTestClient(t, []string{s.addr()}, WithVerifyOnConnect())
+}
+
+func TestExtraUnknownCommand(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	server := c.servers[0]
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.Extra("bogus\r\n")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrUnsupportedCommand) {
+			t.Fatalf("Extra() error = %v, want ErrUnsupportedCommand", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Extra() kept waiting for END after ERROR")
+	}
+	// The connection is still in sync.
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := server.Extra("get key\r\n"); err != nil || res != "VALUE key 0 5\nvalue\n" {
+		t.Errorf("Extra() = %q, %v after ERROR", res, err)
+	}
+}
+
+func TestGetValueLarge(t *testing.T) {
+	value := strings.Repeat("0123456789abcdef", 4<<10)
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	if err := c.Set("large", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("large"); err != nil || got != value {
+		t.Fatalf("Get() = %d bytes, %v; want the %d bytes stored", len(got), err, len(value))
+	}
+	// The data block arrives over many reads, each shorter than the buffer of the reader.
+	resp := "VALUE large 0 65536\r\n" + value + "\r\nEND\r\n"
+	var fragments []string
+	for len(resp) > 0 {
+		n := min(1000, len(resp))
+		fragments = append(fragments, resp[:n])
+		resp = resp[n:]
+	}
+	server := newServerOn(&fragmentedConn{fragments: fragments})
+	if got, _, err := server.GetValue("large", false); err != nil || got != value {
+		t.Fatalf("GetValue() = %d bytes, %v; want the %d bytes of the fragmented response", len(got), err, len(value))
+	}
+}
+
+func TestGetThenStats(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()}, WithSingleConnection())
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	server := c.servers[0]
+	for range 3 {
+		if got, _, err := server.GetValue("key", false); err != nil || got != "value" {
+			t.Fatalf("GetValue() = %q, %v; want %q", got, err, "value")
+		}
+		stats, err := server.GetStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats["version"] != "1.6.21" {
+			t.Fatalf("GetStats() = %v after a get, want the stats of the server", stats)
+		}
+	}
+}
+
+func TestGetThenStatsReadAhead(t *testing.T) {
+	// The responses to both commands arrive in a single read, so the reader of the get buffers the stats response too.
+	conn := &fragmentedConn{fragments: []string{"VALUE key 0 5\r\nvalue\r\nEND\r\nSTAT pid 42\r\nSTAT version 1.6.21\r\nEND\r\n"}}
+	server := newServerOn(conn)
+	if got, _, err := server.GetValue("key", false); err != nil || got != "value" {
+		t.Fatalf("GetValue() = %q, %v; want %q", got, err, "value")
+	}
+	stats, err := server.GetStats()
+	if err != nil {
+		t.Fatalf("GetStats() error = %v; the bytes read ahead by the get were lost", err)
+	}
+	if stats["pid"] != "42" || stats["version"] != "1.6.21" {
+		t.Errorf("GetStats() = %v, want pid 42 and version 1.6.21", stats)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	addr := newScriptedServer(t, func(line string) string {
+		switch line {
+		case "watch fetchers mutations":
+			// The stream then stays open without further events.
+			return "OK\r\nts=1 type=item_get key=a\r\nts=2 type=item_get key=b\r\n"
+		case "watch bogus":
+			return "CLIENT_ERROR bad watcher flag\r\n"
+		case "version":
+			return "VERSION 1.6.21\r\n"
+		}
+		return ""
+	})
+	c := newTestClient(t, []string{addr})
+	server := c.servers[0]
+	classes := []string{"fetchers", "mutations"}
+
+	var lines []string
+	err := server.Watch(context.Background(), classes, func(line string) bool {
+		lines = append(lines, line)
+		return false
+	})
+	if err != nil || len(lines) != 1 || lines[0] != "ts=1 type=item_get key=a" {
+		t.Errorf("Watch() = %q, %v when fn stops the stream, want the first event and nil", lines, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	lines = nil
+	err = server.Watch(ctx, classes, func(line string) bool {
+		lines = append(lines, line)
+		return true
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || len(lines) != 2 {
+		t.Errorf("Watch() = %q, %v after the context expired, want both events and context.DeadlineExceeded", lines, err)
+	}
+
+	if err = server.Watch(context.Background(), []string{"bogus"}, func(string) bool { return true }); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("Watch() error = %v for a rejected watch, want ErrUnexpectedResponse", err)
+	}
+	// The streams used dedicated connections, so the pooled one still answers commands.
+	if _, err = server.Ping(time.Second); err != nil {
+		t.Errorf("Ping() error = %v after Watch", err)
+	}
+}
+
+func TestCloseClosesEveryPooledConnection(t *testing.T) {
+	fakes := []*fakeServer{newFakeServer(t), newFakeServer(t)}
+	c := newTestClient(t, []string{fakes[0].addr(), fakes[1].addr()}, WithPool(4))
+	// Hold every slot at once, so that each pool dials all of its connections.
+	for _, server := range c.servers {
+		var conns []*Conn
+		for range 4 {
+			conn, err := server.acquire()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = conn.connect(); err != nil {
+				t.Fatal(err)
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			server.pool.put(conn)
+		}
+	}
+	// The fake servers count connections as they accept them.
+	waitOpen := func(s *fakeServer, want int64) int64 {
+		for deadline := time.Now().Add(time.Second); s.open.Load() != want && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		return s.open.Load()
+	}
+	for _, s := range fakes {
+		if open := waitOpen(s, 4); open != 4 {
+			t.Fatalf("%d connections to %s open, want 4", open, s.addr())
+		}
+	}
+	servers := c.servers
+	for range 2 {
+		// Closing twice is a no-op.
+		if err := c.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+	for _, s := range fakes {
+		if open := waitOpen(s, 0); open != 0 {
+			t.Errorf("%d connections to %s still open after Close", open, s.addr())
+		}
+	}
+	for _, server := range servers {
+		server.Close()
+		if _, _, err := server.GetValue("key", false); !errors.Is(err, ErrClosed) {
+			t.Errorf("GetValue() error = %v after Close, want ErrClosed", err)
+		}
+	}
+	if _, err := c.Get("key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get() error = %v after Close, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,105 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// itemHeaderSize is the size of the item header of 64-bit memcached builds, which the smallest slab class adds to chunk_size.
+const itemHeaderSize = 48
+
+// slabChunkAlign is the alignment of slab chunk sizes.
+const slabChunkAlign = 8
+
+// maxSlabClasses is the maximum number of slab classes of memcached.
+const maxSlabClasses = 63
+
+// slabClasses computes the chunk sizes of the slab classes the way memcached does on startup:
+// the first class holds an item header plus chunkSize bytes, every following class is factor times larger,
+// aligned to 8 bytes, and the last class holds chunkMax bytes. The size of class i is at index i-1.
+func slabClasses(chunkSize int, factor float64, chunkMax int) (sizes []int) {
+	size := itemHeaderSize + chunkSize
+	for len(sizes) < maxSlabClasses-1 && float64(size) < float64(chunkMax)/factor {
+		if size%slabChunkAlign != 0 {
+			size += slabChunkAlign - size%slabChunkAlign
+		}
+		sizes = append(sizes, size)
+		size = int(float64(size) * factor)
+	}
+	return append(sizes, chunkMax)
+}
+
+// SlabClassFor returns the slab class, and its chunk size, that an item of the given size would be stored in
+// on the memcached server identified by the given address.
+// The size is the total size of the item, that is the value plus the key plus about 50 bytes of item header.
+// The classes are computed from growth_factor, chunk_size and slab_chunk_max (or item_size_max) of the settings stats,
+// and the chunk sizes reported by "stats slabs" take precedence for the classes already in use.
+// Items larger than the largest class are split into chunks of that class; larger than item_size_max, they cannot be stored
+// and ErrItemTooLarge is returned.
+func (c *Client) SlabClassFor(addr string, size int) (classID, chunkSize int, err error) {
+	defer c.hookServer("SlabClassFor", addr, &err)()
+	if size <= 0 {
+		err = ErrInvalidOption
+		return
+	}
+	server, _, err := c.pickServerFromAddr(addr)
+	if err != nil {
+		return
+	}
+	settings, err := server.GetStatsGroup("settings")
+	if err != nil {
+		return
+	}
+	factor, err := strconv.ParseFloat(settings["growth_factor"], 64)
+	if err != nil || factor <= 1 {
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("growth_factor: %q", settings["growth_factor"]))
+		return
+	}
+	minChunk, err := intStat(settings, "chunk_size")
+	if err != nil {
+		return
+	}
+	itemSizeMax, err := intStat(settings, "item_size_max")
+	if err != nil {
+		return
+	}
+	if size > itemSizeMax {
+		err = ErrItemTooLarge
+		return
+	}
+	// Servers before 1.4.30 have no slab_chunk_max, and their largest class is item_size_max.
+	chunkMax := itemSizeMax
+	if _, ok := settings["slab_chunk_max"]; ok {
+		if chunkMax, err = intStat(settings, "slab_chunk_max"); err != nil {
+			return
+		}
+	}
+	sizes := slabClasses(minChunk, factor, chunkMax)
+
+	slabs, err := server.GetStatsGroup("slabs")
+	if err != nil {
+		return
+	}
+	for name, value := range slabs {
+		// STAT <class>:chunk_size <bytes>
+		id, field, ok := strings.Cut(name, ":")
+		if !ok || field != "chunk_size" {
+			continue
+		}
+		class, perr := strconv.Atoi(id)
+		n, verr := strconv.Atoi(value)
+		if perr != nil || verr != nil || class < 1 || class > len(sizes) {
+			continue
+		}
+		sizes[class-1] = n
+	}
+
+	for i, n := range sizes {
+		if size <= n {
+			return i + 1, n, nil
+		}
+	}
+	return len(sizes), sizes[len(sizes)-1], nil
+}
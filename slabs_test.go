@@ -0,0 +1,62 @@
+package memcache
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSlabClassesMatchMemcached(t *testing.T) {
+	// The classes memcached 1.6 prints with -vv for the default settings.
+	sizes := slabClasses(48, 1.25, 512*1024)
+	if want := []int{96, 120, 152, 192, 240, 304, 384, 480, 600, 752}; !slices.Equal(sizes[:len(want)], want) {
+		t.Errorf("slabClasses() starts with %v, want %v", sizes[:len(want)], want)
+	}
+	if last := sizes[len(sizes)-1]; last != 512*1024 {
+		t.Errorf("the last class holds %d bytes, want slab_chunk_max", last)
+	}
+}
+
+func TestSlabClassFor(t *testing.T) {
+	addr := newScriptedServer(t, func(line string) string {
+		switch line {
+		case "stats settings":
+			return "STAT growth_factor 1.25\r\nSTAT chunk_size 48\r\nSTAT item_size_max 1048576\r\nSTAT slab_chunk_max 524288\r\nEND\r\n"
+		case "stats slabs":
+			// The reported chunk size of a class in use takes precedence over the computed one.
+			return "STAT 3:chunk_size 160\r\nSTAT active_slabs 1\r\nEND\r\n"
+		}
+		return "ERROR\r\n"
+	})
+	c := newTestClient(t, []string{addr})
+	tests := []struct {
+		size      int
+		class     int
+		chunkSize int
+	}{
+		{1, 1, 96},
+		{100, 2, 120},
+		{121, 3, 160},
+		// Items larger than the largest class are chunked in that class.
+		{600 * 1024, 0, 512 * 1024},
+	}
+	for _, tt := range tests {
+		class, chunkSize, err := c.SlabClassFor(addr, tt.size)
+		if err != nil || (tt.class != 0 && class != tt.class) || chunkSize != tt.chunkSize {
+			t.Errorf("SlabClassFor(%d) = %d, %d, %v; want class %d of %d bytes", tt.size, class, chunkSize, err, tt.class, tt.chunkSize)
+		}
+	}
+	for size, want := range map[int]error{0: ErrInvalidOption, 2 << 20: ErrItemTooLarge} {
+		if _, _, err := c.SlabClassFor(addr, size); !errors.Is(err, want) {
+			t.Errorf("SlabClassFor(%d) error = %v, want %v", size, err, want)
+		}
+	}
+
+	addr = newScriptedServer(t, func(line string) string {
+		return "STAT chunk_size 48\r\nSTAT item_size_max 1048576\r\nEND\r\n"
+	})
+	c = newTestClient(t, []string{addr})
+	if _, _, err := c.SlabClassFor(addr, 100); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("SlabClassFor() error = %v without growth_factor, want ErrUnexpectedResponse", err)
+	}
+}
@@ -0,0 +1,275 @@
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TypedStats holds the general-purpose statistics of a memcached server parsed into Go types.
+// Fields missing from the server response keep their zero value; Raw holds every reported stat.
+type TypedStats struct {
+	PID              uint64            // Process ID of the server ("pid").
+	Uptime           time.Duration     // Time since the server started ("uptime").
+	Time             time.Time         // Current time on the server ("time").
+	Version          string            // Version of the server ("version").
+	RusageUser       float64           // Accumulated user CPU time in seconds ("rusage_user").
+	RusageSystem     float64           // Accumulated system CPU time in seconds ("rusage_system").
+	CurrConnections  uint64            // Number of open connections ("curr_connections").
+	TotalConnections uint64            // Number of connections opened since the server started ("total_connections").
+	CurrItems        uint64            // Number of items currently stored ("curr_items").
+	TotalItems       uint64            // Number of items stored since the server started ("total_items").
+	Bytes            uint64            // Number of bytes used to store items ("bytes").
+	LimitMaxBytes    uint64            // Number of bytes the server may use for storage ("limit_maxbytes").
+	CmdGet           uint64            // Number of retrieval requests ("cmd_get").
+	CmdSet           uint64            // Number of storage requests ("cmd_set").
+	GetHits          uint64            // Number of keys found ("get_hits").
+	GetMisses        uint64            // Number of keys not found ("get_misses").
+	Evictions        uint64            // Number of valid items evicted to free memory ("evictions").
+	AcceptingConns   bool              // Whether the server accepts new connections ("accepting_conns").
+	Raw              map[string]string // All stats as reported by the server.
+}
+
+// ParseStats converts the raw stats returned by Server.GetStats into TypedStats.
+// Floating-point fields such as rusage_user are parsed as seconds, and "time" as a Unix timestamp.
+// It returns ErrUnexpectedResponse joined with the parse errors if any known field is malformed.
+func ParseStats(raw map[string]string) (stats *TypedStats, err error) {
+	stats = &TypedStats{
+		Version: raw["version"],
+		Raw:     raw,
+	}
+	var errs []error
+	parseUint := func(name string, dst *uint64) {
+		v, ok := raw[name]
+		if !ok {
+			return
+		}
+		n, perr := strconv.ParseUint(v, 10, 64)
+		if perr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, perr))
+			return
+		}
+		*dst = n
+	}
+	parseFloat := func(name string, dst *float64) {
+		v, ok := raw[name]
+		if !ok {
+			return
+		}
+		f, perr := strconv.ParseFloat(v, 64)
+		if perr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, perr))
+			return
+		}
+		*dst = f
+	}
+
+	parseUint("pid", &stats.PID)
+	var uptime, now uint64
+	parseUint("uptime", &uptime)
+	stats.Uptime = time.Duration(uptime) * time.Second
+	parseUint("time", &now)
+	if _, ok := raw["time"]; ok {
+		stats.Time = time.Unix(int64(now), 0)
+	}
+	parseFloat("rusage_user", &stats.RusageUser)
+	parseFloat("rusage_system", &stats.RusageSystem)
+	parseUint("curr_connections", &stats.CurrConnections)
+	parseUint("total_connections", &stats.TotalConnections)
+	parseUint("curr_items", &stats.CurrItems)
+	parseUint("total_items", &stats.TotalItems)
+	parseUint("bytes", &stats.Bytes)
+	parseUint("limit_maxbytes", &stats.LimitMaxBytes)
+	parseUint("cmd_get", &stats.CmdGet)
+	parseUint("cmd_set", &stats.CmdSet)
+	parseUint("get_hits", &stats.GetHits)
+	parseUint("get_misses", &stats.GetMisses)
+	parseUint("evictions", &stats.Evictions)
+	if v, ok := raw["accepting_conns"]; ok {
+		var perr error
+		if stats.AcceptingConns, perr = parseStatBool(v); perr != nil {
+			errs = append(errs, fmt.Errorf("accepting_conns: %w", perr))
+		}
+	}
+
+	if len(errs) > 0 {
+		err = errors.Join(append([]error{ErrUnexpectedResponse}, errs...)...)
+	}
+	return
+}
+
+// intStat parses the integer stat of the given name.
+// It returns ErrUnexpectedResponse if the stat is missing or malformed.
+func intStat(stats map[string]string, name string) (n int, err error) {
+	v, ok := stats[name]
+	if !ok {
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("missing stat %s", name))
+		return
+	}
+	n, err = strconv.Atoi(v)
+	if err != nil {
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("%s: %w", name, err))
+		return
+	}
+	return
+}
+
+// HitRatio returns the share of retrieved keys that were found on the memcached server identified by the given address,
+// get_hits / (get_hits + get_misses) of its general stats. It returns zero if the server has not served any retrieval yet.
+func (c *Client) HitRatio(addr string) (ratio float64, err error) {
+	stats, err := c.TypedStats(addr)
+	if err != nil {
+		return
+	}
+	ratio = hitRatio(stats)
+	return
+}
+
+// HitRatioAll returns the hit ratio of every memcached server, keyed by address (see HitRatio).
+// Servers whose stats cannot be retrieved are omitted and their errors are joined.
+func (c *Client) HitRatioAll() (ratios map[string]float64, err error) {
+	defer c.hook("HitRatioAll", "", &err)()
+	if err = c.checkClosed(); err != nil {
+		return
+	}
+	ratios = make(map[string]float64)
+	for _, server := range c.snapshotServers() {
+		raw, serr := server.GetStats()
+		if serr != nil {
+			err = errors.Join(err, fmt.Errorf("%s: %w", server.Address, serr))
+			continue
+		}
+		stats, serr := ParseStats(raw)
+		if serr != nil {
+			err = errors.Join(err, fmt.Errorf("%s: %w", server.Address, serr))
+			continue
+		}
+		ratios[server.Address] = hitRatio(stats)
+	}
+	return
+}
+
+// StatsBundle retrieves several groups of statistics from the memcached server identified by the given address in one call,
+// sending one "stats <group>" command per group, e.g. StatsBundle(addr, "", "settings", "items").
+// The empty group stands for the general statistics of a plain "stats" command. The result is keyed by group.
+// Groups that fail are omitted and their errors are joined, prefixed with the group; the other groups are still returned.
+func (c *Client) StatsBundle(addr string, groups ...string) (bundle map[string]map[string]string, err error) {
+	defer c.hookServer("StatsBundle", addr, &err)()
+	server, _, err := c.pickServerFromAddr(addr)
+	if err != nil {
+		return
+	}
+	bundle = make(map[string]map[string]string, len(groups))
+	for _, group := range groups {
+		if _, done := bundle[group]; done {
+			continue
+		}
+		stats, gerr := server.GetStatsGroup(group)
+		if gerr != nil {
+			err = errors.Join(err, fmt.Errorf("stats %q: %w", group, gerr))
+			continue
+		}
+		bundle[group] = stats
+	}
+	return
+}
+
+// StatsDelta samples the general statistics of the memcached server identified by the given address twice,
+// window apart, and returns the change of every integer stat between the samples, keyed by stat name,
+// along with the time actually elapsed between them. Stats that are not integers, such as "version" or "rusage_user",
+// are omitted. Counters that were reset in between, e.g. by a restart, show a negative change.
+func (c *Client) StatsDelta(addr string, window time.Duration) (delta map[string]int64, elapsed time.Duration, err error) {
+	before, err := c.Stats(addr)
+	if err != nil {
+		return
+	}
+	start := time.Now()
+	time.Sleep(window)
+	after, err := c.Stats(addr)
+	if err != nil {
+		return
+	}
+	elapsed = time.Since(start)
+	delta = make(map[string]int64, len(after))
+	for name, value := range after {
+		curr, cerr := strconv.ParseInt(value, 10, 64)
+		prev, perr := strconv.ParseInt(before[name], 10, 64)
+		if cerr != nil || perr != nil {
+			continue
+		}
+		delta[name] = curr - prev
+	}
+	return
+}
+
+// EvictionRate returns the number of items the memcached server identified by the given address evicts per second,
+// measured over window with StatsDelta, e.g. to alert on memory pressure. A counter reset in between yields zero.
+func (c *Client) EvictionRate(addr string, window time.Duration) (rate float64, err error) {
+	delta, elapsed, err := c.StatsDelta(addr, window)
+	if err != nil {
+		return
+	}
+	evictions, ok := delta["evictions"]
+	if !ok {
+		err = errors.Join(ErrUnexpectedResponse, errors.New("missing stat evictions"))
+		return
+	}
+	if evictions <= 0 || elapsed <= 0 {
+		return
+	}
+	rate = float64(evictions) / elapsed.Seconds()
+	return
+}
+
+// hitRatio computes get_hits / (get_hits + get_misses), or zero if there were no retrievals.
+func hitRatio(stats *TypedStats) float64 {
+	total := stats.GetHits + stats.GetMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(stats.GetHits) / float64(total)
+}
+
+// parseStatBool parses a boolean stat, reported by memcached as "0" or "1".
+func parseStatBool(v string) (b bool, err error) {
+	switch v {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	err = fmt.Errorf("invalid boolean %q", v)
+	return
+}
+
+// acceptingConns reads the "accepting_conns" stat of the server, which memcached sets to 0 while it refuses
+// new connections because it reached its connection limit.
+// It returns ErrUnexpectedResponse if the stat is missing or malformed.
+func (s *Server) acceptingConns() (accepting bool, err error) {
+	stats, err := s.GetStats()
+	if err != nil {
+		return
+	}
+	v, ok := stats["accepting_conns"]
+	if !ok {
+		err = errors.Join(ErrUnexpectedResponse, errors.New("missing stat accepting_conns"))
+		return
+	}
+	if accepting, err = parseStatBool(v); err != nil {
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("accepting_conns: %w", err))
+	}
+	return
+}
+
+// AcceptingConns reports whether the memcached server identified by the given address accepts new connections,
+// from the "accepting_conns" stat. A server under connection pressure stops accepting them once it reaches maxconns.
+// It returns ErrUnexpectedResponse if the stat is missing or malformed.
+func (c *Client) AcceptingConns(addr string) (accepting bool, err error) {
+	defer c.hookServer("AcceptingConns", addr, &err)()
+	server, _, err := c.pickServerFromAddr(addr)
+	if err != nil {
+		return
+	}
+	return server.acceptingConns()
+}
@@ -0,0 +1,263 @@
+package memcache
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseStatsFloatAndTimeFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    map[string]string
+		user   float64
+		system float64
+		time   time.Time
+	}{
+		{
+			name:   "microsecond precision",
+			raw:    map[string]string{"rusage_user": "0.123456", "rusage_system": "12.500000", "time": "1700000000"},
+			user:   0.123456,
+			system: 12.5,
+			time:   time.Unix(1700000000, 0),
+		},
+		{
+			name:   "integral seconds",
+			raw:    map[string]string{"rusage_user": "3", "rusage_system": "0", "time": "0"},
+			user:   3,
+			system: 0,
+			time:   time.Unix(0, 0),
+		},
+		{
+			name: "missing fields",
+			raw:  map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := ParseStats(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.RusageUser != tt.user || stats.RusageSystem != tt.system {
+				t.Errorf("rusage = %v/%v, want %v/%v", stats.RusageUser, stats.RusageSystem, tt.user, tt.system)
+			}
+			if !stats.Time.Equal(tt.time) {
+				t.Errorf("Time = %v, want %v", stats.Time, tt.time)
+			}
+		})
+	}
+}
+
+func TestParseStatsMalformedFields(t *testing.T) {
+	for _, raw := range []map[string]string{
+		{"rusage_user": "0,5"},
+		{"rusage_system": "abc"},
+		{"time": "-1"},
+		{"time": "1700000000.5"},
+	} {
+		if _, err := ParseStats(raw); !errors.Is(err, ErrUnexpectedResponse) {
+			t.Errorf("ParseStats(%v) error = %v, want ErrUnexpectedResponse", raw, err)
+		}
+	}
+}
+
+func TestTypedStats(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	before := time.Now().Add(-time.Second)
+	stats, err := c.TypedStats(s.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.RusageUser != 0.123456 || stats.RusageSystem != 1.5 {
+		t.Errorf("rusage = %v/%v, want 0.123456/1.5", stats.RusageUser, stats.RusageSystem)
+	}
+	if stats.Time.Before(before.Truncate(time.Second)) || stats.Time.After(time.Now()) {
+		t.Errorf("Time = %v, want about now", stats.Time)
+	}
+	if stats.Uptime != 100*time.Second {
+		t.Errorf("Uptime = %v, want 100s", stats.Uptime)
+	}
+}
+
+func TestConnectionInfo(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	if current, maximum, err := c.ConnectionInfo(s.addr()); err != nil || current < 1 || maximum != 1024 {
+		t.Errorf("ConnectionInfo() = %d, %d, %v; want the open connections and maxconns 1024", current, maximum, err)
+	}
+
+	tests := []struct {
+		name     string
+		general  string
+		settings string
+	}{
+		{"no curr_connections", "STAT pid 1\r\nEND\r\n", "STAT maxconns 1024\r\nEND\r\n"},
+		{"no maxconns", "STAT curr_connections 2\r\nEND\r\n", "STAT item_size_max 1048576\r\nEND\r\n"},
+		{"malformed maxconns", "STAT curr_connections 2\r\nEND\r\n", "STAT maxconns many\r\nEND\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := newScriptedServer(t, func(line string) string {
+				switch line {
+				case "stats":
+					return tt.general
+				case "stats settings":
+					return tt.settings
+				}
+				return "ERROR\r\n"
+			})
+			c := newTestClient(t, []string{addr})
+			if _, _, err := c.ConnectionInfo(addr); !errors.Is(err, ErrUnexpectedResponse) {
+				t.Errorf("ConnectionInfo() error = %v, want ErrUnexpectedResponse", err)
+			}
+		})
+	}
+}
+
+func TestHitRatio(t *testing.T) {
+	s := newFakeServer(t)
+	idle := newScriptedServer(t, func(line string) string {
+		return "STAT get_hits 0\r\nSTAT get_misses 0\r\nEND\r\n"
+	})
+	broken := newScriptedServer(t, func(line string) string { return "SERVER_ERROR out of memory\r\n" })
+	c := newTestClient(t, []string{s.addr(), idle, broken})
+	// The fake server reports 3 hits and 1 miss.
+	if ratio, err := c.HitRatio(s.addr()); err != nil || ratio != 0.75 {
+		t.Errorf("HitRatio() = %v, %v; want 0.75", ratio, err)
+	}
+	// A server that has not served any retrieval has a ratio of zero rather than NaN.
+	if ratio, err := c.HitRatio(idle); err != nil || ratio != 0 {
+		t.Errorf("HitRatio() = %v, %v without retrievals; want 0", ratio, err)
+	}
+	if _, err := c.HitRatio(broken); err == nil {
+		t.Error("HitRatio() succeeded for a server failing its stats")
+	}
+
+	ratios, err := c.HitRatioAll()
+	if err == nil || !strings.Contains(err.Error(), broken) {
+		t.Errorf("HitRatioAll() error = %v, want the error of %s", err, broken)
+	}
+	if len(ratios) != 2 || ratios[s.addr()] != 0.75 || ratios[idle] != 0 {
+		t.Errorf("HitRatioAll() = %v, want the ratios of the two working servers", ratios)
+	}
+}
+
+func TestAcceptingConns(t *testing.T) {
+	if _, err := ParseStats(map[string]string{"accepting_conns": "yes"}); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("ParseStats() error = %v for a malformed accepting_conns, want ErrUnexpectedResponse", err)
+	}
+	if stats, err := ParseStats(map[string]string{"accepting_conns": "1"}); err != nil || !stats.AcceptingConns {
+		t.Errorf("ParseStats() AcceptingConns = %v, %v; want true", stats.AcceptingConns, err)
+	}
+
+	tests := []struct {
+		name  string
+		stats string
+		want  bool
+		err   error
+	}{
+		{"accepting", "STAT accepting_conns 1\r\nEND\r\n", true, nil},
+		{"refusing", "STAT accepting_conns 0\r\nEND\r\n", false, nil},
+		{"missing", "STAT pid 1\r\nEND\r\n", false, ErrUnexpectedResponse},
+		{"malformed", "STAT accepting_conns 2\r\nEND\r\n", false, ErrUnexpectedResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := newScriptedServer(t, func(line string) string { return tt.stats })
+			c := newTestClient(t, []string{addr})
+			if accepting, err := c.AcceptingConns(addr); accepting != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("AcceptingConns() = %v, %v; want %v, %v", accepting, err, tt.want, tt.err)
+			}
+		})
+	}
+
+	c := newTestClient(t, []string{newFakeServer(t).addr()})
+	if _, err := c.AcceptingConns("127.0.0.1:1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AcceptingConns() error = %v for an unknown server, want ErrNotFound", err)
+	}
+}
+
+func TestStatsBundle(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	addr := newScriptedServer(t, func(line string) string {
+		mu.Lock()
+		sent = append(sent, line)
+		mu.Unlock()
+		switch line {
+		case "stats":
+			return "STAT pid 1\r\nEND\r\n"
+		case "stats settings":
+			return "STAT maxconns 1024\r\nEND\r\n"
+		}
+		return "SERVER_ERROR out of memory\r\n"
+	})
+	c := newTestClient(t, []string{addr})
+	bundle, err := c.StatsBundle(addr, "", "items", "settings", "")
+	// The failing group is reported without failing the others.
+	if err == nil || !strings.Contains(err.Error(), `"items"`) {
+		t.Errorf("StatsBundle() error = %v, want the failure of the items group", err)
+	}
+	if len(bundle) != 2 || bundle[""]["pid"] != "1" || bundle["settings"]["maxconns"] != "1024" {
+		t.Errorf("StatsBundle() = %v, want the general and settings groups", bundle)
+	}
+	if _, ok := bundle["items"]; ok {
+		t.Error("StatsBundle() returned the failed items group")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"stats", "stats items", "stats settings"}; !slices.Equal(sent, want) {
+		t.Errorf("sent %q, want every group requested once: %q", sent, want)
+	}
+
+	if _, err := c.StatsBundle("127.0.0.1:1", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("StatsBundle() error = %v for an unknown server, want ErrNotFound", err)
+	}
+}
+
+func TestEvictionRate(t *testing.T) {
+	// sampled returns a server answering successive "stats" commands with the given replies.
+	sampled := func(replies ...string) string {
+		var n atomic.Int64
+		return newScriptedServer(t, func(line string) string {
+			if i := int(n.Add(1)) - 1; i < len(replies) {
+				return replies[i]
+			}
+			return "SERVER_ERROR out of memory\r\n"
+		})
+	}
+	const window = 100 * time.Millisecond
+	growing := sampled("STAT evictions 100\r\nSTAT version 1.6.21\r\nEND\r\n", "STAT evictions 150\r\nSTAT version 1.6.21\r\nEND\r\n")
+	reset := sampled("STAT evictions 150\r\nEND\r\n", "STAT evictions 10\r\nEND\r\n")
+	missing := sampled("STAT pid 1\r\nEND\r\n", "STAT pid 1\r\nEND\r\n")
+	failing := sampled("STAT evictions 100\r\nEND\r\n")
+	c := newTestClient(t, []string{growing, reset, missing, failing})
+
+	// 50 evictions over at least the window is at most 500 per second.
+	if rate, err := c.EvictionRate(growing, window); err != nil || rate <= 250 || rate > 500 {
+		t.Errorf("EvictionRate() = %v, %v; want about 500 per second", rate, err)
+	}
+	if rate, err := c.EvictionRate(reset, window); err != nil || rate != 0 {
+		t.Errorf("EvictionRate() = %v, %v after a counter reset; want 0", rate, err)
+	}
+	if _, err := c.EvictionRate(missing, window); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Errorf("EvictionRate() error = %v without an evictions stat, want ErrUnexpectedResponse", err)
+	}
+	if _, err := c.EvictionRate(failing, window); err == nil {
+		t.Error("EvictionRate() succeeded when the second sample failed")
+	}
+
+	// StatsDelta shows the reset as a negative change, and omits stats that are not integers.
+	reset = sampled("STAT evictions 150\r\nSTAT version 1.6.21\r\nEND\r\n", "STAT evictions 10\r\nSTAT version 1.6.21\r\nEND\r\n")
+	c = newTestClient(t, []string{reset})
+	delta, elapsed, err := c.StatsDelta(reset, window)
+	if err != nil || elapsed < window || len(delta) != 1 || delta["evictions"] != -140 {
+		t.Errorf("StatsDelta() = %v, %v, %v; want evictions -140 over at least %v", delta, elapsed, err, window)
+	}
+}
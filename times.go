@@ -0,0 +1,37 @@
+package memcache
+
+import (
+	"errors"
+	"time"
+)
+
+// SetTime stores t under the given key, formatted in UTC with time.RFC3339Nano and marked with FlagTime,
+// so that every caller, and clients in other languages, read the same value back.
+// The monotonic clock reading is dropped and only the wall time is kept, to the nanosecond.
+// It returns an error if the store operation is not acknowledged.
+func (c *Client) SetTime(key string, t time.Time, expiration int) (err error) {
+	defer c.hook("SetTime", key, &err)()
+	return c.setEncoded(key, t.UTC().AppendFormat(nil, time.RFC3339Nano), FlagTime, expiration)
+}
+
+// GetTime retrieves the time stored with SetTime under the given key, in UTC.
+// It returns ErrNotFound if the key does not exist, ErrUnknownFlags if the value was not stored with FlagTime,
+// and ErrDecodeFailed if it cannot be parsed.
+func (c *Client) GetTime(key string) (t time.Time, err error) {
+	defer c.hook("GetTime", key, &err)()
+	data, flags, err := c.getEncoded(key)
+	if err != nil {
+		return
+	}
+	if flags != FlagTime {
+		err = ErrUnknownFlags
+		return
+	}
+	t, err = time.Parse(time.RFC3339Nano, string(data))
+	if err != nil {
+		err = errors.Join(ErrDecodeFailed, err)
+		return
+	}
+	t = t.UTC()
+	return
+}
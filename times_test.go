@@ -0,0 +1,39 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetTimeGetTime(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	// time.Now carries a monotonic reading and a local location, neither of which is stored.
+	now := time.Now().In(time.FixedZone("UTC+9", 9*60*60))
+	if err := c.SetTime("now", now, 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, flags, _ := s.stored("now"); value != now.UTC().Format(time.RFC3339Nano) || flags != FlagTime {
+		t.Errorf("SetTime() stored %q with flags %d, want RFC 3339 in UTC with FlagTime", value, flags)
+	}
+	got, err := c.GetTime("now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != now.Round(0).UTC() {
+		t.Errorf("GetTime() = %v, want %v in UTC without a monotonic reading", got, now.Round(0).UTC())
+	}
+
+	if err := c.Set("plain", now.Format(time.RFC3339Nano), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetWithFlags("corrupt", "yesterday", FlagTime, 0); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]error{"plain": ErrUnknownFlags, "corrupt": ErrDecodeFailed, "missing": ErrNotFound} {
+		if _, err := c.GetTime(key); !errors.Is(err, want) {
+			t.Errorf("GetTime(%q) error = %v, want %v", key, err, want)
+		}
+	}
+}
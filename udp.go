@@ -0,0 +1,179 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// udpHeaderLength is the length of the frame header preceding every UDP datagram.
+const udpHeaderLength = 8
+
+// UDPHeader is the frame header of a memcached UDP datagram.
+type UDPHeader struct {
+	RequestID uint16 // Identifies the request a datagram belongs to; responses echo the ID of their request.
+	Sequence  uint16 // Index of the datagram within the message, starting at 0.
+	Total     uint16 // Number of datagrams in the message.
+}
+
+// NewUDPRequest frames a text protocol command as a single-datagram UDP request with the given request ID.
+func NewUDPRequest(requestID uint16, cmd []byte) (datagram []byte) {
+	datagram = make([]byte, udpHeaderLength, udpHeaderLength+len(cmd))
+	binary.BigEndian.PutUint16(datagram[0:], requestID)
+	binary.BigEndian.PutUint16(datagram[2:], 0)
+	binary.BigEndian.PutUint16(datagram[4:], 1)
+	// datagram[6:8] is reserved and must be zero.
+	return append(datagram, cmd...)
+}
+
+// ParseUDPDatagram splits a UDP datagram into its frame header and payload.
+// It returns ErrUnexpectedResponse if the datagram is too short or its sequence number is out of range.
+func ParseUDPDatagram(datagram []byte) (header UDPHeader, payload []byte, err error) {
+	if len(datagram) < udpHeaderLength {
+		err = ErrUnexpectedResponse
+		return
+	}
+	header = UDPHeader{
+		RequestID: binary.BigEndian.Uint16(datagram[0:]),
+		Sequence:  binary.BigEndian.Uint16(datagram[2:]),
+		Total:     binary.BigEndian.Uint16(datagram[4:]),
+	}
+	if header.Total == 0 || header.Sequence >= header.Total {
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("datagram %d of %d", header.Sequence, header.Total))
+		return
+	}
+	payload = datagram[udpHeaderLength:]
+	return
+}
+
+// Limits of a UDPReassembler. The frame headers come from the network, so they are not trusted to size the buffers.
+// Memcached fills datagrams with at most 1400 bytes, so maxUDPDatagrams allows responses of several megabytes.
+const (
+	maxUDPDatagrams = 4096     // Number of datagrams of a message.
+	maxUDPPending   = 256      // Number of messages buffered at once.
+	maxUDPBuffered  = 32 << 20 // Payload bytes buffered across all messages.
+)
+
+// udpMessage is a message whose datagrams are being collected.
+type udpMessage struct {
+	parts    [][]byte // Payloads indexed by sequence number; nil until received.
+	received int      // Number of distinct datagrams received.
+	size     int      // Total payload length received.
+	started  uint64   // Order in which the first datagram of the message arrived, to evict the oldest message first.
+}
+
+// UDPReassembler reassembles responses that span several UDP datagrams, such as stats or multi-get responses.
+// Datagrams may arrive in any order; they are buffered per request ID until every datagram of the response arrived.
+// At most 256 responses of at most 4096 datagrams are buffered, with 32 MiB of payload in total;
+// the oldest incomplete responses are discarded to make room for new ones. A UDPReassembler is safe for concurrent use.
+type UDPReassembler struct {
+	mu       sync.Mutex
+	messages map[uint16]*udpMessage
+	buffered int    // Payload bytes buffered across all messages.
+	started  uint64 // Number of messages started so far.
+}
+
+// NewUDPReassembler creates an empty UDPReassembler.
+func NewUDPReassembler() *UDPReassembler {
+	return &UDPReassembler{
+		messages: make(map[uint16]*udpMessage),
+	}
+}
+
+// Add buffers a received datagram.
+// Once the last missing datagram of a response arrives, it returns the payloads concatenated in sequence order with complete set,
+// and forgets the response. Duplicate datagrams are ignored.
+// It returns ErrUnexpectedResponse if the datagram is malformed, disagrees with earlier datagrams on the number of datagrams,
+// or belongs to a response larger than a UDPReassembler buffers, which is then discarded.
+func (r *UDPReassembler) Add(datagram []byte) (requestID uint16, response []byte, complete bool, err error) {
+	header, payload, err := ParseUDPDatagram(datagram)
+	if err != nil {
+		return
+	}
+	requestID = header.RequestID
+	if header.Total > maxUDPDatagrams {
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("request %d: %d datagrams", requestID, header.Total))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, ok := r.messages[requestID]
+	if !ok {
+		if len(r.messages) >= maxUDPPending {
+			r.evictOldest(nil)
+		}
+		r.started++
+		msg = &udpMessage{parts: make([][]byte, header.Total), started: r.started}
+		r.messages[requestID] = msg
+	}
+	if len(msg.parts) != int(header.Total) {
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("request %d: datagram count changed from %d to %d", requestID, len(msg.parts), header.Total))
+		return
+	}
+	if msg.parts[header.Sequence] != nil {
+		return
+	}
+	if msg.size+len(payload) > maxUDPBuffered {
+		r.remove(requestID)
+		err = errors.Join(ErrUnexpectedResponse, fmt.Errorf("request %d: response larger than %d bytes", requestID, maxUDPBuffered))
+		return
+	}
+	// The message itself fits, so evicting the others always makes room for the payload.
+	for r.buffered+len(payload) > maxUDPBuffered {
+		r.evictOldest(msg)
+	}
+	// The payload is copied, since callers usually reuse their receive buffer.
+	msg.parts[header.Sequence] = append(make([]byte, 0, len(payload)), payload...)
+	msg.received++
+	msg.size += len(payload)
+	r.buffered += len(payload)
+	if msg.received < len(msg.parts) {
+		return
+	}
+	r.remove(requestID)
+	response = make([]byte, 0, msg.size)
+	for _, part := range msg.parts {
+		response = append(response, part...)
+	}
+	complete = true
+	return
+}
+
+// Discard forgets the datagrams buffered for the given request ID, e.g. after the response timed out
+// because a datagram was lost. UDP gives no delivery guarantee, so incomplete responses must be discarded by the caller.
+func (r *UDPReassembler) Discard(requestID uint16) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(requestID)
+}
+
+// remove forgets the datagrams buffered for the given request ID. The mutex must be held.
+func (r *UDPReassembler) remove(requestID uint16) {
+	if msg, ok := r.messages[requestID]; ok {
+		r.buffered -= msg.size
+		delete(r.messages, requestID)
+	}
+}
+
+// evictOldest discards the message other than keep whose first datagram arrived the longest ago. The mutex must be held.
+func (r *UDPReassembler) evictOldest(keep *udpMessage) {
+	var oldest *udpMessage
+	var oldestID uint16
+	for id, msg := range r.messages {
+		if msg != keep && (oldest == nil || msg.started < oldest.started) {
+			oldest, oldestID = msg, id
+		}
+	}
+	if oldest != nil {
+		r.remove(oldestID)
+	}
+}
+
+// Pending returns the number of responses that are still missing datagrams.
+func (r *UDPReassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.messages)
+}
@@ -0,0 +1,218 @@
+package memcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// udpDatagram frames a payload as datagram sequence of total of the given request.
+func udpDatagram(requestID, sequence, total uint16, payload string) []byte {
+	datagram := make([]byte, udpHeaderLength, udpHeaderLength+len(payload))
+	binary.BigEndian.PutUint16(datagram[0:], requestID)
+	binary.BigEndian.PutUint16(datagram[2:], sequence)
+	binary.BigEndian.PutUint16(datagram[4:], total)
+	return append(datagram, payload...)
+}
+
+func TestNewUDPRequest(t *testing.T) {
+	datagram := NewUDPRequest(0xBEEF, []byte("stats\r\n"))
+	if want := []byte("\xBE\xEF\x00\x00\x00\x01\x00\x00stats\r\n"); !bytes.Equal(datagram, want) {
+		t.Fatalf("NewUDPRequest() = %q, want %q", datagram, want)
+	}
+	header, payload, err := ParseUDPDatagram(datagram)
+	if err != nil || header != (UDPHeader{RequestID: 0xBEEF, Sequence: 0, Total: 1}) || string(payload) != "stats\r\n" {
+		t.Errorf("ParseUDPDatagram() = %+v, %q, %v", header, payload, err)
+	}
+}
+
+func TestParseUDPDatagramMalformed(t *testing.T) {
+	for _, datagram := range [][]byte{
+		nil,
+		[]byte("\x00\x01\x00\x00\x00"),
+		udpDatagram(1, 0, 0, "payload"),
+		udpDatagram(1, 2, 2, "payload"),
+		udpDatagram(1, 0xFFFF, 1, "payload"),
+	} {
+		if _, _, err := ParseUDPDatagram(datagram); !errors.Is(err, ErrUnexpectedResponse) {
+			t.Errorf("ParseUDPDatagram(%q) error = %v, want ErrUnexpectedResponse", datagram, err)
+		}
+	}
+	// A header without a payload is a valid, empty datagram.
+	if _, payload, err := ParseUDPDatagram(udpDatagram(1, 0, 1, "")); err != nil || len(payload) != 0 {
+		t.Errorf("ParseUDPDatagram() = %q, %v for an empty payload", payload, err)
+	}
+}
+
+func TestUDPReassemblerOutOfOrder(t *testing.T) {
+	r := NewUDPReassembler()
+	for i, datagram := range [][]byte{
+		udpDatagram(7, 2, 3, "END\r\n"),
+		udpDatagram(8, 0, 1, "STAT pid 2\r\nEND\r\n"),
+		udpDatagram(7, 0, 3, "STAT pid 1\r\n"),
+		udpDatagram(7, 1, 3, "STAT uptime 10\r\n"),
+	} {
+		id, response, complete, err := r.Add(datagram)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch i {
+		case 0, 2:
+			if complete || id != 7 || r.Pending() != 1 {
+				t.Errorf("Add() = %d, %q, %v with %d pending; want request 7 to miss datagrams", id, response, complete, r.Pending())
+			}
+		case 1:
+			if !complete || id != 8 || string(response) != "STAT pid 2\r\nEND\r\n" {
+				t.Errorf("Add() = %d, %q, %v; want the single-datagram response of request 8", id, response, complete)
+			}
+		case 3:
+			if want := "STAT pid 1\r\nSTAT uptime 10\r\nEND\r\n"; !complete || id != 7 || string(response) != want {
+				t.Errorf("Add() = %d, %q, %v; want %q", id, response, complete, want)
+			}
+		}
+	}
+	if r.Pending() != 0 {
+		t.Errorf("Pending() = %d after every response completed, want 0", r.Pending())
+	}
+}
+
+func TestUDPReassemblerDuplicates(t *testing.T) {
+	r := NewUDPReassembler()
+	buf := udpDatagram(1, 0, 2, "first ")
+	if _, _, complete, err := r.Add(buf); err != nil || complete {
+		t.Fatalf("Add() = %v, %v", complete, err)
+	}
+	// The payload was copied, so reusing the receive buffer does not change it.
+	copy(buf[udpHeaderLength:], "xxxxxx")
+	if _, _, complete, err := r.Add(udpDatagram(1, 0, 2, "again ")); err != nil || complete {
+		t.Fatalf("Add() = %v, %v for a duplicate datagram; want it ignored", complete, err)
+	}
+	_, response, complete, err := r.Add(udpDatagram(1, 1, 2, "second"))
+	if err != nil || !complete || string(response) != "first second" {
+		t.Errorf("Add() = %q, %v, %v; want %q", response, complete, err, "first second")
+	}
+}
+
+func TestUDPReassemblerTotalChanged(t *testing.T) {
+	r := NewUDPReassembler()
+	if _, _, _, err := r.Add(udpDatagram(1, 0, 3, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, complete, err := r.Add(udpDatagram(1, 1, 2, "b")); !errors.Is(err, ErrUnexpectedResponse) || complete {
+		t.Fatalf("Add() = %v, %v for a different datagram count; want ErrUnexpectedResponse", complete, err)
+	}
+	// The datagrams agreeing with the first one still complete the response.
+	r.Add(udpDatagram(1, 1, 3, "b"))
+	if _, response, complete, err := r.Add(udpDatagram(1, 2, 3, "c")); err != nil || !complete || string(response) != "abc" {
+		t.Errorf("Add() = %q, %v, %v; want %q", response, complete, err, "abc")
+	}
+}
+
+func TestUDPReassemblerMalformed(t *testing.T) {
+	r := NewUDPReassembler()
+	for _, datagram := range [][]byte{
+		[]byte("\x00\x01"),
+		udpDatagram(1, 3, 3, "payload"),
+		udpDatagram(1, 0, maxUDPDatagrams+1, "payload"),
+	} {
+		if _, _, complete, err := r.Add(datagram); !errors.Is(err, ErrUnexpectedResponse) || complete {
+			t.Errorf("Add(%q) = %v, %v; want ErrUnexpectedResponse", datagram, complete, err)
+		}
+	}
+	if r.Pending() != 0 {
+		t.Errorf("Pending() = %d after malformed datagrams, want 0", r.Pending())
+	}
+}
+
+func TestUDPReassemblerDiscard(t *testing.T) {
+	r := NewUDPReassembler()
+	r.Add(udpDatagram(1, 0, 2, "a"))
+	r.Add(udpDatagram(2, 0, 2, "b"))
+	if r.Pending() != 2 {
+		t.Fatalf("Pending() = %d, want 2", r.Pending())
+	}
+	r.Discard(1)
+	r.Discard(3)
+	if r.Pending() != 1 {
+		t.Fatalf("Pending() = %d after Discard, want 1", r.Pending())
+	}
+	// The discarded response starts over, so its last datagram alone does not complete it.
+	if _, _, complete, _ := r.Add(udpDatagram(1, 1, 2, "a")); complete {
+		t.Error("a discarded response completed")
+	}
+	if _, response, complete, _ := r.Add(udpDatagram(2, 1, 2, "b")); !complete || string(response) != "bb" {
+		t.Errorf("Add() = %q, %v; want %q", response, complete, "bb")
+	}
+}
+
+func TestUDPReassemblerPendingLimit(t *testing.T) {
+	r := NewUDPReassembler()
+	for id := range uint16(maxUDPPending + 10) {
+		if _, _, _, err := r.Add(udpDatagram(id, 0, 2, "part ")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.Pending() != maxUDPPending {
+		t.Fatalf("Pending() = %d, want %d", r.Pending(), maxUDPPending)
+	}
+	// The oldest responses were evicted, and the newest are still buffered.
+	if _, _, complete, _ := r.Add(udpDatagram(0, 1, 2, "end")); complete {
+		t.Error("an evicted response completed")
+	}
+	if _, response, complete, _ := r.Add(udpDatagram(maxUDPPending+9, 1, 2, "end")); !complete || string(response) != "part end" {
+		t.Errorf("Add() = %q, %v; want %q", response, complete, "part end")
+	}
+}
+
+func TestUDPReassemblerBufferedLimit(t *testing.T) {
+	r := NewUDPReassembler()
+	part := string(make([]byte, 1<<20))
+	// A response that does not fit is discarded as a whole.
+	var err error
+	for seq := uint16(0); err == nil; seq++ {
+		_, _, _, err = r.Add(udpDatagram(1, seq, 64, part))
+	}
+	if !errors.Is(err, ErrUnexpectedResponse) || r.Pending() != 0 {
+		t.Fatalf("Add() error = %v with %d pending for an oversized response, want ErrUnexpectedResponse", err, r.Pending())
+	}
+	// Responses that fit one at a time evict the oldest ones.
+	for id := range uint16(maxUDPBuffered>>20 + 8) {
+		if _, _, _, err = r.Add(udpDatagram(id, 0, 2, part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := r.Pending(); got != maxUDPBuffered>>20 {
+		t.Errorf("Pending() = %d, want %d", got, maxUDPBuffered>>20)
+	}
+	if r.buffered > maxUDPBuffered {
+		t.Errorf("%d bytes buffered, want at most %d", r.buffered, maxUDPBuffered)
+	}
+}
+
+func TestUDPReassemblerConcurrent(t *testing.T) {
+	r := NewUDPReassembler()
+	var wg sync.WaitGroup
+	for id := range uint16(16) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := range uint16(4) {
+				_, response, complete, err := r.Add(udpDatagram(id, 3-seq, 4, fmt.Sprint(3-seq)))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if complete != (seq == 3) || (complete && string(response) != "0123") {
+					t.Errorf("Add() = %q, %v for request %d", response, complete, id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if r.Pending() != 0 {
+		t.Errorf("Pending() = %d, want 0", r.Pending())
+	}
+}
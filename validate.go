@@ -0,0 +1,40 @@
+package memcache
+
+// Validate checks the given items the way Set and the other store methods would before sending them, without any network I/O,
+// e.g. to reject bad input early in a request handler. errs holds the error of every item at the same index, nil for valid items.
+//
+// An item fails with ErrInvalidKey if its key, namespaced with WithNamespace, cannot be sent, with ErrItemTooLarge if its value
+// exceeds the item size limit of the server its key maps to and would not be split by WithChunking or WithAutoChunking,
+// and with the routing error, e.g. ErrNoServers or ErrClosed, if its key cannot be mapped to a server.
+// The size limit is the item_size_max setting once it was read from the server, and the memcached default of 1 MiB before.
+// Since no round trip is made, the version token of the namespace may have changed, and a valid item can still be rejected
+// by the server, e.g. when it runs out of memory.
+func (c *Client) Validate(items []*Item) (errs []error) {
+	errs = make([]error, len(items))
+	for i, item := range items {
+		errs[i] = c.validateItem(item)
+	}
+	return
+}
+
+// validateItem checks a single item for Validate.
+func (c *Client) validateItem(item *Item) (err error) {
+	// A nil item has no key to store it under.
+	if item == nil {
+		err = ErrInvalidKey
+		return
+	}
+	key := c.cachedNamespacedKey(item.Key)
+	servers, err := c.pickServers(key, 1)
+	if err != nil {
+		return
+	}
+	// Values split into chunks are not limited by the size of a single item.
+	if c.autoChunking || (c.chunkSize > 0 && len(item.Value) > c.chunkSize) {
+		return
+	}
+	if len(item.Value) > valueSizeLimit(servers[0].knownItemSizeLimit(), key) {
+		err = ErrItemTooLarge
+	}
+	return
+}
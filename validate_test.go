@@ -0,0 +1,47 @@
+package memcache
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, []string{s.addr()})
+	large := make([]byte, defaultItemSizeMax)
+	items := []*Item{
+		{Key: "valid", Value: []byte("value")},
+		{Key: "with space", Value: []byte("value")},
+		{Key: strings.Repeat("k", 251), Value: []byte("value")},
+		{Key: "large", Value: large},
+		nil,
+	}
+	errs := c.Validate(items)
+	for i, want := range []error{nil, ErrInvalidKey, ErrInvalidKey, ErrItemTooLarge, ErrInvalidKey} {
+		if !errors.Is(errs[i], want) || (want == nil && errs[i] != nil) {
+			t.Errorf("Validate() error %d = %v, want %v", i, errs[i], want)
+		}
+	}
+	if received := s.received(); len(received) > 0 {
+		t.Errorf("Validate() sent %q, want no network I/O", received)
+	}
+
+	// The namespace counts against the key length.
+	c = newTestClient(t, []string{s.addr()}, WithNamespace("tenant", time.Minute))
+	if errs := c.Validate([]*Item{{Key: strings.Repeat("k", 240)}}); !errors.Is(errs[0], ErrInvalidKey) {
+		t.Errorf("Validate() error = %v for a key too long once namespaced, want ErrInvalidKey", errs[0])
+	}
+
+	// Values split into chunks are not limited by the item size.
+	c = newTestClient(t, []string{s.addr()}, WithChunking(64<<10))
+	if errs := c.Validate([]*Item{{Key: "large", Value: large}}); errs[0] != nil {
+		t.Errorf("Validate() error = %v for a value that would be chunked, want nil", errs[0])
+	}
+
+	c.Close()
+	if errs := c.Validate([]*Item{{Key: "valid"}}); !errors.Is(errs[0], ErrClosed) {
+		t.Errorf("Validate() error = %v after Close, want ErrClosed", errs[0])
+	}
+}